@@ -1,446 +1,1046 @@
 package main
 
 import (
+	"bufio"
+	"crypto/tls"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/raymond1/generate_certificates/pki"
 )
 
-var stringFragments = make(map[string]string)
+//Exit statuses, so that scripts can tell what went wrong. exitFailure is also used when verify, renew or trust finds a problem.
+const (
+	exitFailure        = 1
+	exitUsage          = 2
+	exitOpensslMissing = 3
+	exitTemplate       = 4
+	exitSigning        = 5
+	exitIO             = 6
+)
 
-//Takes in a string and runs the command in a shell
-func runCommand(command string) error {
-	executableCommand := convertStringIntoExecCommand(command)
-	fmt.Println("runCommand:")
-	fmt.Println(command)
-	return executableCommand.Run()
+var exitStatuses = map[pki.ErrorKind]int{
+	pki.InvalidError:        exitUsage,
+	pki.OpensslMissingError: exitOpensslMissing,
+	pki.TemplateError:       exitTemplate,
+	pki.SigningError:        exitSigning,
+	pki.IOError:             exitIO,
 }
 
-//Takes in a string and produces Cmd object that can be run
-func convertStringIntoExecCommand(command string) *exec.Cmd {
-	arguments := strings.Split(command, " ")
-	return exec.Command(arguments[0], arguments[1:]...)
+//Prints err along with its kind and stops the program with the matching exit status if err is not nil
+func exitOnError(err error) {
+	if err == nil {
+		return
+	}
+
+	kind := pki.KindOf(err)
+	status, known := exitStatuses[kind]
+	if !known {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitFailure)
+	}
+	fmt.Fprintf(os.Stderr, "Error: %s: %v\n", kind, err)
+	os.Exit(status)
 }
 
-//Incorrect
-func signCertificate(signerPrivateKey string, certificateSigningRequest string, outputCertificate string) {
-	//openssl ca -selfsign -keyfile root.pem -config root_ca.conf -out root.crt -in root.csr -outdir root_certificates -verbose -batch
-	command := ""
-	executableCommand := convertStringIntoExecCommand(command)
-	executableCommand.Run()
+//Returns an error for an invalid flag or argument, which exits with exitUsage
+func usageError(format string, args ...any) error {
+	return &pki.Error{Kind: pki.InvalidError, Err: fmt.Errorf(format, args...)}
 }
 
-//Generates a certificate based off of the root private key, root authority openssl confiration file, output filename and output directory
-func generateSelfSignedCertificate(privateKey, configuration, outputCertificateFilename, certificateSigningRequest, outputDirectory string) {
+//A subcommand such as init or issue. The summary is shown in the list of commands, while arguments
+//and description make up the command's own help text.
+type command struct {
+	name        string
+	arguments   string
+	summary     string
+	description string
+	run         func(c command, arguments []string)
+}
 
-	command := fmt.Sprintf("openssl ca -selfsign -keyfile %s -config %s -out %s -in %s -outdir %s -verbose -batch",
-		privateKey, configuration, outputCertificateFilename, certificateSigningRequest, outputDirectory)
+var commands = []command{
+	{"init", "", "create the root and intermediate authorities", "Creates the root and intermediate authorities: their directories, databases, keys and certificates. Anything that already exists is kept, so running init again changes nothing. When the root is kept offline with root_directory, its key, database and serial numbers go into that directory, which must exist, and root.crt and the root CRL are published in output/root_authority. The root directory is then only needed again to sign a new intermediate authority, to revoke it and to regenerate the root CRL. Named intermediate authorities listed as [[intermediates]] in the configuration file, or given with -intermediate, are created in output/intermediates/<name> next to the default one in output/intermediate_authority.", initCommand},
+	{"intermediates", "", "list the intermediate authorities", "Lists the default intermediate authority and the named ones, which are those in the configuration file and those found in output/intermediates, with their common names, expiry dates, name constraints and the number of certificates they issued.", intermediatesCommand},
+	{"issue", "[<domain.name> [name...]]", "issue server certificates", "Issues a server certificate signed by the intermediate authority, or the named one given with -intermediate, into output/<domain.name>. The certificate covers domain.name, 127.0.0.1 and any further DNS names, *. wildcards, IPv4 and IPv6 addresses or URIs listed after it. Without a domain name, a certificate is issued for every [[server]] in the configuration file. Existing keys and certificates are kept, along with the intermediate authority that issued them.", issueCommand},
+	{"issue-client", "[<name> [email|URI...]]", "issue client certificates for mutual TLS", "Issues a client certificate for mutual TLS, signed by the intermediate authority, or the named one given with -intermediate, into output/clients/<name>. Its common name is the name of the user or service, and any email addresses or URIs, such as spiffe:// IDs, listed after it become its subject alternative names. Besides the key client.pem and the certificate client.crt, it writes client_chain.crt with the intermediate authority's certificate, and client.p12, a password protected bundle that browsers and operating systems can import. The password is taken from -password or the PKCS12_PASSWORD environment variable. Without a name, a certificate is issued for every [[client]] in the configuration file. Existing keys and certificates are kept, along with the intermediate authority that issued them.", issueClientCommand},
+	{"issue-manifest", "<manifest.toml>", "issue the servers and clients of a manifest in one run", "Issues every server and client certificate listed in a manifest file, which holds [[server]] and [[client]] tables with the same keys as those of the configuration file. A server's directory key names its directory in the output directory instead of its domain, for example to keep an RSA and an ECDSA certificate for the same domain. Keys and CSRs are generated in parallel, up to -parallel at a time, while certificates are signed one after the other, so the authorities' databases and serial number files stay consistent. Prints what was created, skipped because it already existed, or failed, and exits with status 1 if anything failed. The password of the clients' client.p12 is taken from -password or the PKCS12_PASSWORD environment variable.", issueManifestCommand},
+	{"renew", "[name...]", "reissue expiring server and client certificates", "Scans the server certificates in the output directory and the client certificates in output/clients, or only those named, and reissues the ones that expire within the threshold from their existing configuration, so they keep their names and extensions. With -rotate-keys, they also get new keys of the same algorithm. server_bundle.crt and client_chain.crt are rebuilt, as are client.p12 and an exported server.p12 when a password is given. Prints what was renewed, skipped or failed, and exits with status 1 if anything failed, so it can be run from cron or a systemd timer.", renewCommand},
+	{"revoke", "<domain.name> | -client <name> | -intermediate [name]", "revoke a certificate", "Marks the server certificate of domain.name, or with -client, the client certificate of name, as revoked in the database of the intermediate authority that issued it, or with -intermediate, the certificate of the default or named intermediate authority in the root authority's database, and regenerates the CRL of the authority that issued it.", revokeCommand},
+	{"crl", "", "regenerate certificate revocation lists", "Writes the CRLs of the root and intermediate authorities, named ones included, to root_crl.pem, root_crl.der, intermediate_crl.pem and intermediate_crl.der in their directories. A CRL is regenerated once less than half of its lifetime, set by crl_days, remains. Run it regularly, for example from cron, so the CRLs never expire. When the root is kept offline with root_directory, only the intermediate authorities' CRLs are regenerated, and the root's CRL is regenerated with -root while the root storage is attached.", crlCommand},
+	{"serve-ocsp", "", "run an OCSP responder for the intermediate authority", "Answers OCSP requests (RFC 6960) for certificates issued by the intermediate authority, looking up their status in the intermediate authority's database on every request. Responses are signed by a dedicated OCSP signing certificate in output/ocsp_responder, which is issued by the intermediate authority when missing or about to expire. Requests are accepted by GET and POST at the path of the ocsp url.", serveOCSPCommand},
+	{"serve-ca", "", "publish the authorities' certificates and CRLs over HTTP", "Serves the DER encoded certificates and CRLs of the root and intermediate authorities at <publish url>/root.crt, /root.crl, /intermediate.crt and /intermediate.crl, and those of named intermediates at /intermediates/<name>.crt and /intermediates/<name>.crl, the locations named by the caIssuers and CRL distribution point URLs of issued certificates. CRLs are regenerated before being served once less than half of their lifetime remains.", serveCACommand},
+	{"serve-acme", "", "run an ACME server backed by the intermediate authority", "Runs an ACME (RFC 8555) server whose directory is <acme url>/directory, so that clients such as certbot, lego, Caddy and cert-manager can order server certificates from the intermediate authority. Names are validated with http-01 and dns-01 challenges, or with -auto-approve, names under the local domains and private addresses are approved without validation. When the url is https, the server uses a server certificate for its host name, issued like issue does.", serveACMECommand},
+	{"export-p12", "<domain.name>", "export a server certificate as a PKCS#12 keystore", "Writes output/<domain.name>/server.p12, a password protected PKCS#12 keystore (.p12 or .pfx) holding the server key, the server certificate and the intermediate authority's certificate, for Java, .NET and Windows. It also writes output/<domain.name>/truststore.p12, which only holds the root certificate, protected by the same password. The password is taken from -password or the PKCS12_PASSWORD environment variable.", exportPKCS12Command},
+	{"export-kubernetes", "<name> | -issuer", "export Kubernetes Secrets and cert-manager issuers", "Writes a kubernetes.io/tls Secret holding the key, chain and root certificate of a server, or of a client with -client, to kubernetes_secret.yaml in its directory. With -issuer, it instead writes a Secret holding the intermediate authority's key and certificate, unencrypted, followed by a cert-manager CA Issuer or ClusterIssuer reading it, to cert_manager_issuer.yaml in the intermediate authority's directory, so that cert-manager can issue certificates from the same hierarchy inside a cluster. -intermediate picks a named intermediate authority. The namespaces and names default to the [kubernetes] table of the configuration file. With -out -, the manifests are printed for kubectl apply -f - instead.", exportKubernetesCommand},
+	{"trust", "install | uninstall | status", "trust the root certificate on this machine", "Adds the root certificate to, or removes it from, the system trust store of Debian and RHEL style systems and the NSS databases used by Chrome and Firefox (~/.pki/nssdb and Firefox profiles), then reports for each store whether the root is trusted. The system store needs root and the NSS databases need certutil, so run it with sudo and -nss=false, then as yourself with -system=false.", trustCommand},
+	{"encrypt-keys", "", "encrypt the authorities' existing private keys", "Encrypts root.pem and the intermediate.pem of every intermediate authority with a passphrase, for the authorities whose key_encryption is pbkdf2 or scrypt, when they were generated unencrypted. Keys that are already encrypted are left alone. Once encrypted, every command that signs with a key asks for its passphrase, which is read from the file descriptor given with -passphrase-fd, the PKI_PASSPHRASE environment variable or the terminal, in that order.", encryptKeysCommand},
+	{"list", "", "list issued certificates", "Lists the certificates recorded in the databases of the root and intermediate authorities, named ones included, with their status, expiry date, serial number, subject, subject alternative names and issuer. The names are read from the copy of each certificate its authority kept when signing it. -authority, -status, -name and -expires-within select some of them, and -format prints them as a table, JSON or CSV.", listCommand},
+	{"inspect", "<domain.name> | -client <name>", "show the details of a certificate and check it", "Shows the subject, names, serial number, key type and remaining validity of every certificate in server_bundle.crt, or client_chain.crt with -client. It then checks that the bundle starts with the certificate, that it chains to root.crt, that the certificate covers the host name (domain.name unless -hostname is given) and that the private key belongs to the certificate. -json prints the same report as JSON.", inspectCommand},
+	{"verify", "<domain.name> | -client <name>", "check a certificate, failing if anything is wrong", "Runs the same checks and prints the same report as inspect, but exits with status 1 when a check fails, so that scripts can rely on it.", inspectCommand},
+}
 
-	fmt.Println("Inside generateSelfSignedCertificate", command)
-	err := runCommand(command)
-	if err != nil {
-		fmt.Println("Error during generation of self-signed certificate. Command was: " + command)
-		fmt.Println(err)
-		os.Exit(0)
+//Prints the list of commands
+func printUsage() {
+	fmt.Println("usage: go run generate_certificates.go <command> [flags] [arguments]")
+	fmt.Println()
+	fmt.Println("commands:")
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(table, "  %s\t%s\n", c.name, c.summary)
 	}
+	table.Flush()
+	fmt.Println()
+	fmt.Println("Run go run generate_certificates.go <command> -h for the flags of a command.")
 }
 
-//privateKey is a string specifying the filepath of the private key for the entity performing the sign
-//outputCertificate is a string specifying the filepath of the certificate that will be generated
-//configuration is a string specifying the filepath of a file containing data to be signed
-func generateCertificateSigningRequest(privateKey, outputCertificate, configuration string) {
-	command := fmt.Sprintf("openssl req -key %s -out %s -days 398 -new -config %s", privateKey, outputCertificate, configuration)
-	fmt.Println("Inside generateCertificateSigningRequest. command is:", command)
-	err := runCommand(command)
-	if err != nil {
-		fmt.Println("An error occurred when trying to generate the certificate signing request using the key " + privateKey + " with the configuration " + configuration)
-		fmt.Println("The command was:", command)
-		fmt.Println(err)
-		os.Exit(0)
-	}
+//The flags shared by every command, which select and override the configuration file
+type configurationFlags struct {
+	configurationFile string
+	outputDirectory   string
+	rootDirectory     string
+	backend           string
+	passphraseFD      int
+	//The passphrase read from passphraseFD or PKI_PASSPHRASE, which is used for every key
+	passphrase string
+	//Where progress messages go, standard output unless the command prints JSON or CSV there
+	log io.Writer
 }
 
-//Generates a signed certificate using the openssl ca command
-func generateSignedCertificate(certificateSigningRequest, outputCertificateFilepath, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputCertificateDirectory string) {
-	command := fmt.Sprintf("openssl ca -in %s -out %s -config %s -keyfile %s -cert %s -outdir %s -batch", certificateSigningRequest, outputCertificateFilepath, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputCertificateDirectory)
-	fmt.Println("Inside generateSignedCertificate the command is:", command)
-	err := runCommand(command)
-	if err != nil {
-		fmt.Println("An error occurred when trying to generate the signed certificate. The command was: ", command)
-		fmt.Println(err)
-		os.Exit(0)
+//Returns a flag set for c holding the shared configuration flags, with help text built from the command's description
+func (c command) flags(shared *configurationFlags) *flag.FlagSet {
+	flags := flag.NewFlagSet(c.name, flag.ExitOnError)
+	flags.StringVar(&shared.configurationFile, "config", "pki.toml", "configuration file describing the hierarchy, used if it exists")
+	flags.StringVar(&shared.outputDirectory, "output", "", "directory the authorities and servers are generated in, overriding output_directory")
+	flags.StringVar(&shared.rootDirectory, "root-directory", "", "directory holding the root authority's key, such as a removable drive, overriding root_directory")
+	flags.StringVar(&shared.backend, "backend", "", "how keys and certificates are generated: native (crypto/x509) or openssl, overriding backend")
+	flags.IntVar(&shared.passphraseFD, "passphrase-fd", -1, "file descriptor the passphrase of encrypted authority keys is read from, instead of the PKI_PASSPHRASE environment variable or the terminal")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: go run generate_certificates.go %s [flags] %s\n\n%s\n\nflags:\n", c.name, c.arguments, c.description)
+		flags.PrintDefaults()
 	}
+	return flags
 }
 
-//Uses OpenSSL to generate a private key
-func generatePrivateKey(filename string) error {
-	command := fmt.Sprintf("openssl genpkey -outform pem -out %s -algorithm rsa", filename)
-	err := runCommand(command)
-
-	if err != nil {
-		fmt.Println("An error occurred when trying to generate private key " + filename + " using OpenSSL.")
-		fmt.Println(err)
+//Loads the configuration file, if it exists or was given with -config, and applies the shared flags to it
+func (shared *configurationFlags) load(flags *flag.FlagSet) pki.Options {
+	if shared.log == nil {
+		shared.log = os.Stdout
+	}
+	options := pki.DefaultOptions()
+	options.Log = shared.log
+	configurationFileGiven := false
+	flags.Visit(func(f *flag.Flag) {
+		configurationFileGiven = configurationFileGiven || f.Name == "config"
+	})
+	if _, err := os.Stat(shared.configurationFile); configurationFileGiven || err == nil {
+		fmt.Fprintln(shared.log, "Loading configuration: "+shared.configurationFile)
+		exitOnError(options.Load(shared.configurationFile))
 	}
-	return err
-}
 
-//Returns true if file or directory passed in exists
-func fileExists(filename string) bool {
-	_, err := os.Stat(filename)
-	if errors.Is(err, os.ErrNotExist) {
-		return false
+	if shared.outputDirectory != "" {
+		options.OutputDirectory = shared.outputDirectory
+	}
+	if shared.rootDirectory != "" {
+		options.RootDirectory = shared.rootDirectory
 	}
-	return true
+	if shared.backend != "" {
+		options.Backend = shared.backend
+	}
+	//The serial numbers are recorded per user rather than in the output directory, so that a CA created again after
+	//its output directory was deleted doesn't reuse them
+	if directory, err := os.UserConfigDir(); err == nil && options.SerialNumberRecord == "" {
+		options.SerialNumberRecord = filepath.Join(directory, "generate_certificates", "serial_numbers.txt")
+	}
+	options.Passphrase = shared.readPassphrase
+	return options
 }
 
-//Given the domain name directory, generates the directory structure needed for this program
-func makeDirectories() {
-	//1)Ensure a directory named after the domain name passed in always exists in the output directory
-	if !fileExists(stringFragments["outputDirectory"]) {
-		fmt.Println("Generating directory: " + stringFragments["outputDirectory"])
-		os.Mkdir(stringFragments["outputDirectory"], 0700)
+//Returns the passphrase of an encrypted authority key. It is read once from -passphrase-fd, or taken from PKI_PASSPHRASE,
+//and otherwise asked for on the terminal for each key.
+func (shared *configurationFlags) readPassphrase(privateKey string, confirm bool) (string, error) {
+	if shared.passphrase != "" {
+		return shared.passphrase, nil
 	}
 
-	if !fileExists(stringFragments["domainNameDirectory"]) {
-		fmt.Println("Generating directory: " + stringFragments["domainNameDirectory"])
-		os.Mkdir(stringFragments["domainNameDirectory"], 0700)
+	if shared.passphraseFD >= 0 {
+		line, err := bufio.NewReader(os.NewFile(uintptr(shared.passphraseFD), "passphrase")).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("reading the passphrase from file descriptor %d: %w", shared.passphraseFD, err)
+		}
+		shared.passphrase = strings.TrimRight(line, "\r\n")
+		return shared.passphrase, nil
 	}
 
-	if !fileExists(stringFragments["rootAuthorityDirectory"]) {
-		fmt.Println("Generating directory: " + stringFragments["rootAuthorityDirectory"])
-		os.Mkdir(stringFragments["rootAuthorityDirectory"], 0700)
+	if passphrase := os.Getenv("PKI_PASSPHRASE"); passphrase != "" {
+		shared.passphrase = passphrase
+		return passphrase, nil
 	}
 
-	if !fileExists(stringFragments["intermediateAuthorityDirectory"]) {
-		fmt.Println("Generating directory: " + stringFragments["intermediateAuthorityDirectory"])
-		os.Mkdir(stringFragments["intermediateAuthorityDirectory"], 0700)
+	passphrase, err := promptPassphrase("Passphrase for " + privateKey + ": ")
+	if err != nil {
+		return "", err
 	}
-}
-
-//Takes in an output directory and generates 3 private keys, one for the root authority, one for the intermediate authority, and one for the server hosting the domain name.
-func makePrivateKeys() {
-	//2)Create a root authority private key if it doesn't already exist. Do not replace an existing one
-	//openssl genpkey -outform pem -out root.pem -algorithm rsa
-	if !fileExists(stringFragments["rootAuthorityPrivateKey"]) {
-		fmt.Println("Generating root private key: " + stringFragments["rootAuthorityPrivateKey"])
-		err := generatePrivateKey(stringFragments["rootAuthorityPrivateKey"])
+	if confirm {
+		repeated, err := promptPassphrase("Repeat the passphrase for " + privateKey + ": ")
 		if err != nil {
-			os.Exit(0)
+			return "", err
+		}
+		if repeated != passphrase {
+			return "", fmt.Errorf("the passphrases for %s don't match", privateKey)
 		}
 	}
+	return passphrase, nil
+}
 
-	//3)Create an intermediate authority private key
-	if !fileExists(stringFragments["intermediateAuthorityPrivateKey"]) {
-		fmt.Println("Generating intermediate private key: " + stringFragments["intermediateAuthorityPrivateKey"])
-		generatePrivateKey(stringFragments["intermediateAuthorityPrivateKey"])
+//Asks for a passphrase on the terminal without echoing it
+func promptPassphrase(prompt string) (string, error) {
+	terminal, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.New("no terminal to ask for the passphrase on, pass -passphrase-fd or set PKI_PASSPHRASE")
 	}
+	defer terminal.Close()
 
-	//4)Generate a server private key
-	stringFragments["serverPrivateKey"] = stringFragments["domainNameDirectory"] + "/" + stringFragments["serverPrivateKeyFilename"]
-	if !fileExists(stringFragments["serverPrivateKey"]) {
-		fmt.Println("Generating server private key: " + stringFragments["serverPrivateKey"])
-		generatePrivateKey(stringFragments["serverPrivateKey"])
+	stty := func(setting string) error {
+		command := exec.Command("stty", setting)
+		command.Stdin = terminal
+		return command.Run()
 	}
-}
-
-//Copies the file in source to destination
-func fileCopy(src, dst string) {
-	fmt.Println("Inside fileCopy src:", src, "| dst:", dst, "|")
-	bytesRead, err := ioutil.ReadFile(src)
-
+	err = stty("-echo")
 	if err != nil {
-		fmt.Println("Error reading from ", src)
-		fmt.Println(err)
+		return "", fmt.Errorf("turning off the terminal echo: %w", err)
 	}
+	defer stty("echo")
 
-	err = ioutil.WriteFile(dst, bytesRead, 0644)
-
+	fmt.Fprint(terminal, prompt)
+	line, err := bufio.NewReader(terminal).ReadString('\n')
+	fmt.Fprintln(terminal)
 	if err != nil {
-		fmt.Println("Error writing to: ", dst)
-		fmt.Println(err)
+		return "", fmt.Errorf("reading the passphrase: %w", err)
 	}
+	return strings.TrimRight(line, "\r\n"), nil
 }
 
-//template: a template openssl configuration file
-//output: the output file that will be guaranteed to exist. One will be generated by copying
-//args: a string array of
-func hydrateTemplate(template, output string, args ...any) {
-	fmt.Println("Hydrating ", template, " into ", output)
-	fileCopy(template, output)
+//Returns the CA described by options, stopping the program if they are not valid
+func newCA(options pki.Options) *pki.CA {
+	ca, err := pki.New(options)
+	exitOnError(err)
+	return ca
+}
 
-	contentAsBytes, err := ioutil.ReadFile(output)
-	if err != nil {
-		fmt.Println("Error while reading " + output)
-		fmt.Println(err)
+//Parses the flags of a command that takes exactly one domain name and returns the CA and the server certificate of that domain
+func parseDomainCommand(c command, flags *flag.FlagSet, shared *configurationFlags, arguments []string) (*pki.CA, *pki.Leaf) {
+	flags.Parse(arguments)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
 	}
 
-	//After copying the contents of the file needs to be altered to match input domain name
-	contentsAsString := string(contentAsBytes[:])
-	newFileContents := fmt.Sprintf(contentsAsString, args...)
+	options := shared.load(flags)
+	ca := newCA(options)
+	return ca, ca.Server(options.Server(flags.Arg(0)))
+}
+
+func initCommand(c command, arguments []string) {
+	var shared configurationFlags
+	flags := c.flags(&shared)
+	keyAlgorithmUsage := "key algorithm for the %s key, one of " + strings.Join(pki.KeyAlgorithms, ", ") + ", overriding the configuration file"
+	rootKeyAlgorithm := flags.String("root-key", "", fmt.Sprintf(keyAlgorithmUsage, "root authority"))
+	intermediateKeyAlgorithm := flags.String("intermediate-key", "", fmt.Sprintf(keyAlgorithmUsage, "intermediate authority"))
+	keyEncryption := flags.String("key-encryption", "", "how the root and intermediate keys are encrypted, one of "+strings.Join(pki.KeyEncryptions, ", ")+", overriding key_encryption")
+	caURLs := flags.Bool("ca-urls", false, "add the root certificate and CRL URLs of the publication server to the intermediate certificate, overriding add_to_certificates")
+	nameConstraints := flags.Bool("name-constraints", false, "limit the intermediate authority to local domains and private addresses, unless the configuration file sets its permitted_names")
+	intermediate := flags.String("intermediate", "", "also create the named intermediate authority called this, with the settings of [intermediate] unless it is listed in the configuration file")
+	flags.Parse(arguments)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	options := shared.load(flags)
+	if *rootKeyAlgorithm != "" {
+		options.Root.KeyAlgorithm = *rootKeyAlgorithm
+	}
+	if *intermediateKeyAlgorithm != "" {
+		options.Intermediate.KeyAlgorithm = *intermediateKeyAlgorithm
+	}
+	if *keyEncryption != "" {
+		options.Root.KeyEncryption = *keyEncryption
+		options.Intermediate.KeyEncryption = *keyEncryption
+		for index := range options.Intermediates {
+			options.Intermediates[index].KeyEncryption = *keyEncryption
+		}
+	}
+	if *caURLs {
+		options.Publish.AddToCertificates = true
+	}
+	if *nameConstraints && len(options.Intermediate.PermittedNames) == 0 {
+		options.Intermediate.PermittedNames = pki.LocalPermittedNames
+	}
+	//A new named intermediate takes the settings of the default one, flags included
+	if *intermediate != "" && !slices.ContainsFunc(options.Intermediates, func(named pki.IntermediateOptions) bool { return named.Name == *intermediate }) {
+		options.Intermediates = append(options.Intermediates, options.NamedIntermediate(*intermediate))
+	}
 
-	ioutil.WriteFile(output, []byte(newFileContents), 0644)
+	exitOnError(newCA(options).Init())
 }
 
-func makeServerCertificate() {
-	fmt.Println("serverCSR:", stringFragments["serverCSR"])
-	fmt.Println("serverCSRConfig:", stringFragments["serverCSRConfig"])
-	fmt.Println("serverCSRConfigTemplate:", stringFragments["serverCSRConfigTemplate"])
+func issueCommand(c command, arguments []string) {
+	var shared configurationFlags
+	flags := c.flags(&shared)
+	serverKeyAlgorithm := flags.String("key", "", "key algorithm for the server key, one of "+strings.Join(pki.KeyAlgorithms, ", ")+", overriding the configuration file")
+	validityDays := flags.Int("days", 0, "number of days the server certificate is valid for, overriding validity_days")
+	ocsp := flags.Bool("ocsp", false, "add the OCSP responder URL to the certificate's authorityInfoAccess extension, overriding add_to_certificates")
+	caURLs := flags.Bool("ca-urls", false, "add the intermediate certificate and CRL URLs of the publication server to the certificate, overriding add_to_certificates")
+	intermediate := flags.String("intermediate", "", "name of the intermediate authority that signs the certificates, overriding intermediate")
+	flags.Parse(arguments)
+
+	options := shared.load(flags)
+	if *ocsp {
+		options.OCSP.AddToCertificates = true
+	}
+	if *caURLs {
+		options.Publish.AddToCertificates = true
+	}
+	if flags.NArg() > 0 {
+		server := options.Server(flags.Arg(0))
+		server.Names = flags.Args()[1:]
+		options.Servers = []pki.ServerOptions{server}
+	}
+	if len(options.Servers) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no domain name specified.")
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	for index := range options.Servers {
+		if *serverKeyAlgorithm != "" {
+			options.Servers[index].KeyAlgorithm = *serverKeyAlgorithm
+		}
+		if *validityDays != 0 {
+			options.Servers[index].ValidityDays = *validityDays
+		}
+		if *intermediate != "" {
+			options.Servers[index].Intermediate = *intermediate
+		}
+	}
+	ca := newCA(options)
+	exitOnError(ca.CheckAuthorities())
 
-	if !fileExists(stringFragments["serverCSRConfig"]) {
-		hydrateTemplate(stringFragments["serverCSRConfigTemplate"], stringFragments["serverCSRConfig"], stringFragments["domainName"])
+	//Every server is checked before anything is signed, against the intermediate authority that will sign it
+	for _, server := range options.Servers {
+		exitOnError(ca.CheckNameConstraints(ca.Server(server).Intermediate, server.SubjectAlternativeNames()))
 	}
 
-	if !fileExists(stringFragments["serverConfig"]) {
-		fmt.Println(stringFragments["serverConfigTemplate"])
-		fmt.Println(stringFragments["serverConfig"])
-		hydrateTemplate(stringFragments["serverConfigTemplate"], stringFragments["serverConfig"], stringFragments["intermediateAuthorityDatabase"], stringFragments["intermediateAuthoritySerialNumber"], stringFragments["domainName"])
+	for _, server := range options.Servers {
+		_, err := ca.IssueServer(server)
+		exitOnError(err)
 	}
+}
 
-	if !fileExists(stringFragments["serverCSR"]) {
-		fmt.Println("Generating server CSR")
-		generateCertificateSigningRequest(stringFragments["serverPrivateKey"], stringFragments["serverCSR"], stringFragments["serverCSRConfig"])
+func issueClientCommand(c command, arguments []string) {
+	var shared configurationFlags
+	flags := c.flags(&shared)
+	clientKeyAlgorithm := flags.String("key", "", "key algorithm for the client key, one of "+strings.Join(pki.KeyAlgorithms, ", ")+", overriding the configuration file")
+	validityDays := flags.Int("days", 0, "number of days the client certificate is valid for, overriding validity_days")
+	password := flags.String("password", "", "password protecting client.p12, instead of the PKCS12_PASSWORD environment variable")
+	encryption := flags.String("encryption", "modern", "encryption of client.p12: modern (AES-256) or legacy (3DES) for older browsers and macOS Keychain")
+	ocsp := flags.Bool("ocsp", false, "add the OCSP responder URL to the certificate's authorityInfoAccess extension, overriding add_to_certificates")
+	caURLs := flags.Bool("ca-urls", false, "add the intermediate certificate and CRL URLs of the publication server to the certificate, overriding add_to_certificates")
+	intermediate := flags.String("intermediate", "", "name of the intermediate authority that signs the certificates, overriding intermediate")
+	flags.Parse(arguments)
+
+	options := shared.load(flags)
+	if *ocsp {
+		options.OCSP.AddToCertificates = true
+	}
+	if *caURLs {
+		options.Publish.AddToCertificates = true
+	}
+	if flags.NArg() > 0 {
+		client := options.Client(flags.Arg(0))
+		client.Names = flags.Args()[1:]
+		options.Clients = []pki.ClientOptions{client}
 	}
+	if len(options.Clients) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no client name specified.")
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	for index := range options.Clients {
+		if *clientKeyAlgorithm != "" {
+			options.Clients[index].KeyAlgorithm = *clientKeyAlgorithm
+		}
+		if *validityDays != 0 {
+			options.Clients[index].ValidityDays = *validityDays
+		}
+		if *intermediate != "" {
+			options.Clients[index].Intermediate = *intermediate
+		}
+	}
+	ca := newCA(options)
+	exitOnError(ca.CheckAuthorities())
 
-	if !fileExists(stringFragments["serverCertificate"]) {
-		fmt.Println("Generating server certificate")
-		fmt.Println(stringFragments["serverCSR"])
-		fmt.Println(stringFragments["serverCertificateFilename"])
-		fmt.Println(stringFragments["serverConfig"])
-		fmt.Println(stringFragments["intermediateAuthorityPrivateKey"])
-		fmt.Println(stringFragments["intermediateAuthorityCertificate"])
-		fmt.Println(stringFragments["domainNameDirectory"])
+	//Checked before anything is generated, since every client gets a client.p12
+	if !slices.Contains(pki.PKCS12Encryptions, *encryption) {
+		exitOnError(usageError("unknown encryption %q, expected one of %s", *encryption, strings.Join(pki.PKCS12Encryptions, ", ")))
+	}
+	if *password == "" {
+		*password = os.Getenv("PKCS12_PASSWORD")
+	}
+	if *password == "" {
+		exitOnError(usageError("a password for client.p12 is required, pass -password or set PKCS12_PASSWORD"))
+	}
+
+	for _, client := range options.Clients {
+		exitOnError(ca.CheckNameConstraints(ca.Client(client).Intermediate, client.ConstrainedNames()))
+	}
 
-		generateSignedCertificate(stringFragments["serverCSR"], stringFragments["serverCertificate"], stringFragments["serverConfig"], stringFragments["intermediateAuthorityPrivateKey"], stringFragments["intermediateAuthorityCertificate"], stringFragments["domainNameDirectory"])
+	for _, client := range options.Clients {
+		_, err := ca.IssueClient(client, *password, *encryption)
+		exitOnError(err)
 	}
 }
 
-//This is not correct
-func makeIntermediateAuthorityCertificate() {
-	if !fileExists(stringFragments["intermediateAuthorityMakeInformationCSRConfig"]) {
-		fileCopy(stringFragments["intermediateAuthorityMakeInformationCSRConfigTemplate"], stringFragments["intermediateAuthorityMakeInformationCSRConfig"])
+func issueManifestCommand(c command, arguments []string) {
+	var shared configurationFlags
+	flags := c.flags(&shared)
+	parallel := flags.Int("parallel", runtime.NumCPU(), "number of certificates generated at the same time")
+	password := flags.String("password", "", "password protecting the client.p12 of the clients, instead of the PKCS12_PASSWORD environment variable")
+	encryption := flags.String("encryption", "modern", "encryption of client.p12: modern (AES-256) or legacy (3DES) for older browsers and macOS Keychain")
+	flags.Parse(arguments)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
 	}
 
-	//Generate the intermediate authority CSR if it doesn't already exist
-	if !fileExists(stringFragments["intermediateAuthorityCSR"]) {
-		fmt.Println("Generating intermediate CSR.") //This is the request from the intermediate authority to the root authority to sign its certificate
-		generateCertificateSigningRequest(stringFragments["intermediateAuthorityPrivateKey"], stringFragments["intermediateAuthorityCSR"], stringFragments["intermediateAuthorityMakeInformationCSRConfig"])
+	manifest, err := pki.LoadManifest(flags.Arg(0))
+	exitOnError(err)
+	if *parallel < 1 {
+		exitOnError(usageError("-parallel must be at least 1"))
 	}
+	if !slices.Contains(pki.PKCS12Encryptions, *encryption) {
+		exitOnError(usageError("unknown encryption %q, expected one of %s", *encryption, strings.Join(pki.PKCS12Encryptions, ", ")))
+	}
+	if *password == "" {
+		*password = os.Getenv("PKCS12_PASSWORD")
+	}
+	if len(manifest.Clients) > 0 && *password == "" {
+		exitOnError(usageError("a password for client.p12 is required, pass -password or set PKCS12_PASSWORD"))
+	}
+
+	results, err := newCA(shared.load(flags)).IssueManifest(manifest, *parallel, *password, *encryption)
+	exitOnError(err)
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "KIND\tNAME\tINTERMEDIATE\tRESULT")
+	failures := 0
+	for _, result := range results {
+		intermediate := result.Leaf.Intermediate
+		if intermediate == "" {
+			intermediate = "(default)"
+		}
 
-	//ensure the openssl configuration file for making the intermediate authority certificate is present
-	if !fileExists(stringFragments["intermediateAuthorityMakeCertificateConfiguration"]) {
-		hydrateTemplate(
-			stringFragments["intermediateAuthorityConfigTemplate"],
-			stringFragments["intermediateAuthorityMakeCertificateConfiguration"],
-			stringFragments["intermediateAuthorityDatabase"],
-			stringFragments["intermediateAuthoritySerialNumber"])
+		outcome := "skipped, already issued"
+		switch {
+		case result.Err != nil:
+			failures++
+			outcome = "failed: " + result.Err.Error()
+		case result.Created:
+			outcome = "created"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", result.Leaf.Kind, result.Leaf.Name, intermediate, outcome)
 	}
+	table.Flush()
 
-	fmt.Println("Generating intermediate certificate")
-	fmt.Println("Inside makeIntermediateAuthorityCertificate")
-	fmt.Println(stringFragments["intermediateAuthorityCSR"], stringFragments["intermediateAuthorityCertificate"], stringFragments["intermediateAuthorityMakeCertificateConfiguration"], stringFragments["rootAuthorityPrivateKey"], stringFragments["rootAuthorityCertificate"], stringFragments["intermediateAuthorityDirectory"])
-	generateSignedCertificate(stringFragments["intermediateAuthorityCSR"], stringFragments["intermediateAuthorityCertificate"], stringFragments["intermediateAuthorityMakeCertificateConfiguration"], stringFragments["rootAuthorityPrivateKey"], stringFragments["rootAuthorityCertificate"], stringFragments["intermediateAuthorityDirectory"])
+	if failures > 0 {
+		fmt.Fprintf(os.Stderr, "Error: %d of %d certificates could not be issued\n", failures, len(results))
+		os.Exit(exitFailure)
+	}
 }
 
-func makeRootAuthorityCertificate() {
-	//Stage 4
-	//1)Generate root certificate
-	//2)Generate intermediate certificate
-	//3)Generate server certificate
-	stringFragments["rootCSR"] = stringFragments["rootAuthorityDirectory"] + "/root.csr"
-	if !fileExists(stringFragments["rootCSR"]) {
-		fmt.Println("Generating root CSR: ", stringFragments["rootCSR"])
+func renewCommand(c command, arguments []string) {
+	var shared configurationFlags
+	flags := c.flags(&shared)
+	thresholdDays := flags.Int("threshold", 0, "renew certificates that expire within this many days, overriding threshold_days")
+	rotateKeys := flags.Bool("rotate-keys", false, "replace the keys of renewed certificates with new ones, overriding rotate_keys")
+	force := flags.Bool("force", false, "renew the certificates whatever their expiry date")
+	password := flags.String("password", "", "password for rebuilding the client.p12 and server.p12 of renewed certificates, instead of the PKCS12_PASSWORD environment variable")
+	flags.Parse(arguments)
+
+	options := shared.load(flags)
+	if *thresholdDays != 0 {
+		options.Renew.ThresholdDays = *thresholdDays
+	}
+	if *rotateKeys {
+		options.Renew.RotateKeys = true
+	}
+	ca := newCA(options)
+	if *password == "" {
+		*password = os.Getenv("PKCS12_PASSWORD")
+	}
 
-		stringFragments["rootAuthorityCSRConfig"] = stringFragments["rootAuthorityDirectory"] + "/" + stringFragments["rootAuthorityMakeInformationCSRConfigFilename"]
-		if !fileExists(stringFragments["rootAuthorityCSRConfig"]) {
-			//Copy the config file over from the templates directory to the directoryNameDirectory if it doesn't exist
-			templatesDirectoryRootAuthorityCSRConfig := stringFragments["templatesDirectory"] + "/" + stringFragments["rootAuthorityMakeInformationCSRConfigFilename"]
-			fileCopy(templatesDirectoryRootAuthorityCSRConfig, stringFragments["rootAuthorityCSRConfig"])
+	renewals, err := ca.Renew(flags.Args(), *force, *password)
+	exitOnError(err)
+
+	results := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(results, "KIND\tNAME\tEXPIRES\tRESULT")
+	failures := 0
+	for _, renewal := range renewals {
+		expires := "-"
+		if !renewal.Expires.IsZero() {
+			expires = renewal.Expires.Format("2006-01-02")
+		}
+
+		result := fmt.Sprintf("skipped, %d days left", int(time.Until(renewal.Expires).Hours()/24))
+		switch {
+		case renewal.Err != nil:
+			failures++
+			result = "failed: " + renewal.Err.Error()
+		case renewal.Renewed && options.Renew.RotateKeys:
+			result = "renewed with a new key"
+		case renewal.Renewed:
+			result = "renewed"
 		}
-		generateCertificateSigningRequest(stringFragments["rootAuthorityPrivateKey"], stringFragments["rootCSR"], stringFragments["rootAuthorityCSRConfig"])
+		fmt.Fprintf(results, "%s\t%s\t%s\t%s\n", renewal.Leaf.Kind, renewal.Leaf.Name, expires, result)
 	}
+	results.Flush()
 
-	if !fileExists(stringFragments["rootAuthorityCertificate"]) {
-		if !fileExists(stringFragments["rootAuthorityMakeCertificateConfiguration"]) {
-			fmt.Println("Copying root authority certificate generation configuration from ", stringFragments["rootAuthorityConfigTemplate"], " to ", stringFragments["rootAuthorityMakeCertificateConfiguration"])
+	if failures > 0 {
+		fmt.Fprintf(os.Stderr, "Error: %d of %d certificates could not be renewed\n", failures, len(renewals))
+		os.Exit(exitFailure)
+	}
+}
 
-			hydrateTemplate(stringFragments["rootAuthorityConfigTemplate"], stringFragments["rootAuthorityMakeCertificateConfiguration"], stringFragments["rootAuthorityDatabase"], stringFragments["rootAuthoritySerialNumber"])
+func revokeCommand(c command, arguments []string) {
+	var shared configurationFlags
+	flags := c.flags(&shared)
+	reason := flags.String("reason", "", "why the certificate is revoked: unspecified, keyCompromise, CACompromise, affiliationChanged, superseded, cessationOfOperation or certificateHold")
+	intermediate := flags.Bool("intermediate", false, "revoke the certificate of the intermediate authority, or of the named one given as argument, instead of a server certificate")
+	client := flags.Bool("client", false, "revoke the client certificate of the named client instead of a server certificate")
+	flags.Parse(arguments)
+
+	if *intermediate {
+		if flags.NArg() > 1 {
+			flags.Usage()
+			os.Exit(exitUsage)
 		}
+		exitOnError(newCA(shared.load(flags)).RevokeIntermediate(flags.Arg(0), *reason))
+		return
+	}
 
-		fmt.Println("Generating root certificate: ", stringFragments["rootAuthorityCertificate"])
-		generateSelfSignedCertificate(stringFragments["rootAuthorityPrivateKey"], stringFragments["rootAuthorityMakeCertificateConfiguration"], stringFragments["rootAuthorityCertificate"], stringFragments["rootCSR"], stringFragments["rootAuthorityDirectory"])
+	var leaf *pki.Leaf
+	if *client {
+		if flags.NArg() != 1 {
+			flags.Usage()
+			os.Exit(exitUsage)
+		}
+		options := shared.load(flags)
+		leaf = newCA(options).Client(options.Client(flags.Arg(0)))
+	} else {
+		_, leaf = parseDomainCommand(c, flags, &shared, flags.Args())
 	}
+	exitOnError(leaf.Revoke(*reason))
 }
 
-func initializeStringFragments() {
-	stringFragments["rootAuthorityPrivateKeyFilename"] = "root.pem"
-	stringFragments["intermediateAuthorityPrivateKeyFilename"] = "intermediate.pem"
-	stringFragments["serverPrivateKeyFilename"] = "server.pem"
+func crlCommand(c command, arguments []string) {
+	var shared configurationFlags
+	flags := c.flags(&shared)
+	force := flags.Bool("force", false, "regenerate the CRLs even if they are not halfway through their lifetime")
+	root := flags.Bool("root", false, "only regenerate the root authority's CRL, which needs the root storage when root_directory is set")
+	flags.Parse(arguments)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
 
-	stringFragments["outputDirectory"] = "output"
-	stringFragments["templatesDirectory"] = "templates"
-	stringFragments["domainNameDirectory"] = stringFragments["outputDirectory"] + "/" + stringFragments["domainName"]
+	ca := newCA(shared.load(flags))
+	if *root {
+		exitOnError(ca.UpdateRootCRL(*force))
+		return
+	}
+	exitOnError(ca.UpdateCRLs(*force))
+}
 
-	stringFragments["rootAuthorityMakeInformationCSRConfigFilename"] = "make_root_information_csr.conf"
-	stringFragments["rootAuthorityMakeCertificateFilename"] = "make_root_certificate.conf"
-	stringFragments["rootAuthorityDirectory"] = stringFragments["outputDirectory"] + "/root_authority"
-	stringFragments["rootAuthorityPrivateKey"] = stringFragments["rootAuthorityDirectory"] + "/" + stringFragments["rootAuthorityPrivateKeyFilename"]
-	stringFragments["rootAuthorityDatabaseFilename"] = "root_database.txt"
-	stringFragments["rootAuthoritySerialNumberFilename"] = "root_serial_number.txt"
-	stringFragments["rootAuthorityCertificateFilename"] = "root.crt"
-	stringFragments["rootAuthorityMakeCertificateConfiguration"] = stringFragments["rootAuthorityDirectory"] + "/" + stringFragments["rootAuthorityMakeCertificateFilename"]
-	stringFragments["rootAuthorityDatabase"] = stringFragments["rootAuthorityDirectory"] + "/" + stringFragments["rootAuthorityDatabaseFilename"]
-	stringFragments["rootAuthoritySerialNumber"] = stringFragments["rootAuthorityDirectory"] + "/" + stringFragments["rootAuthoritySerialNumberFilename"]
-	stringFragments["rootAuthorityConfigTemplate"] = stringFragments["templatesDirectory"] + "/" + stringFragments["rootAuthorityMakeCertificateFilename"]
-	stringFragments["rootAuthorityCertificate"] = stringFragments["rootAuthorityDirectory"] + "/" + stringFragments["rootAuthorityCertificateFilename"]
+func serveOCSPCommand(c command, arguments []string) {
+	var shared configurationFlags
+	flags := c.flags(&shared)
+	listen := flags.String("listen", "", "address to listen on, overriding the ocsp listen setting")
+	responseValidity := flags.Duration("response-validity", time.Hour, "how long clients may cache each response")
+	flags.Parse(arguments)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
 
-	stringFragments["intermediateAuthorityMakeInformationCSRConfigFilename"] = "make_intermediate_information_csr.conf"
-	stringFragments["intermediateAuthorityDirectory"] = stringFragments["outputDirectory"] + "/intermediate_authority"
-	stringFragments["intermediateAuthorityPrivateKey"] = stringFragments["intermediateAuthorityDirectory"] + "/" + stringFragments["intermediateAuthorityPrivateKeyFilename"]
-	stringFragments["intermediateAuthorityMakeCertificateConfigurationFilename"] = "make_intermediate_certificate.conf"
-	stringFragments["intermediateAuthorityMakeCertificateConfiguration"] = stringFragments["intermediateAuthorityDirectory"] + "/" + stringFragments["intermediateAuthorityMakeCertificateConfigurationFilename"]
-	stringFragments["intermediateAuthorityMakeInformationCSRConfig"] = stringFragments["intermediateAuthorityDirectory"] + "/" + stringFragments["intermediateAuthorityMakeInformationCSRConfigFilename"]
-	stringFragments["intermediateAuthorityDatabase"] = stringFragments["intermediateAuthorityDirectory"] + "/intermediate_database.txt"
-	stringFragments["intermediateAuthoritySerialNumber"] = stringFragments["intermediateAuthorityDirectory"] + "/intermediate_serial_number.txt"
-	stringFragments["intermediateAuthorityCSR"] = stringFragments["intermediateAuthorityDirectory"] + "/intermediate.csr"
-	stringFragments["intermediateAuthorityMakeInformationCSRConfigTemplate"] = stringFragments["templatesDirectory"] + "/" + stringFragments["intermediateAuthorityMakeInformationCSRConfigFilename"]
-	stringFragments["intermediateAuthorityConfigTemplate"] = stringFragments["templatesDirectory"] + "/" + stringFragments["intermediateAuthorityMakeCertificateConfigurationFilename"]
-	stringFragments["intermediateAuthorityCertificate"] = stringFragments["intermediateAuthorityDirectory"] + "/intermediate.crt"
+	options := shared.load(flags)
+	if *listen != "" {
+		options.OCSP.Listen = *listen
+	}
+	ca := newCA(options)
+	handler, err := ca.OCSPHandler(*responseValidity)
+	exitOnError(err)
 
-	stringFragments["serverCSR"] = stringFragments["domainNameDirectory"] + "/server.csr"
-	stringFragments["serverCSRConfigFilename"] = "make_server_information_csr.conf"
-	stringFragments["serverCSRConfig"] = stringFragments["domainNameDirectory"] + "/" + stringFragments["serverCSRConfigFilename"]
-	stringFragments["serverCSRConfigTemplate"] = stringFragments["templatesDirectory"] + "/" + stringFragments["serverCSRConfigFilename"]
+	//The url was validated by pki.New
+	ocspURL, _ := url.Parse(options.OCSP.URL)
+	address := listenAddress(ocspURL, options.OCSP.Listen)
 
-	stringFragments["serverConfigFilename"] = "make_server_certificate.conf"
-	stringFragments["serverConfig"] = stringFragments["domainNameDirectory"] + "/" + stringFragments["serverConfigFilename"]
-	stringFragments["serverConfigTemplate"] = stringFragments["templatesDirectory"] + "/" + stringFragments["serverConfigFilename"]
-	stringFragments["serverCertificateFilename"] = "server.crt"
-	stringFragments["serverCertificate"] = stringFragments["domainNameDirectory"] + "/" + stringFragments["serverCertificateFilename"]
-	stringFragments["serverBundleCertificate"] = stringFragments["domainNameDirectory"] + "/server_bundle.crt"
+	fmt.Println("Serving OCSP responses for " + ca.IntermediateCertificate() + " at " + options.OCSP.URL + " on " + address)
+	exitOnError(http.ListenAndServe(address, handler))
+}
 
+//Returns listen, or the host and port of serviceURL when listen is empty
+func listenAddress(serviceURL *url.URL, listen string) string {
+	if listen != "" {
+		return listen
+	}
+	if serviceURL.Port() == "" {
+		return serviceURL.Host + ":80"
+	}
+	return serviceURL.Host
 }
 
-func makeServerCertificateBundle() {
-	fmt.Println("Generating server certificate bundle")
+func serveCACommand(c command, arguments []string) {
+	var shared configurationFlags
+	flags := c.flags(&shared)
+	listen := flags.String("listen", "", "address to listen on, overriding the publish listen setting")
+	flags.Parse(arguments)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
 
-	serverCertificateData, err := ioutil.ReadFile(stringFragments["serverCertificate"])
-	if err != nil {
-		fmt.Println("Error reading server certificate during bundle generation:")
-		fmt.Println(err)
-		os.Exit(0)
+	options := shared.load(flags)
+	if *listen != "" {
+		options.Publish.Listen = *listen
 	}
+	handler, err := newCA(options).PublicationHandler()
+	exitOnError(err)
 
-	intermediateCertificateData, err := ioutil.ReadFile(stringFragments["intermediateAuthorityCertificate"])
-	if err != nil {
-		fmt.Println("Error reading intermediate certificate during bundle generation:")
-		fmt.Println(err)
-		os.Exit(0)
+	//The url was validated by pki.New
+	publishURL, _ := url.Parse(options.Publish.URL)
+	address := listenAddress(publishURL, options.Publish.Listen)
+
+	fmt.Println("Publishing the root and intermediate certificates and CRLs at " + strings.TrimSuffix(options.Publish.URL, "/") + " on " + address)
+	exitOnError(http.ListenAndServe(address, handler))
+}
+
+func serveACMECommand(c command, arguments []string) {
+	var shared configurationFlags
+	flags := c.flags(&shared)
+	listen := flags.String("listen", "", "address to listen on, overriding the acme listen setting")
+	autoApprove := flags.Bool("auto-approve", false, "approve orders for local names without validation, overriding auto_approve")
+	flags.Parse(arguments)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
 	}
 
-	rootCertificateData, err := ioutil.ReadFile(stringFragments["rootAuthorityCertificate"])
-	if err != nil {
-		fmt.Println("Error reading root certificate during bundle generation:")
-		fmt.Println(err)
-		os.Exit(0)
+	options := shared.load(flags)
+	if *listen != "" {
+		options.ACME.Listen = *listen
+	}
+	if *autoApprove {
+		options.ACME.AutoApprove = true
+	}
+	ca := newCA(options)
+	handler, err := ca.ACMEHandler()
+	exitOnError(err)
+
+	//The url was validated by pki.New
+	acmeURL, _ := url.Parse(options.ACME.URL)
+	directory := strings.TrimSuffix(options.ACME.URL, "/") + "/directory"
+	httpServer := &http.Server{Addr: listenAddress(acmeURL, options.ACME.Listen), Handler: handler}
+	if acmeURL.Scheme == "http" {
+		fmt.Println("Serving the ACME directory " + directory + " on " + httpServer.Addr)
+		exitOnError(httpServer.ListenAndServe())
 	}
 
-	certificateBundleData := append(serverCertificateData, intermediateCertificateData...)
-	certificateBundleData = append(certificateBundleData, rootCertificateData...)
+	if options.ACME.Listen == "" && acmeURL.Port() == "" {
+		httpServer.Addr = acmeURL.Host + ":443"
+	}
 
-	err = ioutil.WriteFile(stringFragments["serverBundleCertificate"], certificateBundleData, 0644)
+	//The ACME server's own certificate is an ordinary server certificate for its host name
+	leaf, err := ca.IssueServer(options.Server(acmeURL.Hostname()))
+	exitOnError(err)
 
-	if err != nil {
-		fmt.Println("Error writing to ", stringFragments["serverBundleCertificate"])
-		fmt.Println(err)
-	}
+	certificate, err := tls.LoadX509KeyPair(leaf.Chain, leaf.PrivateKey)
+	exitOnError(err)
+	httpServer.TLSConfig = &tls.Config{Certificates: []tls.Certificate{certificate}}
+
+	fmt.Println("Serving the ACME directory " + directory + " on " + httpServer.Addr)
+	exitOnError(httpServer.ListenAndServeTLS("", ""))
 }
 
-//Makes the database file and serial number needed for the OpenSSL ca command for
-//the intermediate and root certificates
-func makeDatabaseFiles() {
-	//Must also ensure the files referenced in the root authority configuration file exists
-	if !fileExists(stringFragments["rootAuthorityDatabase"]) {
-		fmt.Println("Generating root database file:" + stringFragments["rootAuthorityDatabase"])
-		rootAuthorityDatabaseFile, error := os.Create(stringFragments["rootAuthorityDatabase"])
-		if error != nil {
-			fmt.Println("Error while creating root authority database file:" + stringFragments["rootAuthorityDatabase"])
-			fmt.Println(error)
+func encryptKeysCommand(c command, arguments []string) {
+	var shared configurationFlags
+	flags := c.flags(&shared)
+	keyEncryption := flags.String("key-encryption", "", "how the keys are encrypted, pbkdf2 or scrypt, overriding key_encryption")
+	flags.Parse(arguments)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	options := shared.load(flags)
+	if *keyEncryption != "" {
+		options.Root.KeyEncryption = *keyEncryption
+		options.Intermediate.KeyEncryption = *keyEncryption
+		for index := range options.Intermediates {
+			options.Intermediates[index].KeyEncryption = *keyEncryption
 		}
+	}
+	encryption := options.Root.KeyEncryption != "none" || options.Intermediate.KeyEncryption != "none"
+	for _, intermediate := range options.Intermediates {
+		encryption = encryption || intermediate.KeyEncryption != "none"
+	}
+	if !encryption {
+		exitOnError(usageError("key_encryption is none for every authority, set it or pass -key-encryption"))
+	}
+
+	encrypted, err := newCA(options).EncryptKeys()
+	exitOnError(err)
+	if len(encrypted) == 0 {
+		fmt.Println("No unencrypted keys to encrypt")
+	}
+}
 
-		rootAuthorityDatabaseFile.Close()
+func intermediatesCommand(c command, arguments []string) {
+	var shared configurationFlags
+	flags := c.flags(&shared)
+	flags.Parse(arguments)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
 	}
 
-	if !fileExists(stringFragments["rootAuthoritySerialNumber"]) {
-		fmt.Println("Generating root serial number file:" + stringFragments["rootAuthoritySerialNumber"])
-		rootAuthoritySerialNumberFile, error := os.Create(stringFragments["rootAuthoritySerialNumber"])
-		if error != nil {
-			fmt.Println(error)
+	intermediates, err := newCA(shared.load(flags)).Intermediates()
+	exitOnError(err)
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "NAME\tCOMMON NAME\tEXPIRES\tISSUED\tPERMITTED NAMES\tDIRECTORY")
+	for _, intermediate := range intermediates {
+		name := intermediate.Name
+		if name == "" {
+			name = "(default)"
 		}
+		expires := "not created, run init"
+		if !intermediate.Expires.IsZero() {
+			expires = intermediate.Expires.Format("2006-01-02")
+		}
+		permittedNames := strings.Join(intermediate.PermittedNames, ",")
+		if permittedNames == "" {
+			permittedNames = "-"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%s\t%s\n", name, intermediate.CommonName, expires, intermediate.Issued, permittedNames, intermediate.Directory)
+	}
+	table.Flush()
+}
 
-		//Serial numbers file needs to have the hexadecimal digit 01 in it when initially created.
-		rootAuthoritySerialNumberFile.WriteString("01")
-		rootAuthoritySerialNumberFile.Close()
+func listCommand(c command, arguments []string) {
+	var shared configurationFlags
+	flags := c.flags(&shared)
+	var filter pki.ListFilter
+	flags.StringVar(&filter.Authority, "authority", "", "only list the certificates of this authority: root, intermediate or the name of a named intermediate")
+	flags.StringVar(&filter.Status, "status", "", "only list the certificates with this status: "+strings.Join(pki.CertificateStatuses, ", "))
+	flags.StringVar(&filter.Name, "name", "", "only list the certificates whose subject or subject alternative names contain this text")
+	expiresWithin := flags.Int("expires-within", 0, "only list the valid certificates that expire within this many days")
+	format := flags.String("format", "table", "output format: table, json or csv")
+	flags.Parse(arguments)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if filter.Status != "" && !slices.Contains(pki.CertificateStatuses, filter.Status) {
+		exitOnError(usageError("unknown status %q, expected one of %s", filter.Status, strings.Join(pki.CertificateStatuses, ", ")))
+	}
+	if *expiresWithin < 0 {
+		exitOnError(usageError("-expires-within must not be negative"))
+	}
+	if !slices.Contains([]string{"table", "json", "csv"}, *format) {
+		exitOnError(usageError("unknown format %q, expected table, json or csv", *format))
+	}
+	filter.ExpiresWithin = time.Duration(*expiresWithin) * 24 * time.Hour
+
+	//Progress messages would get mixed up with JSON and CSV output
+	if *format != "table" {
+		shared.log = os.Stderr
+	}
+	issued, err := newCA(shared.load(flags)).List()
+	exitOnError(err)
+
+	selected := []pki.IssuedCertificate{}
+	for _, certificate := range issued {
+		if filter.Matches(certificate) {
+			selected = append(selected, certificate)
+		}
 	}
 
-	if !fileExists(stringFragments["intermediateAuthorityDatabase"]) {
-		fmt.Println("Generating intermediate database file:" + stringFragments["intermediateAuthorityDatabase"])
-		intermediateAuthorityDatabaseFile, error := os.Create(stringFragments["intermediateAuthorityDatabase"])
-		if error != nil {
-			fmt.Println("Error while creating intermediate authority database file:" + stringFragments["intermediateAuthorityDatabase"])
-			fmt.Println(error)
+	switch *format {
+	case "json":
+		output, err := json.MarshalIndent(selected, "", "  ")
+		exitOnError(err)
+		fmt.Println(string(output))
+	case "csv":
+		writer := csv.NewWriter(os.Stdout)
+		writer.Write([]string{"authority", "status", "expires", "serial_number", "subject", "names", "issuer", "revoked", "revocation_reason", "file"})
+		for _, certificate := range selected {
+			revoked := ""
+			if !certificate.Revoked.IsZero() {
+				revoked = certificate.Revoked.Format(time.RFC3339)
+			}
+			writer.Write([]string{certificate.Authority, certificate.Status, certificate.Expires.Format(time.RFC3339), certificate.SerialNumber, certificate.Subject,
+				strings.Join(certificate.Names, " "), certificate.Issuer, revoked, certificate.RevocationReason, certificate.File})
+		}
+		writer.Flush()
+		exitOnError(writer.Error())
+	default:
+		table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "AUTHORITY\tSTATUS\tEXPIRES\tSERIAL\tSUBJECT\tNAMES\tISSUER")
+		for _, certificate := range selected {
+			names := strings.Join(certificate.Names, ", ")
+			if names == "" {
+				names = "-"
+			}
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", certificate.Authority, certificate.Status, certificate.Expires.Format("2006-01-02"), certificate.SerialNumber, certificate.Subject, names, certificate.Issuer)
 		}
+		table.Flush()
+	}
+}
 
-		intermediateAuthorityDatabaseFile.Close()
+//inspect and verify share one implementation. verify exits with status 1 when a check fails, while inspect only reports it.
+func inspectCommand(c command, arguments []string) {
+	var shared configurationFlags
+	flags := c.flags(&shared)
+	client := flags.Bool("client", false, "check the client certificate of name in output/clients instead of a server certificate")
+	hostname := flags.String("hostname", "", "host name or IP address the certificate must cover, domain.name by default for server certificates")
+	jsonOutput := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(arguments)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
 	}
 
-	if !fileExists(stringFragments["intermediateAuthoritySerialNumber"]) {
-		fmt.Println("Generating intermediate serial number file:" + stringFragments["intermediateAuthoritySerialNumber"])
-		intermediateAuthoritySerialNumberFile, error := os.Create(stringFragments["intermediateAuthoritySerialNumber"])
-		if error != nil {
-			fmt.Println(error)
+	options := shared.load(flags)
+	ca := newCA(options)
+	leaf := ca.Server(options.Server(flags.Arg(0)))
+	if *client {
+		leaf = ca.Client(options.Client(flags.Arg(0)))
+	}
+	report := leaf.Inspect(*hostname)
+
+	if *jsonOutput {
+		output, err := json.MarshalIndent(report, "", "  ")
+		exitOnError(err)
+		fmt.Println(string(output))
+	} else {
+		printReport(report)
+	}
+
+	if c.name == "verify" && !report.Valid {
+		os.Exit(exitFailure)
+	}
+}
+
+//Prints an inspection report for people, leaf first
+func printReport(report pki.InspectionReport) {
+	fmt.Println("Bundle:", report.Bundle)
+	for _, certificate := range report.Certificates {
+		fmt.Println()
+		fmt.Println("Subject:    ", certificate.Subject)
+		fmt.Println("Issuer:     ", certificate.Issuer)
+		fmt.Println("Serial:     ", certificate.SerialNumber)
+		if len(certificate.Names) > 0 {
+			fmt.Println("Names:      ", strings.Join(certificate.Names, ", "))
 		}
+		fmt.Println("Key:        ", certificate.Key)
+		fmt.Println("Not before: ", certificate.NotBefore.Format(time.RFC3339))
+		fmt.Println("Not after:  ", certificate.NotAfter.Format(time.RFC3339))
+		fmt.Printf("Remaining:   %d days\n", certificate.DaysRemaining)
+	}
 
-		//Serial numbers file needs to have the hexadecimal digit 01 in it when initially created.
-		intermediateAuthoritySerialNumberFile.WriteString("05")
-		intermediateAuthoritySerialNumberFile.Close()
+	fmt.Println()
+	for _, check := range report.Checks {
+		status := "OK"
+		if !check.Passed {
+			status = "FAILED"
+		}
+		fmt.Printf("%-8s %-6s %s\n", check.Check, status, check.Detail)
 	}
 }
 
-//Usage: go run generate_certificates.go <domain.name>
-//domain.name will be created as a directory and files generated by generate_certificates.go will go into the directory with name "domain.name".
+func exportPKCS12Command(c command, arguments []string) {
+	var shared configurationFlags
+	flags := c.flags(&shared)
+	password := flags.String("password", "", "password protecting the keystores, instead of the PKCS12_PASSWORD environment variable")
+	encryption := flags.String("encryption", "modern", "modern (AES-256 with a SHA-256 MAC) or legacy (3DES with a SHA-1 MAC) for older Java, .NET and Windows versions")
+	friendlyName := flags.String("name", "", "friendly name, or alias, of the server entry, domain.name by default")
+	ca, leaf := parseDomainCommand(c, flags, &shared, arguments)
+	exitOnError(ca.CheckAuthorities())
+
+	if !slices.Contains(pki.PKCS12Encryptions, *encryption) {
+		exitOnError(usageError("unknown encryption %q, expected one of %s", *encryption, strings.Join(pki.PKCS12Encryptions, ", ")))
+	}
+	if *password == "" {
+		*password = os.Getenv("PKCS12_PASSWORD")
+	}
+	if *password == "" {
+		exitOnError(usageError("a password is required, pass -password or set PKCS12_PASSWORD"))
+	}
 
-//In the code, the term "server" refers to the computer hosting the name domain.name
-func main() {
-	//Force there to be exactly two arguments, the name of the file and the domain name
-	if len(os.Args) != 2 {
-		fmt.Println("Error: no domain name specified.")
-		fmt.Println("usage: go run generate_certificates.go <domain.name>")
-		os.Exit(0)
+	exitOnError(leaf.ExportPKCS12(*friendlyName, *password, *encryption))
+	exitOnError(ca.ExportTruststore(filepath.Join(leaf.Directory, "truststore.p12"), *password, *encryption))
+}
+
+func exportKubernetesCommand(c command, arguments []string) {
+	var shared configurationFlags
+	flags := c.flags(&shared)
+	client := flags.Bool("client", false, "export the client certificate of name in output/clients instead of a server certificate")
+	issuer := flags.Bool("issuer", false, "export the intermediate authority as a cert-manager issuer instead of a certificate")
+	intermediate := flags.String("intermediate", "", "export the named intermediate authority called this with -issuer, instead of the default one")
+	namespace := flags.String("namespace", "", "namespace of the Secret and the Issuer, overriding namespace")
+	name := flags.String("name", "", "name of the Secret, <name>-tls by default, or of the issuer with -issuer, overriding issuer")
+	secret := flags.String("secret", "", "name of the Secret holding the intermediate authority's key with -issuer, overriding ca_secret")
+	kind := flags.String("kind", "", "Issuer or ClusterIssuer with -issuer, overriding issuer_kind")
+	out := flags.String("out", "", "file the manifests are written to, or - for standard output")
+	flags.Parse(arguments)
+	if (*issuer && flags.NArg() != 0) || (!*issuer && flags.NArg() != 1) {
+		flags.Usage()
+		os.Exit(exitUsage)
 	}
+	if !*issuer && (*intermediate != "" || *secret != "" || *kind != "") {
+		exitOnError(usageError("-intermediate, -secret and -kind only apply to -issuer"))
+	}
+	if *issuer && *client {
+		exitOnError(usageError("-client does not apply to -issuer"))
+	}
+
+	if *out == "-" {
+		shared.log = os.Stderr
+	}
+	options := shared.load(flags)
+	ca := newCA(options)
+
+	var manifests []byte
+	var err error
+	if *issuer {
+		kubernetes := options.Kubernetes
+		if *namespace != "" {
+			kubernetes.Namespace = *namespace
+		}
+		if *name != "" {
+			kubernetes.IssuerName = *name
+		}
+		if *secret != "" {
+			kubernetes.CASecretName = *secret
+		}
+		if *kind != "" {
+			kubernetes.IssuerKind = *kind
+		}
+		manifests, err = ca.KubernetesIssuer(*intermediate, kubernetes)
+		exitOnError(err)
+
+		if *out == "" {
+			intermediates, err := ca.Intermediates()
+			exitOnError(err)
+			for _, authority := range intermediates {
+				if authority.Name == *intermediate {
+					*out = filepath.Join(authority.Directory, "cert_manager_issuer.yaml")
+				}
+			}
+		}
+	} else {
+		leaf := ca.Server(options.Server(flags.Arg(0)))
+		if *client {
+			leaf = ca.Client(options.Client(flags.Arg(0)))
+		}
+		manifests, err = leaf.KubernetesSecret(*name, *namespace)
+		exitOnError(err)
 
-	stringFragments["domainName"] = os.Args[1]
+		if *out == "" {
+			*out = filepath.Join(leaf.Directory, "kubernetes_secret.yaml")
+		}
+	}
 
-	//Stage 1
-	initializeStringFragments()
+	if *out == "-" {
+		os.Stdout.Write(manifests)
+		return
+	}
+	//The manifests hold private keys
+	exitOnError(os.WriteFile(*out, manifests, 0600))
+	fmt.Println("Kubernetes manifests: " + *out)
+}
 
-	stringFragments["domainNameDirectory"] = stringFragments["outputDirectory"] + "/" + stringFragments["domainName"]
+func trustCommand(c command, arguments []string) {
+	var shared configurationFlags
+	flags := c.flags(&shared)
+	system := flags.Bool("system", true, "change the system trust store")
+	nss := flags.Bool("nss", true, "change the NSS databases of the current user, used by Chrome and Firefox")
+	flags.Parse(arguments)
+	action := flags.Arg(0)
+	if flags.NArg() != 1 || (action != "install" && action != "uninstall" && action != "status") {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
 
-	//Stage 2
-	makeDirectories()
-	makeDatabaseFiles()
+	ca := newCA(shared.load(flags))
+	nickname, results, err := ca.Trust(action, *system, *nss)
+	exitOnError(err)
+
+	fmt.Println("Root certificate " + ca.RootCertificate() + " (" + nickname + ")")
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "STORE\tLOCATION\tSTATUS")
+	failures := 0
+	for _, result := range results {
+		fmt.Fprintf(table, "%s\t%s\t%s\n", result.Store, result.Location, result.Status)
+		if result.Failed {
+			failures++
+		}
+	}
+	table.Flush()
 
-	//Stage 3
-	makePrivateKeys()
+	if failures > 0 {
+		exitOnError(fmt.Errorf("trust %s failed for %d of %d stores", action, failures, len(results)))
+	}
+}
 
-	//Stage 4.
-	makeRootAuthorityCertificate()
+//Usage: go run generate_certificates.go <command> [flags] [arguments]
+//Run go run generate_certificates.go init once to create the root and intermediate authorities,
+//then go run generate_certificates.go issue <domain.name> [name...] for each server.
+//domain.name will be created as a directory and files generated by generate_certificates.go will go into the directory with name "domain.name".
 
-	makeIntermediateAuthorityCertificate()
+//In the code, the term "server" refers to the computer hosting the name domain.name
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(exitUsage)
+	}
+	if os.Args[1] == "help" || os.Args[1] == "-h" || os.Args[1] == "-help" || os.Args[1] == "--help" {
+		printUsage()
+		return
+	}
 
-	makeServerCertificate()
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			c.run(c, os.Args[2:])
+			return
+		}
+	}
 
-	makeServerCertificateBundle()
+	fmt.Fprintln(os.Stderr, "Error: unknown command "+os.Args[1]+".")
+	fmt.Println("To create the authorities and a certificate for a domain name, run init and then issue <domain.name>.")
+	printUsage()
+	os.Exit(exitUsage)
 }
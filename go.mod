@@ -0,0 +1,3 @@
+module github.com/raymond1/generate_certificates
+
+go 1.25
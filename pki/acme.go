@@ -0,0 +1,1342 @@
+package pki
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//The ACME (RFC 8555) server run by serve-acme issues server certificates from the intermediate authority to clients
+//such as certbot, lego, Caddy and cert-manager. Accounts are kept in output/acme/accounts.json so that clients stay
+//registered across restarts, and the account that ordered each certificate in output/acme/certificate_accounts.json so
+//that it can still revoke it after a restart. Orders, authorizations and challenges only live as long as the server
+//process, and are forgotten once they expire.
+
+const acmeErrorNamespace = "urn:ietf:params:acme:error:"
+
+//How long a nonce can be used for, how long an order and its authorizations can be completed in, and how often
+//the expired ones are forgotten
+const (
+	acmeNonceLifetime = time.Hour
+	acmeOrderLifetime = 7 * 24 * time.Hour
+	acmePruneInterval = time.Minute
+)
+
+//The request signing algorithms accepted in the alg header of JWS requests
+var acmeSignatureAlgorithms = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+type acmeAccount struct {
+	ID      string          `json:"id"`
+	Key     json.RawMessage `json:"key"`
+	Contact []string        `json:"contact,omitempty"`
+	Status  string          `json:"status"`
+
+	publicKey  crypto.PublicKey
+	thumbprint string
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeOrder struct {
+	id             string
+	accountID      string
+	status         string
+	expires        time.Time
+	identifiers    []acmeIdentifier
+	authorizations []*acmeAuthorization
+	certificate    string
+	problem        *acmeProblem
+}
+
+type acmeAuthorization struct {
+	id         string
+	accountID  string
+	identifier acmeIdentifier
+	wildcard   bool
+	status     string
+	expires    time.Time
+	challenges []*acmeChallenge
+}
+
+type acmeChallenge struct {
+	id            string
+	authorization *acmeAuthorization
+	challengeType string
+	token         string
+	status        string
+	validated     time.Time
+	problem       *acmeProblem
+}
+
+//An RFC 7807 problem document, returned with status as the HTTP status code
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	status int
+}
+
+func (problem *acmeProblem) Error() string {
+	return problem.Detail
+}
+
+func newACMEProblem(status int, problemType, format string, args ...any) *acmeProblem {
+	return &acmeProblem{Type: acmeErrorNamespace + problemType, Detail: fmt.Sprintf(format, args...), status: status}
+}
+
+type acmeServer struct {
+	ca           *CA
+	mutex        sync.Mutex
+	baseURL      string
+	origin       string
+	validityDays string
+	autoApprove  bool
+	localDomains []string
+	httpPort     string
+	resolver     *net.Resolver
+
+	accounts       map[string]*acmeAccount
+	nonces         map[string]time.Time
+	orders         map[string]*acmeOrder
+	authorizations map[string]*acmeAuthorization
+	challenges     map[string]*acmeChallenge
+	pruned         time.Time
+	//The account that ordered each issued certificate, by serial number, which is allowed to revoke it
+	certificateAccounts map[string]string
+}
+
+func newACMEServer(ca *CA) (*acmeServer, error) {
+	//The url was validated with the options
+	options := ca.options.ACME
+	acmeURL, _ := url.Parse(options.URL)
+	server := &acmeServer{
+		ca:                  ca,
+		baseURL:             strings.TrimSuffix(options.URL, "/"),
+		origin:              acmeURL.Scheme + "://" + acmeURL.Host,
+		validityDays:        strconv.Itoa(options.ValidityDays),
+		autoApprove:         options.AutoApprove,
+		localDomains:        options.LocalDomains,
+		httpPort:            strconv.Itoa(options.HTTPPort),
+		resolver:            net.DefaultResolver,
+		accounts:            map[string]*acmeAccount{},
+		nonces:              map[string]time.Time{},
+		orders:              map[string]*acmeOrder{},
+		authorizations:      map[string]*acmeAuthorization{},
+		challenges:          map[string]*acmeChallenge{},
+		certificateAccounts: map[string]string{},
+	}
+
+	if options.DNSResolver != "" {
+		server.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, options.DNSResolver)
+			},
+		}
+	}
+
+	if fileExists(server.ca.fragments["acmeAccounts"]) {
+		data, err := ioutil.ReadFile(server.ca.fragments["acmeAccounts"])
+		if err != nil {
+			return nil, err
+		}
+
+		var accounts []*acmeAccount
+		err = json.Unmarshal(data, &accounts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", server.ca.fragments["acmeAccounts"], err)
+		}
+
+		for _, account := range accounts {
+			account.publicKey, account.thumbprint, err = parseJSONWebKey(account.Key)
+			if err != nil {
+				return nil, fmt.Errorf("%s: account %s: %w", server.ca.fragments["acmeAccounts"], account.ID, err)
+			}
+			server.accounts[account.ID] = account
+		}
+	}
+
+	if fileExists(server.ca.fragments["acmeCertificateAccounts"]) {
+		data, err := ioutil.ReadFile(server.ca.fragments["acmeCertificateAccounts"])
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(data, &server.certificateAccounts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", server.ca.fragments["acmeCertificateAccounts"], err)
+		}
+	}
+	return server, nil
+}
+
+//Registers the ACME endpoints on mux, under the path of the server's url
+func (server *acmeServer) register(mux *http.ServeMux) {
+	acmeURL, _ := url.Parse(server.baseURL)
+	path := strings.TrimSuffix(acmeURL.Path, "/")
+	server.handle(mux, "GET "+path+"/directory", server.directory)
+	server.handle(mux, "HEAD "+path+"/new-nonce", server.newNonce)
+	server.handle(mux, "GET "+path+"/new-nonce", server.newNonce)
+	server.handle(mux, "POST "+path+"/new-account", server.newAccount)
+	server.handle(mux, "POST "+path+"/account/{id}", server.updateAccount)
+	server.handle(mux, "POST "+path+"/account/{id}/orders", server.listOrders)
+	server.handle(mux, "POST "+path+"/new-order", server.newOrder)
+	server.handle(mux, "POST "+path+"/order/{id}", server.getOrder)
+	server.handle(mux, "POST "+path+"/authorization/{id}", server.getAuthorization)
+	server.handle(mux, "POST "+path+"/challenge/{id}", server.respondToChallenge)
+	server.handle(mux, "POST "+path+"/finalize/{id}", server.finalizeOrder)
+	server.handle(mux, "POST "+path+"/certificate/{id}", server.downloadCertificate)
+	server.handle(mux, "POST "+path+"/revoke-certificate", server.revokeCertificate)
+}
+
+//Wraps an endpoint so that every response carries a fresh nonce, and problems are written as problem documents
+func (server *acmeServer) handle(mux *http.ServeMux, pattern string, endpoint func(http.ResponseWriter, *http.Request) *acmeProblem) {
+	mux.HandleFunc(pattern, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Replay-Nonce", server.nonce())
+		writer.Header().Set("Cache-Control", "no-store")
+		writer.Header().Add("Link", "<"+server.baseURL+"/directory>;rel=\"index\"")
+
+		problem := endpoint(writer, request)
+		if problem != nil {
+			fmt.Fprintln(server.ca.log, "ACME:", request.URL.Path, problem.Detail)
+			writer.Header().Set("Content-Type", "application/problem+json")
+			writer.WriteHeader(problem.status)
+			json.NewEncoder(writer).Encode(problem)
+		}
+	})
+}
+
+func (server *acmeServer) nonce() string {
+	nonce := randomToken(16)
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(server.pruned) >= acmePruneInterval {
+		server.prune(now)
+	}
+
+	//Forgetting old nonces only makes their clients retry with a new one
+	if len(server.nonces) > 10000 {
+		server.nonces = map[string]time.Time{}
+	}
+	server.nonces[nonce] = now.Add(acmeNonceLifetime)
+	return nonce
+}
+
+//Forgets the expired nonces, and the expired orders along with their authorizations and challenges, so that a
+//long running server doesn't keep every one it has handed out. The caller holds the mutex.
+func (server *acmeServer) prune(now time.Time) {
+	server.pruned = now
+	for nonce, expires := range server.nonces {
+		if now.After(expires) {
+			delete(server.nonces, nonce)
+		}
+	}
+
+	for id, order := range server.orders {
+		if !now.After(order.expires) {
+			continue
+		}
+		delete(server.orders, id)
+		for _, authorization := range order.authorizations {
+			delete(server.authorizations, authorization.id)
+			for _, challenge := range authorization.challenges {
+				delete(server.challenges, challenge.id)
+			}
+		}
+	}
+}
+
+//Returns a random base64url string made of size random bytes
+func randomToken(size int) string {
+	token := make([]byte, size)
+	rand.Read(token)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+func writeJSON(writer http.ResponseWriter, status int, value any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+func (server *acmeServer) directory(writer http.ResponseWriter, request *http.Request) *acmeProblem {
+	writeJSON(writer, http.StatusOK, map[string]string{
+		"newNonce":   server.baseURL + "/new-nonce",
+		"newAccount": server.baseURL + "/new-account",
+		"newOrder":   server.baseURL + "/new-order",
+		"revokeCert": server.baseURL + "/revoke-certificate",
+	})
+	return nil
+}
+
+func (server *acmeServer) newNonce(writer http.ResponseWriter, request *http.Request) *acmeProblem {
+	if request.Method == http.MethodGet {
+		writer.WriteHeader(http.StatusNoContent)
+	}
+	return nil
+}
+
+//A request body in the flattened JSON serialization of JWS
+type acmeJWS struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type acmeProtectedHeader struct {
+	Algorithm string          `json:"alg"`
+	Nonce     string          `json:"nonce"`
+	URL       string          `json:"url"`
+	KeyID     string          `json:"kid"`
+	JWK       json.RawMessage `json:"jwk"`
+}
+
+//A verified request. Requests signed with an account's key ID carry the account. Requests signed with a key
+//in the jwk header, which are only accepted by newAccount and revokeCert, carry that key instead.
+type acmeRequest struct {
+	payload    []byte
+	account    *acmeAccount
+	jwk        json.RawMessage
+	publicKey  crypto.PublicKey
+	thumbprint string
+}
+
+//Checks the signature, nonce and url of a JWS request. allowJWK accepts requests signed with a key that
+//isn't registered to an account.
+func (server *acmeServer) verify(request *http.Request, allowJWK bool) (acmeRequest, *acmeProblem) {
+	var verified acmeRequest
+	body, err := io.ReadAll(io.LimitReader(request.Body, 1<<20))
+	if err != nil {
+		return verified, newACMEProblem(http.StatusBadRequest, "malformed", "error reading the request: %v", err)
+	}
+
+	var jws acmeJWS
+	err = json.Unmarshal(body, &jws)
+	if err != nil {
+		return verified, newACMEProblem(http.StatusBadRequest, "malformed", "the request is not a flattened JWS: %v", err)
+	}
+
+	protected, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		return verified, newACMEProblem(http.StatusBadRequest, "malformed", "invalid protected header encoding")
+	}
+
+	var header acmeProtectedHeader
+	err = json.Unmarshal(protected, &header)
+	if err != nil {
+		return verified, newACMEProblem(http.StatusBadRequest, "malformed", "invalid protected header: %v", err)
+	}
+
+	if !slices.Contains(acmeSignatureAlgorithms, header.Algorithm) {
+		return verified, newACMEProblem(http.StatusBadRequest, "badSignatureAlgorithm", "unsupported signature algorithm %q, expected one of %s", header.Algorithm, strings.Join(acmeSignatureAlgorithms, ", "))
+	}
+
+	if header.URL != server.origin+request.URL.Path {
+		return verified, newACMEProblem(http.StatusUnauthorized, "unauthorized", "the url header %q does not match the request URL", header.URL)
+	}
+
+	server.mutex.Lock()
+	expires, validNonce := server.nonces[header.Nonce]
+	validNonce = validNonce && time.Now().Before(expires)
+	delete(server.nonces, header.Nonce)
+	server.mutex.Unlock()
+	if !validNonce {
+		return verified, newACMEProblem(http.StatusBadRequest, "badNonce", "unknown or reused nonce %q", header.Nonce)
+	}
+
+	switch {
+	case header.KeyID != "" && header.JWK == nil:
+		id, found := strings.CutPrefix(header.KeyID, server.baseURL+"/account/")
+		server.mutex.Lock()
+		account := server.accounts[id]
+		server.mutex.Unlock()
+		if !found || account == nil {
+			return verified, newACMEProblem(http.StatusBadRequest, "accountDoesNotExist", "unknown account %q", header.KeyID)
+		}
+		if account.Status != "valid" {
+			return verified, newACMEProblem(http.StatusUnauthorized, "unauthorized", "the account is %s", account.Status)
+		}
+		verified.account = account
+		verified.publicKey = account.publicKey
+		verified.thumbprint = account.thumbprint
+	case header.KeyID == "" && header.JWK != nil && allowJWK:
+		verified.jwk = header.JWK
+		verified.publicKey, verified.thumbprint, err = parseJSONWebKey(header.JWK)
+		if err != nil {
+			return verified, newACMEProblem(http.StatusBadRequest, "badPublicKey", "%v", err)
+		}
+	case allowJWK:
+		return verified, newACMEProblem(http.StatusBadRequest, "malformed", "the protected header must have exactly one of jwk and kid")
+	default:
+		return verified, newACMEProblem(http.StatusBadRequest, "malformed", "the protected header must have a kid and no jwk")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(jws.Signature)
+	if err != nil {
+		return verified, newACMEProblem(http.StatusBadRequest, "malformed", "invalid signature encoding")
+	}
+
+	err = verifyJWSSignature(verified.publicKey, header.Algorithm, []byte(jws.Protected+"."+jws.Payload), signature)
+	if err != nil {
+		return verified, newACMEProblem(http.StatusBadRequest, "malformed", "%v", err)
+	}
+
+	verified.payload, err = base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return verified, newACMEProblem(http.StatusBadRequest, "malformed", "invalid payload encoding")
+	}
+	return verified, nil
+}
+
+//The members of an RSA, EC or OKP JSON Web Key (RFC 7517)
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	Curve   string `json:"crv"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+//Returns the public key in a JSON Web Key and its RFC 7638 thumbprint, which is part of every key authorization
+func parseJSONWebKey(raw json.RawMessage) (crypto.PublicKey, string, error) {
+	var key jsonWebKey
+	err := json.Unmarshal(raw, &key)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid jwk: %w", err)
+	}
+
+	decode := func(values ...string) ([][]byte, error) {
+		decoded := [][]byte{}
+		for _, value := range values {
+			bytes, err := base64.RawURLEncoding.DecodeString(value)
+			if err != nil || len(bytes) == 0 {
+				return nil, fmt.Errorf("invalid %s jwk", key.KeyType)
+			}
+			decoded = append(decoded, bytes)
+		}
+		return decoded, nil
+	}
+
+	//The thumbprint is the SHA-256 hash of the required members in lexicographic order, without whitespace
+	var publicKey crypto.PublicKey
+	var canonical string
+	switch key.KeyType {
+	case "RSA":
+		values, err := decode(key.N, key.E)
+		if err != nil {
+			return nil, "", err
+		}
+
+		exponent := new(big.Int).SetBytes(values[1])
+		if !exponent.IsInt64() || exponent.Int64() > math.MaxInt32 {
+			return nil, "", errors.New("invalid RSA jwk exponent")
+		}
+
+		rsaKey := &rsa.PublicKey{N: new(big.Int).SetBytes(values[0]), E: int(exponent.Int64())}
+		if rsaKey.N.BitLen() < 2048 {
+			return nil, "", fmt.Errorf("RSA account keys must be at least 2048 bits, not %d", rsaKey.N.BitLen())
+		}
+		publicKey = rsaKey
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, key.E, key.N)
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[key.Curve]
+		if !ok {
+			return nil, "", fmt.Errorf("unsupported EC jwk curve %q", key.Curve)
+		}
+
+		values, err := decode(key.X, key.Y)
+		if err != nil {
+			return nil, "", err
+		}
+
+		size := (curve.Params().BitSize + 7) / 8
+		if len(values[0]) != size || len(values[1]) != size {
+			return nil, "", errors.New("invalid EC jwk coordinates")
+		}
+
+		publicKey, err = ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, values[0]...), values[1]...))
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid EC jwk: %w", err)
+		}
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, key.Curve, key.X, key.Y)
+	case "OKP":
+		if key.Curve != "Ed25519" {
+			return nil, "", fmt.Errorf("unsupported OKP jwk curve %q", key.Curve)
+		}
+
+		values, err := decode(key.X)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(values[0]) != ed25519.PublicKeySize {
+			return nil, "", errors.New("invalid Ed25519 jwk")
+		}
+		publicKey = ed25519.PublicKey(values[0])
+		canonical = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, key.X)
+	default:
+		return nil, "", fmt.Errorf("unsupported jwk key type %q", key.KeyType)
+	}
+
+	thumbprint := sha256.Sum256([]byte(canonical))
+	return publicKey, base64.RawURLEncoding.EncodeToString(thumbprint[:]), nil
+}
+
+//Checks a JWS signature made with algorithm over signingInput
+func verifyJWSSignature(publicKey crypto.PublicKey, algorithm string, signingInput, signature []byte) error {
+	hashes := map[string]crypto.Hash{
+		"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+		"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+	}
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(algorithm, "RS") {
+			break
+		}
+		hash := hashes[algorithm].New()
+		hash.Write(signingInput)
+		if rsa.VerifyPKCS1v15(key, hashes[algorithm], hash.Sum(nil), signature) != nil {
+			return errors.New("invalid JWS signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		//JWS signs with the curve's own hash, and encodes the signature as r and s padded to the size of the curve
+		curveAlgorithms := map[string]string{"P-256": "ES256", "P-384": "ES384", "P-521": "ES512"}
+		if curveAlgorithms[key.Curve.Params().Name] != algorithm {
+			break
+		}
+
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid JWS signature length")
+		}
+
+		hash := hashes[algorithm].New()
+		hash.Write(signingInput)
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, hash.Sum(nil), r, s) {
+			return errors.New("invalid JWS signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if algorithm != "EdDSA" {
+			break
+		}
+		if !ed25519.Verify(key, signingInput, signature) {
+			return errors.New("invalid JWS signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("the signature algorithm %s does not match the key", algorithm)
+}
+
+//The account object returned by newAccount and account requests
+func (server *acmeServer) accountObject(account *acmeAccount) map[string]any {
+	return map[string]any{
+		"status":  account.Status,
+		"contact": account.Contact,
+		"orders":  server.baseURL + "/account/" + account.ID + "/orders",
+	}
+}
+
+//Saves the accounts to output/acme/accounts.json. The caller holds the mutex.
+func (server *acmeServer) saveAccounts() error {
+	accounts := []*acmeAccount{}
+	for _, account := range server.accounts {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(server.ca.fragments["acmeAccounts"], data, 0600)
+}
+
+func (server *acmeServer) newAccount(writer http.ResponseWriter, request *http.Request) *acmeProblem {
+	verified, problem := server.verify(request, true)
+	if problem != nil {
+		return problem
+	}
+	if verified.account != nil {
+		return newACMEProblem(http.StatusBadRequest, "malformed", "newAccount requests must be signed with a jwk")
+	}
+
+	var payload struct {
+		Contact            []string `json:"contact"`
+		OnlyReturnExisting bool     `json:"onlyReturnExisting"`
+	}
+	err := json.Unmarshal(verified.payload, &payload)
+	if err != nil {
+		return newACMEProblem(http.StatusBadRequest, "malformed", "invalid account: %v", err)
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for _, account := range server.accounts {
+		if account.thumbprint == verified.thumbprint {
+			writer.Header().Set("Location", server.baseURL+"/account/"+account.ID)
+			writeJSON(writer, http.StatusOK, server.accountObject(account))
+			return nil
+		}
+	}
+
+	if payload.OnlyReturnExisting {
+		return newACMEProblem(http.StatusBadRequest, "accountDoesNotExist", "no account exists for this key")
+	}
+
+	for _, contact := range payload.Contact {
+		if !strings.HasPrefix(contact, "mailto:") {
+			return newACMEProblem(http.StatusBadRequest, "unsupportedContact", "unsupported contact %q, only mailto: is supported", contact)
+		}
+	}
+
+	account := &acmeAccount{ID: randomToken(12), Key: verified.jwk, Contact: payload.Contact, Status: "valid", publicKey: verified.publicKey, thumbprint: verified.thumbprint}
+	server.accounts[account.ID] = account
+	err = server.saveAccounts()
+	if err != nil {
+		return newACMEProblem(http.StatusInternalServerError, "serverInternal", "error saving the account: %v", err)
+	}
+
+	fmt.Fprintln(server.ca.log, "ACME: registered account", account.ID)
+	writer.Header().Set("Location", server.baseURL+"/account/"+account.ID)
+	writeJSON(writer, http.StatusCreated, server.accountObject(account))
+	return nil
+}
+
+//Returns the account, or updates its contacts or deactivates it
+func (server *acmeServer) updateAccount(writer http.ResponseWriter, request *http.Request) *acmeProblem {
+	verified, problem := server.verify(request, false)
+	if problem != nil {
+		return problem
+	}
+	if verified.account.ID != request.PathValue("id") {
+		return newACMEProblem(http.StatusUnauthorized, "unauthorized", "the request is not signed by this account")
+	}
+
+	var payload struct {
+		Contact []string `json:"contact"`
+		Status  string   `json:"status"`
+	}
+	if len(verified.payload) > 0 {
+		err := json.Unmarshal(verified.payload, &payload)
+		if err != nil {
+			return newACMEProblem(http.StatusBadRequest, "malformed", "invalid account update: %v", err)
+		}
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	account := verified.account
+	if payload.Contact != nil || payload.Status != "" {
+		if payload.Contact != nil {
+			account.Contact = payload.Contact
+		}
+		if payload.Status == "deactivated" {
+			account.Status = "deactivated"
+		} else if payload.Status != "" {
+			return newACMEProblem(http.StatusBadRequest, "malformed", "accounts can only be deactivated")
+		}
+
+		err := server.saveAccounts()
+		if err != nil {
+			return newACMEProblem(http.StatusInternalServerError, "serverInternal", "error saving the account: %v", err)
+		}
+	}
+	writeJSON(writer, http.StatusOK, server.accountObject(account))
+	return nil
+}
+
+func (server *acmeServer) listOrders(writer http.ResponseWriter, request *http.Request) *acmeProblem {
+	verified, problem := server.verify(request, false)
+	if problem != nil {
+		return problem
+	}
+	if verified.account.ID != request.PathValue("id") {
+		return newACMEProblem(http.StatusUnauthorized, "unauthorized", "the request is not signed by this account")
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	orders := []string{}
+	for _, order := range server.orders {
+		if order.accountID == verified.account.ID {
+			orders = append(orders, server.baseURL+"/order/"+order.id)
+		}
+	}
+	sort.Strings(orders)
+	writeJSON(writer, http.StatusOK, map[string][]string{"orders": orders})
+	return nil
+}
+
+//Returns true for the names that auto-approve issues without validation: names in the local domains
+//and loopback, private and link-local addresses
+func (server *acmeServer) isLocalName(identifier acmeIdentifier) bool {
+	if identifier.Type == "ip" {
+		address := net.ParseIP(identifier.Value)
+		return address.IsLoopback() || address.IsPrivate() || address.IsLinkLocalUnicast()
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(identifier.Value, "."))
+	for _, domain := range server.localDomains {
+		domain = strings.ToLower(strings.Trim(domain, "."))
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func (server *acmeServer) newOrder(writer http.ResponseWriter, request *http.Request) *acmeProblem {
+	verified, problem := server.verify(request, false)
+	if problem != nil {
+		return problem
+	}
+
+	var payload struct {
+		Identifiers []acmeIdentifier `json:"identifiers"`
+	}
+	err := json.Unmarshal(verified.payload, &payload)
+	if err != nil || len(payload.Identifiers) == 0 {
+		return newACMEProblem(http.StatusBadRequest, "malformed", "the order must list identifiers")
+	}
+
+	for _, identifier := range payload.Identifiers {
+		switch identifier.Type {
+		case "dns":
+			nameType, err := subjectAlternativeNameType(identifier.Value)
+			if err != nil || nameType != "DNS" {
+				return newACMEProblem(http.StatusBadRequest, "rejectedIdentifier", "invalid DNS identifier %q", identifier.Value)
+			}
+		case "ip":
+			if net.ParseIP(identifier.Value) == nil {
+				return newACMEProblem(http.StatusBadRequest, "rejectedIdentifier", "invalid IP identifier %q", identifier.Value)
+			}
+		default:
+			return newACMEProblem(http.StatusBadRequest, "unsupportedIdentifier", "unsupported identifier type %q", identifier.Type)
+		}
+
+		err = server.ca.CheckNameConstraints("", []string{identifier.Value})
+		if err != nil {
+			return newACMEProblem(http.StatusBadRequest, "rejectedIdentifier", "%s", err)
+		}
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	expires := time.Now().UTC().Add(acmeOrderLifetime).Truncate(time.Second)
+	order := &acmeOrder{id: randomToken(12), accountID: verified.account.ID, status: "pending", expires: expires, identifiers: payload.Identifiers}
+	for _, identifier := range payload.Identifiers {
+		authorization := &acmeAuthorization{id: randomToken(12), accountID: verified.account.ID, identifier: identifier, status: "pending", expires: expires}
+		if value, wildcard := strings.CutPrefix(identifier.Value, "*."); wildcard {
+			authorization.identifier.Value = value
+			authorization.wildcard = true
+		}
+
+		//Wildcards can only be proven through DNS, and addresses have no DNS name to prove
+		challengeTypes := []string{"http-01", "dns-01"}
+		if authorization.wildcard {
+			challengeTypes = []string{"dns-01"}
+		} else if identifier.Type == "ip" {
+			challengeTypes = []string{"http-01"}
+		}
+		for _, challengeType := range challengeTypes {
+			challenge := &acmeChallenge{id: randomToken(12), authorization: authorization, challengeType: challengeType, token: randomToken(32), status: "pending"}
+			authorization.challenges = append(authorization.challenges, challenge)
+			server.challenges[challenge.id] = challenge
+		}
+
+		if server.autoApprove && server.isLocalName(identifier) {
+			fmt.Fprintln(server.ca.log, "ACME: auto-approved", identifier.Value)
+			authorization.status = "valid"
+		}
+		server.authorizations[authorization.id] = authorization
+		order.authorizations = append(order.authorizations, authorization)
+	}
+	server.updateOrderStatus(order)
+	server.orders[order.id] = order
+
+	writer.Header().Set("Location", server.baseURL+"/order/"+order.id)
+	writeJSON(writer, http.StatusCreated, server.orderObject(order))
+	return nil
+}
+
+//Moves a pending order to ready once all of its authorizations are valid, or to invalid when one of them fails.
+//The caller holds the mutex.
+func (server *acmeServer) updateOrderStatus(order *acmeOrder) {
+	if order.status != "pending" {
+		return
+	}
+
+	ready := true
+	for _, authorization := range order.authorizations {
+		switch authorization.status {
+		case "valid":
+		case "pending":
+			ready = false
+		default:
+			order.status = "invalid"
+			order.problem = newACMEProblem(http.StatusForbidden, "unauthorized", "the authorization for %s is %s", authorization.identifier.Value, authorization.status)
+			return
+		}
+	}
+	if ready {
+		order.status = "ready"
+	}
+}
+
+//The order object returned by order requests. The caller holds the mutex.
+func (server *acmeServer) orderObject(order *acmeOrder) map[string]any {
+	authorizations := []string{}
+	for _, authorization := range order.authorizations {
+		authorizations = append(authorizations, server.baseURL+"/authorization/"+authorization.id)
+	}
+
+	object := map[string]any{
+		"status":         order.status,
+		"expires":        order.expires.Format(time.RFC3339),
+		"identifiers":    order.identifiers,
+		"authorizations": authorizations,
+		"finalize":       server.baseURL + "/finalize/" + order.id,
+	}
+	if order.certificate != "" {
+		object["certificate"] = server.baseURL + "/certificate/" + order.id
+	}
+	if order.problem != nil {
+		object["error"] = order.problem
+	}
+	return object
+}
+
+//Returns the order named in the request path if it belongs to the account that signed the request
+func (server *acmeServer) accountOrder(request *http.Request, verified acmeRequest) (*acmeOrder, *acmeProblem) {
+	order := server.orders[request.PathValue("id")]
+	if order == nil {
+		return nil, newACMEProblem(http.StatusNotFound, "malformed", "unknown order")
+	}
+	if order.accountID != verified.account.ID {
+		return nil, newACMEProblem(http.StatusUnauthorized, "unauthorized", "the order belongs to another account")
+	}
+	return order, nil
+}
+
+func (server *acmeServer) getOrder(writer http.ResponseWriter, request *http.Request) *acmeProblem {
+	verified, problem := server.verify(request, false)
+	if problem != nil {
+		return problem
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	order, problem := server.accountOrder(request, verified)
+	if problem != nil {
+		return problem
+	}
+	writeJSON(writer, http.StatusOK, server.orderObject(order))
+	return nil
+}
+
+//The challenge object returned by authorization and challenge requests. The caller holds the mutex.
+func (server *acmeServer) challengeObject(challenge *acmeChallenge) map[string]any {
+	object := map[string]any{
+		"type":   challenge.challengeType,
+		"url":    server.baseURL + "/challenge/" + challenge.id,
+		"token":  challenge.token,
+		"status": challenge.status,
+	}
+	if !challenge.validated.IsZero() {
+		object["validated"] = challenge.validated.Format(time.RFC3339)
+	}
+	if challenge.problem != nil {
+		object["error"] = challenge.problem
+	}
+	return object
+}
+
+func (server *acmeServer) getAuthorization(writer http.ResponseWriter, request *http.Request) *acmeProblem {
+	verified, problem := server.verify(request, false)
+	if problem != nil {
+		return problem
+	}
+
+	var payload struct {
+		Status string `json:"status"`
+	}
+	if len(verified.payload) > 0 {
+		err := json.Unmarshal(verified.payload, &payload)
+		if err != nil || payload.Status != "deactivated" {
+			return newACMEProblem(http.StatusBadRequest, "malformed", "authorizations can only be deactivated")
+		}
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	authorization := server.authorizations[request.PathValue("id")]
+	if authorization == nil {
+		return newACMEProblem(http.StatusNotFound, "malformed", "unknown authorization")
+	}
+	if authorization.accountID != verified.account.ID {
+		return newACMEProblem(http.StatusUnauthorized, "unauthorized", "the authorization belongs to another account")
+	}
+	if payload.Status == "deactivated" {
+		authorization.status = "deactivated"
+		//An order can't be finalized once one of its authorizations is deactivated, even when it was ready. Orders
+		//whose certificate has been issued stay valid.
+		for _, order := range server.orders {
+			if order.status != "valid" && order.status != "invalid" && slices.Contains(order.authorizations, authorization) {
+				order.status = "invalid"
+				order.problem = newACMEProblem(http.StatusForbidden, "unauthorized", "the authorization for %s was deactivated", authorization.identifier.Value)
+			}
+		}
+	}
+
+	challenges := []map[string]any{}
+	for _, challenge := range authorization.challenges {
+		challenges = append(challenges, server.challengeObject(challenge))
+	}
+	object := map[string]any{
+		"identifier": authorization.identifier,
+		"status":     authorization.status,
+		"expires":    authorization.expires.Format(time.RFC3339),
+		"challenges": challenges,
+	}
+	if authorization.wildcard {
+		object["wildcard"] = true
+	}
+	writeJSON(writer, http.StatusOK, object)
+	return nil
+}
+
+//Starts validating a challenge. The client polls the challenge or its authorization for the result.
+func (server *acmeServer) respondToChallenge(writer http.ResponseWriter, request *http.Request) *acmeProblem {
+	verified, problem := server.verify(request, false)
+	if problem != nil {
+		return problem
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	challenge := server.challenges[request.PathValue("id")]
+	if challenge == nil {
+		return newACMEProblem(http.StatusNotFound, "malformed", "unknown challenge")
+	}
+	if challenge.authorization.accountID != verified.account.ID {
+		return newACMEProblem(http.StatusUnauthorized, "unauthorized", "the challenge belongs to another account")
+	}
+
+	//A POST-as-GET only reads the challenge, while a POST of {} asks for it to be validated
+	if string(verified.payload) != "" && challenge.status == "pending" && challenge.authorization.status == "pending" {
+		challenge.status = "processing"
+		go server.validateChallenge(challenge, challenge.token+"."+verified.thumbprint)
+	}
+
+	writer.Header().Add("Link", "<"+server.baseURL+"/authorization/"+challenge.authorization.id+">;rel=\"up\"")
+	writeJSON(writer, http.StatusOK, server.challengeObject(challenge))
+	return nil
+}
+
+//Checks that the client has published keyAuthorization, then records the result on the challenge,
+//its authorization and the orders waiting for it
+func (server *acmeServer) validateChallenge(challenge *acmeChallenge, keyAuthorization string) {
+	identifier := challenge.authorization.identifier
+	var err error
+	switch challenge.challengeType {
+	case "http-01":
+		err = server.validateHTTPChallenge(identifier.Value, challenge.token, keyAuthorization)
+	case "dns-01":
+		err = server.validateDNSChallenge(identifier.Value, keyAuthorization)
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if err != nil {
+		fmt.Fprintln(server.ca.log, "ACME:", challenge.challengeType, "validation of", identifier.Value, "failed:", err)
+		challenge.status = "invalid"
+		challenge.problem = newACMEProblem(http.StatusForbidden, "incorrectResponse", "%v", err)
+		challenge.authorization.status = "invalid"
+	} else {
+		fmt.Fprintln(server.ca.log, "ACME:", challenge.challengeType, "validation of", identifier.Value, "succeeded")
+		challenge.status = "valid"
+		challenge.validated = time.Now().UTC().Truncate(time.Second)
+		challenge.authorization.status = "valid"
+	}
+
+	for _, order := range server.orders {
+		if slices.Contains(order.authorizations, challenge.authorization) {
+			server.updateOrderStatus(order)
+		}
+	}
+}
+
+//Fetches http://<name>/.well-known/acme-challenge/<token> from the http port and compares it with keyAuthorization
+func (server *acmeServer) validateHTTPChallenge(name, token, keyAuthorization string) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	challengeURL := "http://" + net.JoinHostPort(name, server.httpPort) + "/.well-known/acme-challenge/" + token
+	response, err := client.Get(challengeURL)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", challengeURL, response.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<16))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(body)) != keyAuthorization {
+		return fmt.Errorf("%s does not contain the key authorization", challengeURL)
+	}
+	return nil
+}
+
+//Looks for the digest of keyAuthorization in the TXT records of _acme-challenge.<name>
+func (server *acmeServer) validateDNSChallenge(name, keyAuthorization string) error {
+	digest := sha256.Sum256([]byte(keyAuthorization))
+	expected := base64.RawURLEncoding.EncodeToString(digest[:])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	records, err := server.resolver.LookupTXT(ctx, "_acme-challenge."+name)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(records, expected) {
+		return fmt.Errorf("no TXT record of _acme-challenge.%s contains the key authorization digest", name)
+	}
+	return nil
+}
+
+//Issues the certificate of a ready order from the CSR in the request
+func (server *acmeServer) finalizeOrder(writer http.ResponseWriter, request *http.Request) *acmeProblem {
+	verified, problem := server.verify(request, false)
+	if problem != nil {
+		return problem
+	}
+
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	err := json.Unmarshal(verified.payload, &payload)
+	if err != nil {
+		return newACMEProblem(http.StatusBadRequest, "malformed", "invalid finalize request: %v", err)
+	}
+
+	der, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+	if err != nil {
+		return newACMEProblem(http.StatusBadRequest, "badCSR", "invalid CSR encoding")
+	}
+
+	certificateRequest, err := x509.ParseCertificateRequest(der)
+	if err == nil {
+		err = certificateRequest.CheckSignature()
+	}
+	if err != nil {
+		return newACMEProblem(http.StatusBadRequest, "badCSR", "invalid CSR: %v", err)
+	}
+
+	//Issuance writes to the intermediate authority's database and serial number file, so it happens under the mutex
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	order, problem := server.accountOrder(request, verified)
+	if problem != nil {
+		return problem
+	}
+	if order.status != "ready" {
+		return newACMEProblem(http.StatusForbidden, "orderNotReady", "the order is %s, not ready", order.status)
+	}
+
+	names, problem := csrNamesMatchingOrder(certificateRequest, order)
+	if problem != nil {
+		return problem
+	}
+
+	order.status = "processing"
+	certificate, err := server.issueCertificate(order, certificateRequest, names)
+	if err != nil {
+		order.status = "invalid"
+		order.problem = newACMEProblem(http.StatusInternalServerError, "serverInternal", "issuance failed: %v", err)
+		return order.problem
+	}
+
+	fmt.Fprintln(server.ca.log, "ACME: issued", certificate, "for", strings.Join(names, ", "))
+	order.status = "valid"
+	order.certificate = certificate
+	writer.Header().Set("Location", server.baseURL+"/order/"+order.id)
+	writeJSON(writer, http.StatusOK, server.orderObject(order))
+	return nil
+}
+
+//Returns the names requested by a CSR, which must be exactly the identifiers of the order
+func csrNamesMatchingOrder(certificateRequest *x509.CertificateRequest, order *acmeOrder) ([]string, *acmeProblem) {
+	requested := []string{}
+	requested = append(requested, certificateRequest.DNSNames...)
+	for _, address := range certificateRequest.IPAddresses {
+		requested = append(requested, address.String())
+	}
+	if certificateRequest.Subject.CommonName != "" && !slices.Contains(requested, certificateRequest.Subject.CommonName) {
+		requested = append(requested, certificateRequest.Subject.CommonName)
+	}
+
+	ordered := []string{}
+	for _, identifier := range order.identifiers {
+		if identifier.Type == "ip" {
+			ordered = append(ordered, net.ParseIP(identifier.Value).String())
+		} else {
+			ordered = append(ordered, strings.ToLower(identifier.Value))
+		}
+	}
+
+	for index := range requested {
+		requested[index] = strings.ToLower(requested[index])
+	}
+	sort.Strings(requested)
+	sort.Strings(ordered)
+	requested = slices.Compact(requested)
+	ordered = slices.Compact(ordered)
+	if !slices.Equal(requested, ordered) {
+		return nil, newACMEProblem(http.StatusBadRequest, "badCSR", "the CSR names %s do not match the order's identifiers %s", strings.Join(requested, ", "), strings.Join(ordered, ", "))
+	}
+	return ordered, nil
+}
+
+//Signs the CSR of an order with the intermediate authority in output/acme/certificates/<order>, using the server
+//certificate configuration so that the certificate is recorded in the intermediate authority's database.
+//Returns the certificate's filename. The caller holds the mutex.
+func (server *acmeServer) issueCertificate(order *acmeOrder, certificateRequest *x509.CertificateRequest, names []string) (string, error) {
+	directory := server.ca.fragments["acmeCertificatesDirectory"] + "/" + order.id
+	err := os.MkdirAll(directory, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	requestFile := directory + "/certificate.csr"
+	certificate := directory + "/certificate.crt"
+	configuration := directory + "/" + server.ca.fragments["acmeCertificateConfigFilename"]
+	err = writePEM(requestFile, "CERTIFICATE REQUEST", certificateRequest.Raw, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	//The intermediate authority's database and serial number file are shared with the rest of the CA
+	server.ca.mutex.Lock()
+	defer server.ca.mutex.Unlock()
+	err = server.ca.hydrateTemplate(server.ca.fragments["acmeCertificateConfigTemplate"], configuration, server.ca.fragments["intermediateAuthorityDatabase"], server.ca.fragments["intermediateAuthoritySerialNumber"], server.validityDays, serverKeyUsage(describePublicKey(certificateRequest.PublicKey)), server.ca.serverExtensionLines(intermediateAuthority("")), formatSubjectAlternativeNames(names))
+	if err != nil {
+		return "", err
+	}
+
+	err = server.ca.backend.generateSignedCertificate(requestFile, certificate, configuration, server.ca.fragments["intermediateAuthorityPrivateKey"], server.ca.fragments["intermediateAuthorityCertificate"], directory)
+	if err != nil {
+		return "", err
+	}
+
+	issued, err := readCertificate(certificate)
+	if err != nil {
+		return "", err
+	}
+	server.certificateAccounts[serialNumberHex(issued.SerialNumber)] = order.accountID
+	err = server.saveCertificateAccounts()
+	if err != nil {
+		return "", err
+	}
+	return certificate, nil
+}
+
+//Saves the account that ordered each certificate to output/acme/certificate_accounts.json. The caller holds the mutex.
+func (server *acmeServer) saveCertificateAccounts() error {
+	data, err := json.MarshalIndent(server.certificateAccounts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(server.ca.fragments["acmeCertificateAccounts"], data, 0600)
+}
+
+//Returns the certificate of a valid order followed by the intermediate authority's certificate
+func (server *acmeServer) downloadCertificate(writer http.ResponseWriter, request *http.Request) *acmeProblem {
+	verified, problem := server.verify(request, false)
+	if problem != nil {
+		return problem
+	}
+
+	server.mutex.Lock()
+	order, problem := server.accountOrder(request, verified)
+	server.mutex.Unlock()
+	if problem != nil {
+		return problem
+	}
+	if order.certificate == "" {
+		return newACMEProblem(http.StatusNotFound, "malformed", "the order has no certificate")
+	}
+
+	chain := []byte{}
+	for _, filename := range []string{order.certificate, server.ca.fragments["intermediateAuthorityCertificate"]} {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return newACMEProblem(http.StatusInternalServerError, "serverInternal", "error reading %s: %v", filename, err)
+		}
+		chain = append(chain, data...)
+	}
+
+	writer.Header().Set("Content-Type", "application/pem-certificate-chain")
+	writer.Write(chain)
+	return nil
+}
+
+//Revokes a certificate issued by the intermediate authority. The request must be signed by the account
+//that ordered the certificate or by the certificate's own key.
+func (server *acmeServer) revokeCertificate(writer http.ResponseWriter, request *http.Request) *acmeProblem {
+	verified, problem := server.verify(request, true)
+	if problem != nil {
+		return problem
+	}
+
+	var payload struct {
+		Certificate string `json:"certificate"`
+		Reason      int    `json:"reason"`
+	}
+	err := json.Unmarshal(verified.payload, &payload)
+	if err != nil {
+		return newACMEProblem(http.StatusBadRequest, "malformed", "invalid revocation request: %v", err)
+	}
+
+	der, err := base64.RawURLEncoding.DecodeString(payload.Certificate)
+	if err != nil {
+		return newACMEProblem(http.StatusBadRequest, "malformed", "invalid certificate encoding")
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return newACMEProblem(http.StatusBadRequest, "malformed", "invalid certificate: %v", err)
+	}
+
+	reason := ""
+	for name, code := range revocationReasons {
+		if code == payload.Reason {
+			reason = name
+		}
+	}
+	if reason == "" {
+		return newACMEProblem(http.StatusBadRequest, "badRevocationReason", "unsupported revocation reason %d", payload.Reason)
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	serialNumber := serialNumberHex(certificate.SerialNumber)
+	authorized := false
+	if verified.account != nil {
+		authorized = server.certificateAccounts[serialNumber] == verified.account.ID
+	} else {
+		publicKey, ok := certificate.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+		authorized = ok && publicKey.Equal(verified.publicKey)
+	}
+	if !authorized {
+		return newACMEProblem(http.StatusForbidden, "unauthorized", "the request is not signed by the certificate's account or key")
+	}
+
+	intermediate, err := readCertificate(server.ca.fragments["intermediateAuthorityCertificate"])
+	if err != nil {
+		return newACMEProblem(http.StatusInternalServerError, "serverInternal", "%v", err)
+	}
+	if certificate.CheckSignatureFrom(intermediate) != nil {
+		return newACMEProblem(http.StatusNotFound, "malformed", "the certificate was not issued by this authority")
+	}
+
+	server.ca.mutex.Lock()
+	defer server.ca.mutex.Unlock()
+	entries, err := readDatabase(server.ca.fragments["intermediateAuthorityDatabase"])
+	if err != nil {
+		return newACMEProblem(http.StatusInternalServerError, "serverInternal", "%v", err)
+	}
+
+	entry, found := findDatabaseEntry(entries, serialNumber)
+	if !found {
+		return newACMEProblem(http.StatusNotFound, "malformed", "the certificate was not issued by this authority")
+	}
+	if entry.status == "R" {
+		return newACMEProblem(http.StatusBadRequest, "alreadyRevoked", "the certificate is already revoked")
+	}
+
+	//The backends revoke a certificate file, so the certificate in the request is saved next to those issued by the server
+	issuedCertificate := server.ca.fragments["acmeCertificatesDirectory"] + "/" + serialNumber + ".pem"
+	err = writePEM(issuedCertificate, "CERTIFICATE", certificate.Raw, 0644)
+	if err != nil {
+		return newACMEProblem(http.StatusInternalServerError, "serverInternal", "%v", err)
+	}
+
+	err = server.ca.revokeIssuedCertificate(intermediateAuthority(""), issuedCertificate, reason)
+	if err != nil {
+		return newACMEProblem(http.StatusInternalServerError, "serverInternal", "revocation failed: %v", err)
+	}
+
+	fmt.Fprintln(server.ca.log, "ACME: revoked serial", serialNumber, reason)
+	writer.WriteHeader(http.StatusOK)
+	return nil
+}
+
+//Returns the ACME (RFC 8555) server backed by the intermediate authority, routed under the path of the acme url,
+//with its directory at <acme url>/directory. Accounts are kept in output/acme/accounts.json, issued certificates
+//in output/acme/certificates and the account that ordered each of them in output/acme/certificate_accounts.json.
+//Serving it over https with a certificate for its host name is up to the caller.
+func (ca *CA) ACMEHandler() (http.Handler, error) {
+	err := ca.CheckAuthorities()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, directory := range []string{ca.fragments["acmeDirectory"], ca.fragments["acmeCertificatesDirectory"]} {
+		if !fileExists(directory) {
+			fmt.Fprintln(ca.log, "Generating directory: "+directory)
+			err = os.MkdirAll(directory, 0700)
+			if err != nil {
+				return nil, classify(err, IOError)
+			}
+		}
+	}
+
+	server, err := newACMEServer(ca)
+	if err != nil {
+		return nil, classify(err, IOError)
+	}
+
+	mux := http.NewServeMux()
+	server.register(mux)
+	return mux, nil
+}
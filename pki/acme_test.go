@@ -0,0 +1,338 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//Returns an ACME server whose files are kept in a temporary directory
+func newACMETestServer(t *testing.T, directory string) *acmeServer {
+	t.Helper()
+	ca := &CA{
+		options:   Options{ACME: ACMEOptions{URL: "https://localhost:8443/acme"}},
+		fragments: map[string]string{"acmeAccounts": filepath.Join(directory, "accounts.json"), "acmeCertificateAccounts": filepath.Join(directory, "certificate_accounts.json")},
+		log:       io.Discard,
+	}
+	server, err := newACMEServer(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestACMEServerPrune(t *testing.T) {
+	server := newACMETestServer(t, t.TempDir())
+	now := time.Now()
+
+	newOrder := func(id string, expires time.Time) {
+		challenge := &acmeChallenge{id: id + "-challenge"}
+		authorization := &acmeAuthorization{id: id + "-authorization", expires: expires, challenges: []*acmeChallenge{challenge}}
+		challenge.authorization = authorization
+		server.orders[id] = &acmeOrder{id: id, expires: expires, authorizations: []*acmeAuthorization{authorization}}
+		server.authorizations[authorization.id] = authorization
+		server.challenges[challenge.id] = challenge
+	}
+	newOrder("expired", now.Add(-time.Second))
+	newOrder("pending", now.Add(time.Hour))
+	server.nonces["expired"] = now.Add(-time.Second)
+	server.nonces["fresh"] = now.Add(time.Hour)
+
+	server.prune(now)
+	for name, found := range map[string]bool{
+		"the expired nonce":         !server.nonces["expired"].IsZero(),
+		"the expired order":         server.orders["expired"] != nil,
+		"the expired authorization": server.authorizations["expired-authorization"] != nil,
+		"the expired challenge":     server.challenges["expired-challenge"] != nil,
+	} {
+		if found {
+			t.Errorf("prune kept %s", name)
+		}
+	}
+	if server.nonces["fresh"].IsZero() || server.orders["pending"] == nil || server.authorizations["pending-authorization"] == nil || server.challenges["pending-challenge"] == nil {
+		t.Errorf("prune forgot a nonce or an order that hasn't expired")
+	}
+	if !server.pruned.Equal(now) {
+		t.Errorf("prune didn't record when it ran")
+	}
+}
+
+func TestACMEServerNonce(t *testing.T) {
+	server := newACMETestServer(t, t.TempDir())
+	nonce := server.nonce()
+	expires := server.nonces[nonce]
+	if time.Until(expires) <= 0 || time.Until(expires) > acmeNonceLifetime {
+		t.Errorf("the nonce expires at %s, expected within %s", expires, acmeNonceLifetime)
+	}
+}
+
+func TestACMEServerCertificateAccounts(t *testing.T) {
+	directory := t.TempDir()
+	server := newACMETestServer(t, directory)
+	server.certificateAccounts["0ABC"] = "account"
+	err := server.saveCertificateAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newACMETestServer(t, directory)
+	if restarted.certificateAccounts["0ABC"] != "account" {
+		t.Errorf("the restarted server has the certificate accounts %v, expected 0ABC to belong to account", restarted.certificateAccounts)
+	}
+}
+
+//Returns a new P-256 account key and its JSON Web Key
+func newACMETestKey(t *testing.T) (*ecdsa.PrivateKey, json.RawMessage) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := key.PublicKey.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := json.Marshal(jsonWebKey{KeyType: "EC", Curve: "P-256", X: base64.RawURLEncoding.EncodeToString(publicKey[1:33]), Y: base64.RawURLEncoding.EncodeToString(publicKey[33:])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, jwk
+}
+
+//Registers a valid account with a new key on server
+func newACMETestAccount(t *testing.T, server *acmeServer) (*ecdsa.PrivateKey, *acmeAccount) {
+	t.Helper()
+	key, jwk := newACMETestKey(t)
+	account := &acmeAccount{ID: randomToken(8), Key: jwk, Status: "valid"}
+	var err error
+	account.publicKey, account.thumbprint, err = parseJSONWebKey(jwk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.accounts[account.ID] = account
+	return key, account
+}
+
+//Returns a request to path whose body is the flattened JWS of payload and header, signed with key using ES256
+func newACMETestRequest(t *testing.T, key *ecdsa.PrivateKey, header acmeProtectedHeader, path, payload string) *http.Request {
+	t.Helper()
+	//Clients leave out the kid or jwk they don't use
+	protected, err := json.Marshal(struct {
+		acmeProtectedHeader
+		KeyID string          `json:"kid,omitempty"`
+		JWK   json.RawMessage `json:"jwk,omitempty"`
+	}{header, header.KeyID, header.JWK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jws := acmeJWS{Protected: base64.RawURLEncoding.EncodeToString(protected), Payload: base64.RawURLEncoding.EncodeToString([]byte(payload))}
+
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	jws.Signature = base64.RawURLEncoding.EncodeToString(append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...))
+
+	body, err := json.Marshal(jws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewRequest(http.MethodPost, path, strings.NewReader(string(body)))
+}
+
+//Returns a request from account to the endpoint at path under the server's url, with a fresh nonce
+func newACMETestAccountRequest(t *testing.T, server *acmeServer, key *ecdsa.PrivateKey, account *acmeAccount, path, payload string) *http.Request {
+	t.Helper()
+	header := acmeProtectedHeader{Algorithm: "ES256", Nonce: server.nonce(), URL: server.baseURL + path, KeyID: server.baseURL + "/account/" + account.ID}
+	return newACMETestRequest(t, key, header, "/acme"+path, payload)
+}
+
+func TestACMEServerVerify(t *testing.T) {
+	server := newACMETestServer(t, t.TempDir())
+	key, account := newACMETestAccount(t, server)
+	_, deactivated := newACMETestAccount(t, server)
+	deactivated.Status = "deactivated"
+	otherKey, jwk := newACMETestKey(t)
+
+	header := acmeProtectedHeader{Algorithm: "ES256", Nonce: server.nonce(), URL: server.baseURL + "/new-order", KeyID: server.baseURL + "/account/" + account.ID}
+	verified, problem := server.verify(newACMETestRequest(t, key, header, "/acme/new-order", `{"identifiers":[]}`), false)
+	if problem != nil {
+		t.Fatalf("verify of a request signed by an account returned the problem %v", problem)
+	}
+	if verified.account != account || verified.thumbprint != account.thumbprint || string(verified.payload) != `{"identifiers":[]}` {
+		t.Errorf("verify returned the account %v and the payload %s", verified.account, verified.payload)
+	}
+
+	reused := newACMETestRequest(t, key, header, "/acme/new-order", `{"identifiers":[]}`)
+
+	header = acmeProtectedHeader{Algorithm: "ES256", Nonce: server.nonce(), URL: server.baseURL + "/new-account", JWK: jwk}
+	verified, problem = server.verify(newACMETestRequest(t, otherKey, header, "/acme/new-account", "{}"), true)
+	if problem != nil {
+		t.Fatalf("verify of a request signed by a jwk returned the problem %v", problem)
+	}
+	if verified.account != nil || string(verified.jwk) != string(jwk) || verified.thumbprint == "" {
+		t.Errorf("verify of a request signed by a jwk returned the account %v, the jwk %s and the thumbprint %q", verified.account, verified.jwk, verified.thumbprint)
+	}
+
+	expiredNonce := server.nonce()
+	server.nonces[expiredNonce] = time.Now().Add(-time.Second)
+	kid := server.baseURL + "/account/" + account.ID
+	tests := []struct {
+		name     string
+		request  *http.Request
+		allowJWK bool
+		problem  string
+	}{
+		{"a reused nonce", reused, false, "badNonce"},
+		{"an unknown nonce", newACMETestRequest(t, key, acmeProtectedHeader{Algorithm: "ES256", Nonce: "unknown", URL: server.baseURL + "/new-order", KeyID: kid}, "/acme/new-order", "{}"), false, "badNonce"},
+		{"an expired nonce", newACMETestRequest(t, key, acmeProtectedHeader{Algorithm: "ES256", Nonce: expiredNonce, URL: server.baseURL + "/new-order", KeyID: kid}, "/acme/new-order", "{}"), false, "badNonce"},
+		{"another url", newACMETestRequest(t, key, acmeProtectedHeader{Algorithm: "ES256", Nonce: server.nonce(), URL: server.baseURL + "/new-account", KeyID: kid}, "/acme/new-order", "{}"), false, "unauthorized"},
+		{"an unsupported algorithm", newACMETestRequest(t, key, acmeProtectedHeader{Algorithm: "HS256", Nonce: server.nonce(), URL: server.baseURL + "/new-order", KeyID: kid}, "/acme/new-order", "{}"), false, "badSignatureAlgorithm"},
+		{"another algorithm than the key's", newACMETestRequest(t, key, acmeProtectedHeader{Algorithm: "ES384", Nonce: server.nonce(), URL: server.baseURL + "/new-order", KeyID: kid}, "/acme/new-order", "{}"), false, "malformed"},
+		{"an unknown account", newACMETestRequest(t, key, acmeProtectedHeader{Algorithm: "ES256", Nonce: server.nonce(), URL: server.baseURL + "/new-order", KeyID: server.baseURL + "/account/unknown"}, "/acme/new-order", "{}"), false, "accountDoesNotExist"},
+		{"a deactivated account", newACMETestRequest(t, key, acmeProtectedHeader{Algorithm: "ES256", Nonce: server.nonce(), URL: server.baseURL + "/new-order", KeyID: server.baseURL + "/account/" + deactivated.ID}, "/acme/new-order", "{}"), false, "unauthorized"},
+		{"the key of another account", newACMETestRequest(t, otherKey, acmeProtectedHeader{Algorithm: "ES256", Nonce: server.nonce(), URL: server.baseURL + "/new-order", KeyID: kid}, "/acme/new-order", "{}"), false, "malformed"},
+		{"a jwk where a kid is expected", newACMETestRequest(t, otherKey, acmeProtectedHeader{Algorithm: "ES256", Nonce: server.nonce(), URL: server.baseURL + "/new-order", JWK: jwk}, "/acme/new-order", "{}"), false, "malformed"},
+		{"both a jwk and a kid", newACMETestRequest(t, key, acmeProtectedHeader{Algorithm: "ES256", Nonce: server.nonce(), URL: server.baseURL + "/new-account", KeyID: kid, JWK: jwk}, "/acme/new-account", "{}"), true, "malformed"},
+		{"a body that isn't a JWS", httptest.NewRequest(http.MethodPost, "/acme/new-order", strings.NewReader("{")), false, "malformed"},
+	}
+	for _, test := range tests {
+		_, problem := server.verify(test.request, test.allowJWK)
+		if problem == nil || problem.Type != acmeErrorNamespace+test.problem {
+			t.Errorf("verify of a request with %s returned the problem %v, expected %s", test.name, problem, test.problem)
+		}
+	}
+}
+
+func TestCSRNamesMatchingOrder(t *testing.T) {
+	order := &acmeOrder{identifiers: []acmeIdentifier{{"dns", "Example.test"}, {"dns", "*.example.test"}, {"ip", "10.0.0.1"}, {"ip", "2001:db8:0:0::1"}}}
+	expected := []string{"*.example.test", "10.0.0.1", "2001:db8::1", "example.test"}
+	addresses := []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::1")}
+
+	tests := []struct {
+		name    string
+		request x509.CertificateRequest
+		matches bool
+	}{
+		{"the same names", x509.CertificateRequest{DNSNames: []string{"example.test", "*.example.test"}, IPAddresses: addresses}, true},
+		{"a common name among the names", x509.CertificateRequest{Subject: pkix.Name{CommonName: "example.test"}, DNSNames: []string{"EXAMPLE.TEST", "*.example.test", "example.test"}, IPAddresses: addresses}, true},
+		{"the common name as the only copy of a name", x509.CertificateRequest{Subject: pkix.Name{CommonName: "example.test"}, DNSNames: []string{"*.example.test"}, IPAddresses: addresses}, true},
+		{"a common name that wasn't ordered", x509.CertificateRequest{Subject: pkix.Name{CommonName: "other.test"}, DNSNames: []string{"example.test", "*.example.test"}, IPAddresses: addresses}, false},
+		{"a missing name", x509.CertificateRequest{DNSNames: []string{"example.test"}, IPAddresses: addresses}, false},
+		{"a missing address", x509.CertificateRequest{DNSNames: []string{"example.test", "*.example.test"}, IPAddresses: addresses[:1]}, false},
+		{"an extra name", x509.CertificateRequest{DNSNames: []string{"example.test", "*.example.test", "www.example.test"}, IPAddresses: addresses}, false},
+		{"a name as an address", x509.CertificateRequest{DNSNames: []string{"example.test", "*.example.test", "10.0.0.1"}, IPAddresses: addresses[1:]}, true},
+	}
+	for _, test := range tests {
+		names, problem := csrNamesMatchingOrder(&test.request, order)
+		if test.matches && (problem != nil || strings.Join(names, " ") != strings.Join(expected, " ")) {
+			t.Errorf("csrNamesMatchingOrder of a CSR with %s returned %q and the problem %v, expected %q", test.name, names, problem, expected)
+		}
+		if !test.matches && (problem == nil || problem.Type != acmeErrorNamespace+"badCSR") {
+			t.Errorf("csrNamesMatchingOrder of a CSR with %s returned %q and the problem %v, expected badCSR", test.name, names, problem)
+		}
+	}
+}
+
+func TestACMEServerIsLocalName(t *testing.T) {
+	server := newACMETestServer(t, t.TempDir())
+	server.localDomains = []string{"localhost", "test", ".Home.Arpa."}
+
+	tests := []struct {
+		identifier acmeIdentifier
+		local      bool
+	}{
+		{acmeIdentifier{"dns", "localhost"}, true},
+		{acmeIdentifier{"dns", "test"}, true},
+		{acmeIdentifier{"dns", "www.example.test"}, true},
+		{acmeIdentifier{"dns", "WWW.Example.TEST."}, true},
+		{acmeIdentifier{"dns", "router.home.arpa"}, true},
+		{acmeIdentifier{"dns", "attest"}, false},
+		{acmeIdentifier{"dns", "test.example.com"}, false},
+		{acmeIdentifier{"dns", "localhost.example.com"}, false},
+		{acmeIdentifier{"ip", "127.0.0.1"}, true},
+		{acmeIdentifier{"ip", "10.1.2.3"}, true},
+		{acmeIdentifier{"ip", "192.168.0.1"}, true},
+		{acmeIdentifier{"ip", "169.254.0.1"}, true},
+		{acmeIdentifier{"ip", "::1"}, true},
+		{acmeIdentifier{"ip", "fd00::1"}, true},
+		{acmeIdentifier{"ip", "fe80::1"}, true},
+		{acmeIdentifier{"ip", "8.8.8.8"}, false},
+		{acmeIdentifier{"ip", "2001:db8::1"}, false},
+		{acmeIdentifier{"ip", "not an address"}, false},
+	}
+	for _, test := range tests {
+		if local := server.isLocalName(test.identifier); local != test.local {
+			t.Errorf("isLocalName(%v) returned %v, expected %v", test.identifier, local, test.local)
+		}
+	}
+}
+
+func TestACMEServerDeactivateAuthorization(t *testing.T) {
+	server := newACMETestServer(t, t.TempDir())
+	key, account := newACMETestAccount(t, server)
+	otherKey, otherAccount := newACMETestAccount(t, server)
+
+	expires := time.Now().Add(time.Hour)
+	authorization := &acmeAuthorization{id: "authorization", accountID: account.ID, identifier: acmeIdentifier{"dns", "example.test"}, status: "valid", expires: expires}
+	other := &acmeAuthorization{id: "other", accountID: account.ID, identifier: acmeIdentifier{"dns", "other.test"}, status: "valid", expires: expires}
+	server.authorizations[authorization.id] = authorization
+	server.authorizations[other.id] = other
+	for id, status := range map[string]string{"ready": "ready", "pending": "pending", "issued": "valid"} {
+		server.orders[id] = &acmeOrder{id: id, accountID: account.ID, status: status, expires: expires, identifiers: []acmeIdentifier{authorization.identifier}, authorizations: []*acmeAuthorization{authorization}}
+	}
+	server.orders["unrelated"] = &acmeOrder{id: "unrelated", accountID: account.ID, status: "ready", expires: expires, identifiers: []acmeIdentifier{other.identifier}, authorizations: []*acmeAuthorization{other}}
+
+	deactivate := func(key *ecdsa.PrivateKey, account *acmeAccount) *acmeProblem {
+		request := newACMETestAccountRequest(t, server, key, account, "/authorization/"+authorization.id, `{"status":"deactivated"}`)
+		request.SetPathValue("id", authorization.id)
+		return server.getAuthorization(httptest.NewRecorder(), request)
+	}
+	problem := deactivate(otherKey, otherAccount)
+	if problem == nil || problem.Type != acmeErrorNamespace+"unauthorized" || authorization.status != "valid" {
+		t.Fatalf("deactivating the authorization of another account returned the problem %v", problem)
+	}
+	problem = deactivate(key, account)
+	if problem != nil {
+		t.Fatal(problem)
+	}
+
+	if authorization.status != "deactivated" {
+		t.Errorf("the authorization is %s, expected deactivated", authorization.status)
+	}
+	for id, expected := range map[string]string{"ready": "invalid", "pending": "invalid", "issued": "valid", "unrelated": "ready"} {
+		if status := server.orders[id].status; status != expected {
+			t.Errorf("the %s order is %s, expected %s", id, status, expected)
+		}
+	}
+
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{"example.test"}}, crypto.Signer(signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := newACMETestAccountRequest(t, server, key, account, "/finalize/ready", `{"csr":"`+base64.RawURLEncoding.EncodeToString(der)+`"}`)
+	request.SetPathValue("id", "ready")
+	problem = server.finalizeOrder(httptest.NewRecorder(), request)
+	if problem == nil || problem.Type != acmeErrorNamespace+"orderNotReady" {
+		t.Errorf("finalizing an order whose authorization was deactivated returned the problem %v, expected orderNotReady", problem)
+	}
+}
@@ -0,0 +1,1317 @@
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Takes in a string and runs the command in a shell. environment holds extra NAME=value variables, such as passphrases,
+//which are kept out of the command line and the log.
+func (backend opensslBackend) runCommand(command string, environment ...string) error {
+	_, err := backend.runCommandWithInput(command, nil, environment...)
+	return err
+}
+
+//Runs the command like runCommand with input as its standard input, and returns its standard output
+func (backend opensslBackend) runCommandWithInput(command string, input []byte, environment ...string) ([]byte, error) {
+	executableCommand := convertStringIntoExecCommand(command)
+	fmt.Fprintln(backend.log, "runCommand:")
+	fmt.Fprintln(backend.log, command)
+	if len(environment) > 0 {
+		executableCommand.Env = append(os.Environ(), environment...)
+	}
+	var output, errorOutput bytes.Buffer
+	executableCommand.Stdin = bytes.NewReader(input)
+	executableCommand.Stdout = &output
+	executableCommand.Stderr = &errorOutput
+	err := executableCommand.Run()
+	if err != nil {
+		name := strings.Join(executableCommand.Args[:2], " ")
+		if message := strings.TrimSpace(errorOutput.String() + output.String()); message != "" {
+			return nil, fmt.Errorf("%s: %w: %s", name, err, message)
+		}
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return output.Bytes(), nil
+}
+
+//Takes in a string and produces Cmd object that can be run
+func convertStringIntoExecCommand(command string) *exec.Cmd {
+	arguments := strings.Split(command, " ")
+	return exec.Command(arguments[0], arguments[1:]...)
+}
+
+//A certificateBackend performs the cryptographic steps of building the root, intermediate and server hierarchy.
+//All file arguments are filepaths, and configuration files are the hydrated openssl templates, so that both
+//backends read the same subjects, lifetimes, extensions, databases and serial number files.
+type certificateBackend interface {
+	generatePrivateKey(filename, algorithm, encryption string) error
+	generateCertificateSigningRequest(privateKey, outputCertificateSigningRequest, configuration string) error
+	generateSelfSignedCertificate(privateKey, configuration, outputCertificate, certificateSigningRequest, outputDirectory string) error
+	generateSignedCertificate(certificateSigningRequest, outputCertificate, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputDirectory string) error
+	revokeCertificate(certificate, reason, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate string) error
+	generateCertificateRevocationList(certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputCertificateRevocationList string) error
+	exportPKCS12(outputPKCS12, privateKey string, certificates []string, friendlyName, password, encryption string) error
+}
+
+//Returns the backend with the given name. The openssl backend prints the commands it runs to log.
+//Encrypted private keys are unlocked, and new ones encrypted, with the passphrases returned by passphrase.
+func backendFromName(name string, log io.Writer, passphrase PassphraseFunc) (certificateBackend, error) {
+	switch name {
+	case "native":
+		return nativeBackend{passphrase}, nil
+	case "openssl":
+		return opensslBackend{log, passphrase}, nil
+	}
+	return nil, fmt.Errorf("unknown backend %q, expected native or openssl", name)
+}
+
+//Wraps a backend so that its errors are Errors: SigningErrors, unless they come from the file system or a missing openssl command
+type classifiedBackend struct {
+	backend certificateBackend
+}
+
+func (classified classifiedBackend) generatePrivateKey(filename, algorithm, encryption string) error {
+	return classify(classified.backend.generatePrivateKey(filename, algorithm, encryption), SigningError)
+}
+
+func (classified classifiedBackend) generateCertificateSigningRequest(privateKey, outputCertificateSigningRequest, configuration string) error {
+	return classify(classified.backend.generateCertificateSigningRequest(privateKey, outputCertificateSigningRequest, configuration), SigningError)
+}
+
+func (classified classifiedBackend) generateSelfSignedCertificate(privateKey, configuration, outputCertificate, certificateSigningRequest, outputDirectory string) error {
+	return classify(classified.backend.generateSelfSignedCertificate(privateKey, configuration, outputCertificate, certificateSigningRequest, outputDirectory), SigningError)
+}
+
+func (classified classifiedBackend) generateSignedCertificate(certificateSigningRequest, outputCertificate, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputDirectory string) error {
+	return classify(classified.backend.generateSignedCertificate(certificateSigningRequest, outputCertificate, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputDirectory), SigningError)
+}
+
+func (classified classifiedBackend) revokeCertificate(certificate, reason, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate string) error {
+	return classify(classified.backend.revokeCertificate(certificate, reason, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate), SigningError)
+}
+
+func (classified classifiedBackend) generateCertificateRevocationList(certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputCertificateRevocationList string) error {
+	return classify(classified.backend.generateCertificateRevocationList(certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputCertificateRevocationList), SigningError)
+}
+
+func (classified classifiedBackend) exportPKCS12(outputPKCS12, privateKey string, certificates []string, friendlyName, password, encryption string) error {
+	return classify(classified.backend.exportPKCS12(outputPKCS12, privateKey, certificates, friendlyName, password, encryption), SigningError)
+}
+
+//Key algorithms that can be selected for the root, intermediate and server keys
+var KeyAlgorithms = []string{"rsa2048", "rsa3072", "rsa4096", "ecdsa-p256", "ecdsa-p384", "ed25519"}
+
+//Returns an error naming the supported algorithms if algorithm is not one of them
+func validateKeyAlgorithm(algorithm string) error {
+	for _, supported := range KeyAlgorithms {
+		if algorithm == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown key algorithm %q, expected one of %s", algorithm, strings.Join(KeyAlgorithms, ", "))
+}
+
+//Shells out to the openssl command for every step
+type opensslBackend struct {
+	log        io.Writer
+	passphrase PassphraseFunc
+}
+
+//openssl genpkey arguments for each of the KeyAlgorithms
+var opensslKeyAlgorithmOptions = map[string]string{
+	"rsa2048":    "-algorithm RSA -pkeyopt rsa_keygen_bits:2048",
+	"rsa3072":    "-algorithm RSA -pkeyopt rsa_keygen_bits:3072",
+	"rsa4096":    "-algorithm RSA -pkeyopt rsa_keygen_bits:4096",
+	"ecdsa-p256": "-algorithm EC -pkeyopt ec_paramgen_curve:P-256",
+	"ecdsa-p384": "-algorithm EC -pkeyopt ec_paramgen_curve:P-384",
+	"ed25519":    "-algorithm ED25519",
+}
+
+//openssl pkcs8 arguments for each of the KeyEncryptions but none
+var opensslKeyEncryptionOptions = map[string]string{
+	"pbkdf2": fmt.Sprintf("-v2 aes-256-cbc -v2prf hmacWithSHA256 -iter %d", keyEncryptionIterations),
+	"scrypt": fmt.Sprintf("-v2 aes-256-cbc -scrypt -scrypt_N %d -scrypt_r %d -scrypt_p %d", scryptCost, scryptBlockSize, scryptParallelization),
+}
+
+//The environment variable openssl reads passphrases from, so that they don't show up in the process list
+const opensslPassphraseVariable = "PKI_KEY_PASSPHRASE"
+
+//An encrypted key is generated into memory and piped into openssl pkcs8, so the unencrypted key never touches the disk
+func (backend opensslBackend) generatePrivateKey(filename, algorithm, encryption string) error {
+	options, ok := opensslKeyAlgorithmOptions[algorithm]
+	if !ok {
+		return validateKeyAlgorithm(algorithm)
+	}
+	if encryption == "none" {
+		return backend.runCommand(fmt.Sprintf("openssl genpkey -outform pem -out %s %s", filename, options))
+	}
+
+	encryptionOptions, ok := opensslKeyEncryptionOptions[encryption]
+	if !ok {
+		return validateKeyEncryption(encryption)
+	}
+	passphrase, err := backend.passphrase(filename, true)
+	if err != nil {
+		return err
+	}
+
+	key, err := backend.runCommandWithInput(fmt.Sprintf("openssl genpkey -outform pem %s", options), nil)
+	if err != nil {
+		return err
+	}
+	_, err = backend.runCommandWithInput(fmt.Sprintf("openssl pkcs8 -topk8 %s -passout env:%s -out %s", encryptionOptions, opensslPassphraseVariable, filename), key, opensslPassphraseVariable+"="+passphrase)
+	return err
+}
+
+//Returns the -passin option and the environment openssl needs to read privateKey, which are empty unless it is encrypted
+func (backend opensslBackend) unlock(privateKey string) (string, []string, error) {
+	if !isEncryptedPrivateKey(privateKey) {
+		return "", nil, nil
+	}
+
+	passphrase, err := backend.passphrase(privateKey, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return " -passin env:" + opensslPassphraseVariable, []string{opensslPassphraseVariable + "=" + passphrase}, nil
+}
+
+func (backend opensslBackend) generateCertificateSigningRequest(privateKey, outputCertificateSigningRequest, configuration string) error {
+	passin, environment, err := backend.unlock(privateKey)
+	if err != nil {
+		return err
+	}
+	return backend.runCommand(fmt.Sprintf("openssl req -key %s -out %s -days 398 -new -config %s%s", privateKey, outputCertificateSigningRequest, configuration, passin), environment...)
+}
+
+func (backend opensslBackend) generateSelfSignedCertificate(privateKey, configuration, outputCertificate, certificateSigningRequest, outputDirectory string) error {
+	passin, environment, err := backend.unlock(privateKey)
+	if err != nil {
+		return err
+	}
+	return backend.runCommand(fmt.Sprintf("openssl ca -selfsign -keyfile %s -config %s -out %s -in %s -outdir %s -verbose -batch%s",
+		privateKey, configuration, outputCertificate, certificateSigningRequest, outputDirectory, passin), environment...)
+}
+
+func (backend opensslBackend) generateSignedCertificate(certificateSigningRequest, outputCertificate, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputDirectory string) error {
+	passin, environment, err := backend.unlock(certificateAuthoritySigningKey)
+	if err != nil {
+		return err
+	}
+	return backend.runCommand(fmt.Sprintf("openssl ca -in %s -out %s -config %s -keyfile %s -cert %s -outdir %s -batch%s",
+		certificateSigningRequest, outputCertificate, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputDirectory, passin), environment...)
+}
+
+func (backend opensslBackend) revokeCertificate(certificate, reason, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate string) error {
+	passin, environment, err := backend.unlock(certificateAuthoritySigningKey)
+	if err != nil {
+		return err
+	}
+	command := fmt.Sprintf("openssl ca -revoke %s -config %s -keyfile %s -cert %s -batch%s",
+		certificate, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, passin)
+	if reason != "" {
+		command += " -crl_reason " + reason
+	}
+	return backend.runCommand(command, environment...)
+}
+
+func (backend opensslBackend) generateCertificateRevocationList(certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputCertificateRevocationList string) error {
+	passin, environment, err := backend.unlock(certificateAuthoritySigningKey)
+	if err != nil {
+		return err
+	}
+	return backend.runCommand(fmt.Sprintf("openssl ca -gencrl -config %s -keyfile %s -cert %s -out %s -batch%s",
+		certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputCertificateRevocationList, passin), environment...)
+}
+
+//Runs openssl pkcs12. The password is passed through the environment so that it doesn't show up in the process list.
+//Without a private key, the certificates are exported as a truststore.
+func (backend opensslBackend) exportPKCS12(outputPKCS12, privateKey string, certificates []string, friendlyName, password, encryption string) error {
+	//openssl pkcs12 reads every certificate from one file, and gives the friendly name to the one matching the key
+	input, err := ioutil.TempFile("", "pkcs12_certificates")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(input.Name())
+	for _, certificate := range certificates {
+		data, err := ioutil.ReadFile(certificate)
+		if err == nil {
+			_, err = input.Write(data)
+		}
+		if err != nil {
+			input.Close()
+			return err
+		}
+	}
+	err = input.Close()
+	if err != nil {
+		return err
+	}
+
+	arguments := []string{"pkcs12", "-export", "-in", input.Name(), "-out", outputPKCS12, "-name", friendlyName, "-passout", "env:PKCS12_EXPORT_PASSWORD"}
+	switch encryption {
+	case "legacy":
+		arguments = append(arguments, "-keypbe", "PBE-SHA1-3DES", "-certpbe", "PBE-SHA1-3DES", "-macalg", "sha1")
+	case "modern":
+		arguments = append(arguments, "-keypbe", "AES-256-CBC", "-certpbe", "AES-256-CBC", "-macalg", "sha256")
+	default:
+		return fmt.Errorf("unknown PKCS#12 encryption %q, expected one of %s", encryption, strings.Join(PKCS12Encryptions, ", "))
+	}
+
+	if privateKey != "" {
+		arguments = append(arguments, "-inkey", privateKey)
+	} else {
+		arguments = append(arguments, "-nokeys")
+		help, _ := exec.Command("openssl", "pkcs12", "-help").CombinedOutput()
+		if bytes.Contains(help, []byte("-jdktrust")) {
+			arguments = append(arguments, "-jdktrust", "anyExtendedKeyUsage")
+		} else {
+			fmt.Fprintln(backend.log, "Warning: openssl pkcs12 only marks certificates as trusted for Java from OpenSSL 3.2, so Java won't trust "+outputPKCS12+". Use the native backend for Java truststores.")
+		}
+	}
+
+	fmt.Fprintln(backend.log, "runCommand:")
+	fmt.Fprintln(backend.log, "openssl "+strings.Join(arguments, " "))
+	command := exec.Command("openssl", arguments...)
+	command.Env = append(os.Environ(), "PKCS12_EXPORT_PASSWORD="+password)
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("openssl pkcs12: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+//Uses crypto/x509 and crypto/rand, so openssl does not need to be installed
+type nativeBackend struct {
+	passphrase PassphraseFunc
+}
+
+func (backend nativeBackend) generatePrivateKey(filename, algorithm, encryption string) error {
+	err := validateKeyEncryption(encryption)
+	if err != nil {
+		return err
+	}
+
+	key, err := newPrivateKey(algorithm)
+	if err != nil {
+		return err
+	}
+	if encryption == "none" {
+		return writePrivateKey(filename, key)
+	}
+
+	passphrase, err := backend.passphrase(filename, true)
+	if err != nil {
+		return err
+	}
+	return writeEncryptedPrivateKey(filename, key, encryption, passphrase)
+}
+
+//Generates a key using one of the KeyAlgorithms
+func newPrivateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case "rsa2048":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "rsa3072":
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case "rsa4096":
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case "ecdsa-p256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, validateKeyAlgorithm(algorithm)
+}
+
+func (backend nativeBackend) generateCertificateSigningRequest(privateKey, outputCertificateSigningRequest, configuration string) error {
+	key, err := readPrivateKey(privateKey, backend.passphrase)
+	if err != nil {
+		return err
+	}
+
+	conf, err := readOpensslConfiguration(configuration)
+	if err != nil {
+		return err
+	}
+
+	distinguishedNameSection := conf.get("req", "distinguished_name")
+	if distinguishedNameSection == "" {
+		return fmt.Errorf("%s: no distinguished_name in the [req] section", configuration)
+	}
+
+	template := &x509.CertificateRequest{Subject: conf.distinguishedName(distinguishedNameSection)}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return err
+	}
+	return writePEM(outputCertificateSigningRequest, "CERTIFICATE REQUEST", der, 0644)
+}
+
+func (backend nativeBackend) generateSelfSignedCertificate(privateKey, configuration, outputCertificate, certificateSigningRequest, outputDirectory string) error {
+	key, err := readPrivateKey(privateKey, backend.passphrase)
+	if err != nil {
+		return err
+	}
+	return signCertificateRequest(certificateSigningRequest, outputCertificate, configuration, key, nil, outputDirectory)
+}
+
+func (backend nativeBackend) generateSignedCertificate(certificateSigningRequest, outputCertificate, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputDirectory string) error {
+	key, err := readPrivateKey(certificateAuthoritySigningKey, backend.passphrase)
+	if err != nil {
+		return err
+	}
+
+	issuer, err := readCertificate(certificateAuthorityCertificate)
+	if err != nil {
+		return err
+	}
+	return signCertificateRequest(certificateSigningRequest, outputCertificate, certificateAuthorityConfiguration, key, issuer, outputDirectory)
+}
+
+//Does the work of openssl ca -revoke: marks the certificate revoked in the database of the default_ca section of configuration.
+//The reason is one of revocationReasons, or empty to leave it out.
+func (nativeBackend) revokeCertificate(certificate, reason, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate string) error {
+	revokedCertificate, err := readCertificate(certificate)
+	if err != nil {
+		return err
+	}
+
+	issuer, err := readCertificate(certificateAuthorityCertificate)
+	if err != nil {
+		return err
+	}
+
+	err = revokedCertificate.CheckSignatureFrom(issuer)
+	if err != nil {
+		return fmt.Errorf("%s was not issued by %s: %w", certificate, certificateAuthorityCertificate, err)
+	}
+
+	conf, err := readOpensslConfiguration(certificateAuthorityConfiguration)
+	if err != nil {
+		return err
+	}
+
+	database := conf.get(conf.get("ca", "default_ca"), "database")
+	entries, err := readDatabase(database)
+	if err != nil {
+		return err
+	}
+
+	serialNumber := serialNumberHex(revokedCertificate.SerialNumber)
+	for index, entry := range entries {
+		if entry.serialNumber != serialNumber {
+			continue
+		}
+
+		if entry.status == "R" {
+			return fmt.Errorf("certificate %s is already revoked", serialNumber)
+		}
+
+		entries[index].status = "R"
+		entries[index].revocationDate = time.Now().UTC().Format("060102150405Z")
+		if reason != "" {
+			entries[index].revocationDate += "," + reason
+		}
+		return writeDatabase(database, entries)
+	}
+	return fmt.Errorf("certificate %s is not in %s", serialNumber, database)
+}
+
+//Does the work of openssl ca -gencrl: signs a PEM CRL listing every revoked certificate in the database of the
+//default_ca section of configuration, valid for default_crl_days, and advances the crlnumber file
+func (backend nativeBackend) generateCertificateRevocationList(certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputCertificateRevocationList string) error {
+	key, err := readPrivateKey(certificateAuthoritySigningKey, backend.passphrase)
+	if err != nil {
+		return err
+	}
+
+	issuer, err := readCertificate(certificateAuthorityCertificate)
+	if err != nil {
+		return err
+	}
+
+	conf, err := readOpensslConfiguration(certificateAuthorityConfiguration)
+	if err != nil {
+		return err
+	}
+
+	caSection := conf.get("ca", "default_ca")
+	days, err := strconv.Atoi(conf.get(caSection, "default_crl_days"))
+	if err != nil {
+		return fmt.Errorf("%s: invalid default_crl_days: %w", certificateAuthorityConfiguration, err)
+	}
+
+	signatureAlgorithm, err := signatureAlgorithmFor(key, conf.get(caSection, "default_md"))
+	if err != nil {
+		return fmt.Errorf("%s: %w", certificateAuthorityConfiguration, err)
+	}
+
+	entries, err := readDatabase(conf.get(caSection, "database"))
+	if err != nil {
+		return err
+	}
+
+	thisUpdate := time.Now().UTC().Truncate(time.Second)
+	template := &x509.RevocationList{
+		ThisUpdate:         thisUpdate,
+		NextUpdate:         thisUpdate.AddDate(0, 0, days),
+		SignatureAlgorithm: signatureAlgorithm,
+	}
+
+	for _, entry := range entries {
+		if entry.status != "R" {
+			continue
+		}
+
+		revokedEntry, err := entry.revocationListEntry()
+		if err != nil {
+			return err
+		}
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, revokedEntry)
+	}
+
+	numberFile := conf.get(caSection, "crlnumber")
+	template.Number, err = nextSerialNumber(numberFile)
+	if err != nil {
+		return err
+	}
+
+	//A certificate without the keyUsage extension may be used for anything, including signing CRLs,
+	//but crypto/x509 only signs a CRL when the cRLSign bit is present
+	if issuer.KeyUsage == 0 {
+		issuer.KeyUsage = x509.KeyUsageCRLSign
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, issuer, key)
+	if err != nil {
+		return err
+	}
+
+	err = writePEM(outputCertificateRevocationList, "X509 CRL", der, 0644)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(numberFile, []byte(serialNumberHex(new(big.Int).Add(template.Number, big.NewInt(1)))+"\n"), 0644)
+}
+
+//The reasons a certificate can be revoked for, as named by openssl ca -crl_reason, with their RFC 5280 reason codes
+var revocationReasons = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"CACompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"certificateHold":      6,
+}
+
+//Returns the revocationReasons key matching reason, ignoring case as openssl does
+func revocationReasonName(reason string) (string, error) {
+	for name := range revocationReasons {
+		if strings.EqualFold(name, reason) {
+			return name, nil
+		}
+	}
+
+	names := []string{}
+	for name := range revocationReasons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return "", newError(InvalidError, "unknown revocation reason %q, expected one of %s", reason, strings.Join(names, ", "))
+}
+
+//Writes a PKCS#12 keystore holding the private key, when one is given, and the certificates in order
+func (backend nativeBackend) exportPKCS12(outputPKCS12, privateKey string, certificates []string, friendlyName, password, encryption string) error {
+	var signer crypto.Signer
+	if privateKey != "" {
+		var err error
+		signer, err = readPrivateKey(privateKey, backend.passphrase)
+		if err != nil {
+			return err
+		}
+	}
+
+	parsed := []*x509.Certificate{}
+	for _, certificate := range certificates {
+		certificate, err := readCertificate(certificate)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, certificate)
+	}
+	return writePKCS12(outputPKCS12, signer, parsed, friendlyName, password, encryption)
+}
+
+//Does the work of openssl ca: issues a certificate for the request using the default_ca section of configuration,
+//records it in the database, advances the serial number file and places a copy named after the serial in outputDirectory.
+//A nil issuer produces a self-signed certificate.
+func signCertificateRequest(certificateSigningRequest, outputCertificate, configuration string, signer crypto.Signer, issuer *x509.Certificate, outputDirectory string) error {
+	request, err := readCertificateRequest(certificateSigningRequest)
+	if err != nil {
+		return err
+	}
+
+	conf, err := readOpensslConfiguration(configuration)
+	if err != nil {
+		return err
+	}
+
+	caSection := conf.get("ca", "default_ca")
+	if caSection == "" {
+		return fmt.Errorf("%s: no default_ca in the [ca] section", configuration)
+	}
+
+	days, err := strconv.Atoi(conf.get(caSection, "default_days"))
+	if err != nil {
+		return fmt.Errorf("%s: invalid default_days: %w", configuration, err)
+	}
+
+	serialNumberFile := conf.get(caSection, "serial")
+	serialNumber, err := nextSerialNumber(serialNumberFile)
+	if err != nil {
+		return err
+	}
+
+	signatureAlgorithm, err := signatureAlgorithmFor(signer, conf.get(caSection, "default_md"))
+	if err != nil {
+		return fmt.Errorf("%s: %w", configuration, err)
+	}
+
+	notBefore := time.Now().UTC().Truncate(time.Second)
+	template := &x509.Certificate{
+		SerialNumber:       serialNumber,
+		Subject:            request.Subject,
+		NotBefore:          notBefore,
+		NotAfter:           notBefore.AddDate(0, 0, days),
+		SignatureAlgorithm: signatureAlgorithm,
+	}
+
+	extensionsSection := conf.get(caSection, "x509_extensions")
+	if extensionsSection != "" {
+		err = conf.applyExtensions(extensionsSection, template, request.PublicKey, issuer)
+		if err != nil {
+			return err
+		}
+	}
+
+	//copy_extensions=copy takes the subject alternative names from the request when the configuration supplies none
+	if conf.get(caSection, "copy_extensions") == "copy" && len(template.DNSNames) == 0 && len(template.IPAddresses) == 0 {
+		template.DNSNames = request.DNSNames
+		template.IPAddresses = request.IPAddresses
+		template.EmailAddresses = request.EmailAddresses
+		template.URIs = request.URIs
+	}
+
+	if issuer == nil {
+		issuer = template
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, request.PublicKey, signer)
+	if err != nil {
+		return err
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+
+	err = writePEM(outputCertificate, "CERTIFICATE", der, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = writePEM(outputDirectory+"/"+serialNumberHex(serialNumber)+".pem", "CERTIFICATE", der, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = recordIssuedCertificate(conf.get(caSection, "database"), certificate)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(serialNumberFile, []byte(serialNumberHex(new(big.Int).Add(serialNumber, big.NewInt(1)))+"\n"), 0644)
+}
+
+//Picks the signature algorithm for the signing key and the default_md digest, the way openssl does.
+//Ed25519 signatures have no separate digest, so default_md is ignored for them.
+func signatureAlgorithmFor(signer crypto.Signer, digest string) (x509.SignatureAlgorithm, error) {
+	digests := map[string]int{"": 0, "sha256": 0, "sha384": 1, "sha512": 2}
+	index, ok := digests[digest]
+	if !ok {
+		return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported default_md %q", digest)
+	}
+
+	switch signer.Public().(type) {
+	case *rsa.PublicKey:
+		return []x509.SignatureAlgorithm{x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA}[index], nil
+	case *ecdsa.PublicKey:
+		return []x509.SignatureAlgorithm{x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512}[index], nil
+	case ed25519.PublicKey:
+		return x509.PureEd25519, nil
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported signing key type %T", signer.Public())
+}
+
+//Reads the hexadecimal serial number stored in an openssl serial number file
+func nextSerialNumber(serialNumberFile string) (*big.Int, error) {
+	contents, err := ioutil.ReadFile(serialNumberFile)
+	if err != nil {
+		return nil, err
+	}
+
+	serialNumber, ok := new(big.Int).SetString(strings.TrimSpace(string(contents)), 16)
+	if !ok {
+		return nil, fmt.Errorf("%s does not contain a hexadecimal serial number", serialNumberFile)
+	}
+	return serialNumber, nil
+}
+
+//Formats a serial number the way openssl does: upper case hexadecimal with an even number of digits
+func serialNumberHex(serialNumber *big.Int) string {
+	hex := strings.ToUpper(serialNumber.Text(16))
+	if len(hex)%2 == 1 {
+		hex = "0" + hex
+	}
+	return hex
+}
+
+//Appends a line in openssl index format to the certificate authority database
+func recordIssuedCertificate(database string, certificate *x509.Certificate) error {
+	file, err := os.OpenFile(database, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "V\t%s\t\t%s\tunknown\t%s\n",
+		certificate.NotAfter.UTC().Format("060102150405Z"), serialNumberHex(certificate.SerialNumber), opensslSubject(certificate.Subject))
+	return err
+}
+
+//A line of an openssl index file. The status is V for valid, R for revoked or E for expired.
+//Dates are in the YYMMDDHHMMSSZ format, and the revocation date is empty unless the certificate is revoked.
+type databaseEntry struct {
+	status         string
+	expiryDate     string
+	revocationDate string
+	serialNumber   string
+	filename       string
+	subject        string
+}
+
+//Reads every entry of an openssl index file
+func readDatabase(database string) ([]databaseEntry, error) {
+	contents, err := ioutil.ReadFile(database)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []databaseEntry{}
+	for number, line := range strings.Split(string(contents), "\n") {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 6 {
+			return nil, newError(IOError, "%s:%d: expected 6 tab separated fields", database, number+1)
+		}
+		entries = append(entries, databaseEntry{fields[0], fields[1], fields[2], fields[3], fields[4], fields[5]})
+	}
+	return entries, nil
+}
+
+//Returns the revocation time, and the reason if one was recorded, of a revoked entry.
+//openssl stores them together in the revocation date field, as in 261018063729Z,keyCompromise
+func (entry databaseEntry) revocationListEntry() (x509.RevocationListEntry, error) {
+	serialNumber, ok := new(big.Int).SetString(entry.serialNumber, 16)
+	if !ok {
+		return x509.RevocationListEntry{}, fmt.Errorf("invalid serial number %q in database", entry.serialNumber)
+	}
+
+	date, reason, _ := strings.Cut(entry.revocationDate, ",")
+	revocationTime, err := time.Parse("060102150405Z", date)
+	if err != nil {
+		return x509.RevocationListEntry{}, fmt.Errorf("invalid revocation date of certificate %s: %w", entry.serialNumber, err)
+	}
+
+	revokedEntry := x509.RevocationListEntry{SerialNumber: serialNumber, RevocationTime: revocationTime}
+	if reason != "" {
+		name, err := revocationReasonName(reason)
+		if err != nil {
+			return x509.RevocationListEntry{}, fmt.Errorf("certificate %s: %w", entry.serialNumber, err)
+		}
+		revokedEntry.ReasonCode = revocationReasons[name]
+	}
+	return revokedEntry, nil
+}
+
+//Replaces the contents of an openssl index file with entries
+func writeDatabase(database string, entries []databaseEntry) error {
+	contents := ""
+	for _, entry := range entries {
+		contents += strings.Join([]string{entry.status, entry.expiryDate, entry.revocationDate, entry.serialNumber, entry.filename, entry.subject}, "\t") + "\n"
+	}
+	return ioutil.WriteFile(database, []byte(contents), 0644)
+}
+
+//Formats a name the way openssl writes it in its databases, for example /O=Example/CN=example.test
+func opensslSubject(name pkix.Name) string {
+	subject := ""
+	for _, attribute := range name.ToRDNSequence() {
+		for _, value := range attribute {
+			shortName, ok := attributeShortNames[value.Type.String()]
+			if !ok {
+				shortName = value.Type.String()
+			}
+			subject += fmt.Sprintf("/%s=%v", shortName, value.Value)
+		}
+	}
+	return subject
+}
+
+var attributeShortNames = map[string]string{
+	"2.5.4.3":  "CN",
+	"2.5.4.6":  "C",
+	"2.5.4.7":  "L",
+	"2.5.4.8":  "ST",
+	"2.5.4.10": "O",
+	"2.5.4.11": "OU",
+}
+
+//Writes der to filename as a single PEM block
+func writePEM(filename, blockType string, der []byte, permissions os.FileMode) error {
+	return ioutil.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), permissions)
+}
+
+//Reads the first PEM block of the given type from filename
+func readPEM(filename, blockType string) ([]byte, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var block *pem.Block
+		block, contents = pem.Decode(contents)
+		if block == nil {
+			return nil, newError(IOError, "%s: no %s PEM block found", filename, blockType)
+		}
+		if block.Type == blockType {
+			return block.Bytes, nil
+		}
+	}
+}
+
+//Writes key as an unencrypted PKCS#8 PEM file, the same format as openssl genpkey
+func writePrivateKey(filename string, key crypto.Signer) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writePEM(filename, "PRIVATE KEY", der, 0600)
+}
+
+//Reads a PKCS#8 private key such as the ones written by generatePrivateKey. An encrypted key is unlocked with the
+//passphrase returned by passphrase, which can be nil for keys that are never encrypted, such as those of servers.
+func readPrivateKey(filename string, passphrase PassphraseFunc) (crypto.Signer, error) {
+	var der []byte
+	var err error
+	if isEncryptedPrivateKey(filename) {
+		if passphrase == nil {
+			return nil, newError(InvalidError, "%s is encrypted, and no passphrase was given to unlock it", filename)
+		}
+
+		encrypted, err := readPEM(filename, "ENCRYPTED PRIVATE KEY")
+		if err != nil {
+			return nil, err
+		}
+		key, err := passphrase(filename, false)
+		if err != nil {
+			return nil, err
+		}
+		der, err = decryptPrivateKey(filename, encrypted, key)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		der, err = readPEM(filename, "PRIVATE KEY")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, newError(IOError, "%s: %w", filename, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, newError(IOError, "%s: unsupported private key type %T", filename, key)
+	}
+	return signer, nil
+}
+
+func readCertificate(filename string) (*x509.Certificate, error) {
+	der, err := readPEM(filename, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, newError(IOError, "%s: %w", filename, err)
+	}
+	return certificate, nil
+}
+
+//Returns every certificate in a PEM file such as server_bundle.crt, in order
+func readCertificateBundle(filename string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	certificates := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certificates, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, newError(IOError, "%s: %w", filename, err)
+		}
+		certificates = append(certificates, certificate)
+	}
+}
+
+func readCertificateRequest(filename string) (*x509.CertificateRequest, error) {
+	der, err := readPEM(filename, "CERTIFICATE REQUEST")
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, err
+	}
+	return request, request.CheckSignature()
+}
+
+//An openssl configuration file, as a list of key value pairs per section.
+//Pairs before the first section header are stored under the section "default".
+type opensslConfiguration map[string][]configurationEntry
+
+type configurationEntry struct {
+	key   string
+	value string
+}
+
+//Parses the subset of the openssl configuration format used by the templates directory
+func readOpensslConfiguration(filename string) (opensslConfiguration, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := opensslConfiguration{}
+	section := "default"
+	for number, line := range strings.Split(string(contents), "\n") {
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("%s:%d: expected key=value", filename, number+1)
+		}
+		conf[section] = append(conf[section], configurationEntry{strings.TrimSpace(key), strings.TrimSpace(value)})
+	}
+	return conf, nil
+}
+
+//Returns the value of key in section, or the empty string
+func (conf opensslConfiguration) get(section, key string) string {
+	for _, entry := range conf[section] {
+		if entry.key == key {
+			return entry.value
+		}
+	}
+	return ""
+}
+
+//Builds a subject from a distinguished name section such as [distinguished_name_section]
+func (conf opensslConfiguration) distinguishedName(section string) pkix.Name {
+	var name pkix.Name
+	for _, entry := range conf[section] {
+		switch entry.key {
+		case "CN", "commonName":
+			name.CommonName = entry.value
+		case "O", "organizationName":
+			name.Organization = append(name.Organization, entry.value)
+		case "OU", "organizationalUnitName":
+			name.OrganizationalUnit = append(name.OrganizationalUnit, entry.value)
+		case "C", "countryName":
+			name.Country = append(name.Country, entry.value)
+		case "ST", "stateOrProvinceName":
+			name.Province = append(name.Province, entry.value)
+		case "L", "localityName":
+			name.Locality = append(name.Locality, entry.value)
+		}
+	}
+	return name
+}
+
+//Copies the extensions in an x509_extensions section onto template. publicKey is the key being certified,
+//which subjectKeyIdentifier=hash is computed from, and issuer is the certificate of the authority signing it, nil
+//when the certificate is self-signed.
+func (conf opensslConfiguration) applyExtensions(section string, template *x509.Certificate, publicKey crypto.PublicKey, issuer *x509.Certificate) error {
+	authorityKeyIdentifier := false
+	for _, entry := range conf[section] {
+		switch entry.key {
+		case "basicConstraints":
+			template.BasicConstraintsValid = true
+			for _, field := range strings.Split(entry.value, ",") {
+				name, value, _ := strings.Cut(strings.TrimSpace(field), ":")
+				switch name {
+				case "CA":
+					template.IsCA = strings.EqualFold(value, "true")
+				case "pathlen":
+					pathLength, err := strconv.Atoi(value)
+					if err != nil {
+						return fmt.Errorf("invalid pathlen %q in [%s]", value, section)
+					}
+					template.MaxPathLen = pathLength
+					template.MaxPathLenZero = pathLength == 0
+				}
+			}
+		case "keyUsage":
+			for _, field := range strings.Split(entry.value, ",") {
+				usage := strings.TrimSpace(field)
+				if usage == "critical" {
+					//crypto/x509 always marks keyUsage critical
+					continue
+				}
+
+				keyUsage, ok := keyUsageNames[usage]
+				if !ok {
+					return fmt.Errorf("unsupported keyUsage %q in [%s]", usage, section)
+				}
+				template.KeyUsage |= keyUsage
+			}
+		case "extendedKeyUsage":
+			for _, field := range strings.Split(entry.value, ",") {
+				usage := strings.TrimSpace(field)
+				if usage == "critical" {
+					continue
+				}
+
+				extendedKeyUsage, ok := extendedKeyUsageNames[usage]
+				if !ok {
+					return fmt.Errorf("unsupported extendedKeyUsage %q in [%s]", usage, section)
+				}
+				template.ExtKeyUsage = append(template.ExtKeyUsage, extendedKeyUsage)
+			}
+		case "noCheck":
+			//id-pkix-ocsp-nocheck tells clients not to check the revocation status of an OCSP responder certificate. Its value is NULL.
+			template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{Id: oidOCSPNoCheck, Value: asn1.NullBytes})
+		case "authorityInfoAccess":
+			for _, field := range strings.Split(entry.value, ",") {
+				method, location, _ := strings.Cut(strings.TrimSpace(field), ";")
+				uri, found := strings.CutPrefix(location, "URI:")
+				if !found {
+					return fmt.Errorf("unsupported authorityInfoAccess location %q in [%s]", location, section)
+				}
+
+				switch method {
+				case "OCSP":
+					template.OCSPServer = append(template.OCSPServer, uri)
+				case "caIssuers":
+					template.IssuingCertificateURL = append(template.IssuingCertificateURL, uri)
+				default:
+					return fmt.Errorf("unsupported authorityInfoAccess method %q in [%s]", method, section)
+				}
+			}
+		case "crlDistributionPoints":
+			for _, field := range strings.Split(entry.value, ",") {
+				uri, found := strings.CutPrefix(strings.TrimSpace(field), "URI:")
+				if !found {
+					return fmt.Errorf("unsupported crlDistributionPoints location %q in [%s]", field, section)
+				}
+				template.CRLDistributionPoints = append(template.CRLDistributionPoints, uri)
+			}
+		case "nameConstraints":
+			err := applyNameConstraints(entry.value, template)
+			if err != nil {
+				return fmt.Errorf("%w in [%s]", err, section)
+			}
+		case "subjectKeyIdentifier":
+			if entry.value != "hash" {
+				return fmt.Errorf("unsupported subjectKeyIdentifier %q in [%s]", entry.value, section)
+			}
+
+			keyIdentifier, err := subjectKeyIdentifier(publicKey)
+			if err != nil {
+				return err
+			}
+			template.SubjectKeyId = keyIdentifier
+		case "authorityKeyIdentifier":
+			authorityKeyIdentifier = true
+		case "subjectAltName":
+			err := conf.applySubjectAlternativeNames(entry.value, template)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	//A self-signed certificate identifies its own key. Any other certificate identifies its issuer's key, as openssl
+	//does, and has no authorityKeyIdentifier when the issuer has no subjectKeyIdentifier.
+	if authorityKeyIdentifier && issuer == nil {
+		template.AuthorityKeyId = template.SubjectKeyId
+	} else if authorityKeyIdentifier {
+		template.AuthorityKeyId = issuer.SubjectKeyId
+	}
+	return nil
+}
+
+//Adds the subtrees of a nameConstraints value such as critical,permitted;DNS:test,permitted;IP:10.0.0.0/255.0.0.0 to template
+func applyNameConstraints(value string, template *x509.Certificate) error {
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "critical" {
+			template.PermittedDNSDomainsCritical = true
+			continue
+		}
+
+		kind, subtree, _ := strings.Cut(field, ";")
+		nameType, name, _ := strings.Cut(subtree, ":")
+		if kind != "permitted" && kind != "excluded" {
+			return fmt.Errorf("unsupported nameConstraints subtree %q", field)
+		}
+		permitted := kind == "permitted"
+
+		switch nameType {
+		case "DNS":
+			if permitted {
+				template.PermittedDNSDomains = append(template.PermittedDNSDomains, name)
+			} else {
+				template.ExcludedDNSDomains = append(template.ExcludedDNSDomains, name)
+			}
+		case "IP":
+			address, mask, _ := strings.Cut(name, "/")
+			ip, maskIP := net.ParseIP(address), net.ParseIP(mask)
+			if ip == nil || maskIP == nil {
+				return fmt.Errorf("invalid nameConstraints IP range %q", name)
+			}
+			network := &net.IPNet{IP: ip, Mask: net.IPMask(maskIP)}
+			if ip.To4() != nil {
+				network = &net.IPNet{IP: ip.To4(), Mask: net.IPMask(maskIP.To4())}
+			}
+			if permitted {
+				template.PermittedIPRanges = append(template.PermittedIPRanges, network)
+			} else {
+				template.ExcludedIPRanges = append(template.ExcludedIPRanges, network)
+			}
+		case "email":
+			if permitted {
+				template.PermittedEmailAddresses = append(template.PermittedEmailAddresses, name)
+			} else {
+				template.ExcludedEmailAddresses = append(template.ExcludedEmailAddresses, name)
+			}
+		case "URI":
+			if permitted {
+				template.PermittedURIDomains = append(template.PermittedURIDomains, name)
+			} else {
+				template.ExcludedURIDomains = append(template.ExcludedURIDomains, name)
+			}
+		default:
+			return fmt.Errorf("unsupported nameConstraints type %q", nameType)
+		}
+	}
+	return nil
+}
+
+//Returns the SHA-1 hash of the subjectPublicKey BIT STRING of publicKey, the keyIdentifier openssl computes for subjectKeyIdentifier=hash
+func subjectKeyIdentifier(publicKey crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var publicKeyInfo struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	_, err = asn1.Unmarshal(der, &publicKeyInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	keyIdentifier := sha1.Sum(publicKeyInfo.PublicKey.RightAlign())
+	return keyIdentifier[:], nil
+}
+
+//The openssl names of the extendedKeyUsage purposes
+var extendedKeyUsageNames = map[string]x509.ExtKeyUsage{
+	"serverAuth":          x509.ExtKeyUsageServerAuth,
+	"clientAuth":          x509.ExtKeyUsageClientAuth,
+	"codeSigning":         x509.ExtKeyUsageCodeSigning,
+	"emailProtection":     x509.ExtKeyUsageEmailProtection,
+	"timeStamping":        x509.ExtKeyUsageTimeStamping,
+	"OCSPSigning":         x509.ExtKeyUsageOCSPSigning,
+	"anyExtendedKeyUsage": x509.ExtKeyUsageAny,
+}
+
+var oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+//The openssl names of the keyUsage bits
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digitalSignature": x509.KeyUsageDigitalSignature,
+	"nonRepudiation":   x509.KeyUsageContentCommitment,
+	"keyEncipherment":  x509.KeyUsageKeyEncipherment,
+	"dataEncipherment": x509.KeyUsageDataEncipherment,
+	"keyAgreement":     x509.KeyUsageKeyAgreement,
+	"keyCertSign":      x509.KeyUsageCertSign,
+	"cRLSign":          x509.KeyUsageCRLSign,
+	"encipherOnly":     x509.KeyUsageEncipherOnly,
+	"decipherOnly":     x509.KeyUsageDecipherOnly,
+}
+
+//Adds the names in a subjectAltName value to template. The value is either a comma separated list
+//such as DNS:example.test,IP:127.0.0.1 or a reference to a section such as @altNames
+func (conf opensslConfiguration) applySubjectAlternativeNames(value string, template *x509.Certificate) error {
+	var entries []configurationEntry
+	if strings.HasPrefix(value, "@") {
+		for _, entry := range conf[value[1:]] {
+			nameType, _, _ := strings.Cut(entry.key, ".")
+			entries = append(entries, configurationEntry{nameType, entry.value})
+		}
+	} else {
+		for _, field := range strings.Split(value, ",") {
+			nameType, name, _ := strings.Cut(strings.TrimSpace(field), ":")
+			entries = append(entries, configurationEntry{nameType, name})
+		}
+	}
+
+	for _, entry := range entries {
+		switch entry.key {
+		case "DNS":
+			template.DNSNames = append(template.DNSNames, entry.value)
+		case "IP":
+			ip := net.ParseIP(entry.value)
+			if ip == nil {
+				return fmt.Errorf("invalid IP address %q in subjectAltName", entry.value)
+			}
+			template.IPAddresses = append(template.IPAddresses, ip)
+		case "email":
+			template.EmailAddresses = append(template.EmailAddresses, entry.value)
+		case "URI":
+			uri, err := url.Parse(entry.value)
+			if err != nil {
+				return fmt.Errorf("invalid URI %q in subjectAltName: %w", entry.value, err)
+			}
+			template.URIs = append(template.URIs, uri)
+		default:
+			return fmt.Errorf("unsupported subjectAltName type %q", entry.key)
+		}
+	}
+	return nil
+}
+
+//Generates a certificate based off of the root private key, root authority openssl confiration file, output filename and output directory
+func (ca *CA) generateSelfSignedCertificate(privateKey, configuration, outputCertificateFilename, certificateSigningRequest, outputDirectory string) error {
+	fmt.Fprintln(ca.log, "Inside generateSelfSignedCertificate", outputCertificateFilename)
+	err := ca.backend.generateSelfSignedCertificate(privateKey, configuration, outputCertificateFilename, certificateSigningRequest, outputDirectory)
+	if err != nil {
+		return fmt.Errorf("generating the self-signed certificate %s: %w", outputCertificateFilename, err)
+	}
+	return nil
+}
+
+//privateKey is a string specifying the filepath of the private key for the entity performing the sign
+//outputCertificate is a string specifying the filepath of the certificate that will be generated
+//configuration is a string specifying the filepath of a file containing data to be signed
+func (ca *CA) generateCertificateSigningRequest(privateKey, outputCertificate, configuration string) error {
+	fmt.Fprintln(ca.log, "Inside generateCertificateSigningRequest. output is:", outputCertificate)
+	err := ca.backend.generateCertificateSigningRequest(privateKey, outputCertificate, configuration)
+	if err != nil {
+		return fmt.Errorf("generating the certificate signing request %s using the key %s with the configuration %s: %w", outputCertificate, privateKey, configuration, err)
+	}
+	return nil
+}
+
+//Generates a signed certificate using the certificate authority configuration, key and certificate
+func (ca *CA) generateSignedCertificate(certificateSigningRequest, outputCertificateFilepath, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputCertificateDirectory string) error {
+	fmt.Fprintln(ca.log, "Inside generateSignedCertificate the output is:", outputCertificateFilepath)
+	err := ca.backend.generateSignedCertificate(certificateSigningRequest, outputCertificateFilepath, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputCertificateDirectory)
+	if err != nil {
+		return fmt.Errorf("generating the signed certificate %s: %w", outputCertificateFilepath, err)
+	}
+	return nil
+}
+
+//Generates a private key with the given algorithm using the selected backend
+func (ca *CA) generatePrivateKey(filename, algorithm, encryption string) error {
+	err := ca.backend.generatePrivateKey(filename, algorithm, encryption)
+	if err != nil {
+		return fmt.Errorf("generating %s private key %s: %w", algorithm, filename, err)
+	}
+	return nil
+}
+
+//Returns true if file or directory passed in exists
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadDatabase(t *testing.T) {
+	database := filepath.Join(t.TempDir(), "index.txt")
+	err := os.WriteFile(database, []byte(
+		"V\t361018063729Z\t\t0A\tunknown\t/O=Example/CN=example.test\n"+
+			"R\t361018063729Z\t261018063729Z,keyCompromise\t0B\tunknown\t/CN=revoked.test\n"+
+			"\n"+
+			"E\t250101000000Z\t\t0C\tunknown\t/CN=expired.test\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := readDatabase(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []databaseEntry{
+		{"V", "361018063729Z", "", "0A", "unknown", "/O=Example/CN=example.test"},
+		{"R", "361018063729Z", "261018063729Z,keyCompromise", "0B", "unknown", "/CN=revoked.test"},
+		{"E", "250101000000Z", "", "0C", "unknown", "/CN=expired.test"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("readDatabase returned\n%v\nexpected\n%v", entries, expected)
+	}
+}
+
+func TestReadDatabaseErrors(t *testing.T) {
+	database := filepath.Join(t.TempDir(), "index.txt")
+	err := os.WriteFile(database, []byte("V\t361018063729Z\t\t0A\tunknown\t/CN=example.test\nV\t361018063729Z\t0B\t/CN=short.test\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readDatabase(database)
+	if err == nil || !strings.Contains(err.Error(), "index.txt:2: expected 6 tab separated fields") || KindOf(err) != IOError {
+		t.Errorf("readDatabase returned the error %v, expected an IOError on line 2", err)
+	}
+
+	_, err = readDatabase(filepath.Join(t.TempDir(), "missing.txt"))
+	if !os.IsNotExist(err) {
+		t.Errorf("readDatabase of a missing file returned the error %v", err)
+	}
+}
+
+func TestRecordIssuedCertificate(t *testing.T) {
+	database := filepath.Join(t.TempDir(), "index.txt")
+	certificate := &x509.Certificate{
+		SerialNumber: big.NewInt(0xabc),
+		NotAfter:     time.Date(2036, 10, 18, 6, 37, 29, 0, time.UTC),
+		Subject:      pkix.Name{Organization: []string{"Example"}, CommonName: "example.test"},
+	}
+	for range 2 {
+		err := recordIssuedCertificate(database, certificate)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := readDatabase(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := databaseEntry{"V", "361018063729Z", "", "0ABC", "unknown", "/O=Example/CN=example.test"}
+	if !reflect.DeepEqual(entries, []databaseEntry{entry, entry}) {
+		t.Errorf("recordIssuedCertificate wrote %v, expected two copies of %v", entries, entry)
+	}
+}
+
+func TestRevocationListEntry(t *testing.T) {
+	revocationTime := time.Date(2026, 10, 18, 6, 37, 29, 0, time.UTC)
+	tests := []struct {
+		revocationDate string
+		reasonCode     int
+	}{
+		{"261018063729Z", 0},
+		{"261018063729Z,keyCompromise", 1},
+		{"261018063729Z,superseded", 4},
+	}
+	for _, test := range tests {
+		entry := databaseEntry{"R", "361018063729Z", test.revocationDate, "0ABC", "unknown", "/CN=example.test"}
+		revoked, err := entry.revocationListEntry()
+		if err != nil {
+			t.Errorf("revocationListEntry of %s returned the error %v", test.revocationDate, err)
+			continue
+		}
+		if revoked.SerialNumber.Int64() != 0xabc || !revoked.RevocationTime.Equal(revocationTime) || revoked.ReasonCode != test.reasonCode {
+			t.Errorf("revocationListEntry of %s returned %s, %s, %d", test.revocationDate, revoked.SerialNumber, revoked.RevocationTime, revoked.ReasonCode)
+		}
+	}
+
+	for _, entry := range []databaseEntry{
+		{"R", "361018063729Z", "261018063729Z", "not hex", "unknown", "/CN=example.test"},
+		{"R", "361018063729Z", "yesterday", "0ABC", "unknown", "/CN=example.test"},
+		{"R", "361018063729Z", "261018063729Z,tired", "0ABC", "unknown", "/CN=example.test"},
+	} {
+		_, err := entry.revocationListEntry()
+		if err == nil {
+			t.Errorf("revocationListEntry of %v didn't return an error", entry)
+		}
+	}
+}
+
+func TestApplyExtensionsAuthorityKeyIdentifier(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyIdentifier, err := subjectKeyIdentifier(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := opensslConfiguration{"extensions": {{"subjectKeyIdentifier", "hash"}, {"authorityKeyIdentifier", "keyid:always"}}}
+
+	tests := []struct {
+		name     string
+		issuer   *x509.Certificate
+		expected []byte
+	}{
+		{"self-signed", nil, keyIdentifier},
+		{"issued", &x509.Certificate{SubjectKeyId: []byte{1, 2, 3}}, []byte{1, 2, 3}},
+		{"issuer without subjectKeyIdentifier", &x509.Certificate{}, nil},
+	}
+	for _, test := range tests {
+		template := &x509.Certificate{}
+		err := conf.applyExtensions("extensions", template, key.Public(), test.issuer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(template.SubjectKeyId, keyIdentifier) || !bytes.Equal(template.AuthorityKeyId, test.expected) {
+			t.Errorf("%s: applyExtensions set the subjectKeyIdentifier %x and the authorityKeyIdentifier %x, expected %x and %x", test.name, template.SubjectKeyId, template.AuthorityKeyId, keyIdentifier, test.expected)
+		}
+	}
+}
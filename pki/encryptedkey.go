@@ -0,0 +1,452 @@
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"math/bits"
+	"os"
+	"slices"
+	"strings"
+)
+
+//How the root and intermediate private keys are written: unencrypted, or as encrypted PKCS#8 with AES-256-CBC and a key
+//derived from a passphrase with PBKDF2-HMAC-SHA256 or with scrypt
+var KeyEncryptions = []string{"none", "pbkdf2", "scrypt"}
+
+//Returns the passphrase of the encrypted private key in the file privateKey. confirm is set when a new key is about
+//to be encrypted with it, so that an interactive prompt can ask for it twice.
+type PassphraseFunc func(privateKey string, confirm bool) (string, error)
+
+//The cost of deriving the key. scrypt uses the parameters openssl pkcs8 -scrypt defaults to, which stay within the
+//32 MiB of memory openssl allows scrypt when decrypting.
+const (
+	keyEncryptionIterations = 600000
+	scryptCost              = 1 << 14
+	scryptBlockSize         = 8
+	scryptParallelization   = 1
+)
+
+var (
+	oidScrypt         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+)
+
+type scryptParameters struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+//Returns an error naming the supported encryptions if encryption is not one of them
+func validateKeyEncryption(encryption string) error {
+	if slices.Contains(KeyEncryptions, encryption) {
+		return nil
+	}
+	return fmt.Errorf("unknown key encryption %q, expected one of %s", encryption, strings.Join(KeyEncryptions, ", "))
+}
+
+//Returns true if filename holds an encrypted PKCS#8 private key
+func isEncryptedPrivateKey(filename string) bool {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false
+	}
+
+	block, _ := pem.Decode(contents)
+	return block != nil && block.Type == "ENCRYPTED PRIVATE KEY"
+}
+
+//Writes key as an encrypted PKCS#8 PEM file, the same format as openssl pkcs8 -topk8 -v2 aes-256-cbc
+func writeEncryptedPrivateKey(filename string, key crypto.Signer, encryption, passphrase string) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := encryptPrivateKey(der, encryption, passphrase)
+	if err != nil {
+		return err
+	}
+	return writePEM(filename, "ENCRYPTED PRIVATE KEY", encrypted, 0600)
+}
+
+//Encrypts a PKCS#8 private key into an EncryptedPrivateKeyInfo using PBES2 with AES-256-CBC
+func encryptPrivateKey(der []byte, encryption, passphrase string) ([]byte, error) {
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	_, err = rand.Read(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	var key []byte
+	var keyDerivation pkix.AlgorithmIdentifier
+	switch encryption {
+	case "pbkdf2":
+		key, err = pbkdf2.Key(sha256.New, passphrase, salt, keyEncryptionIterations, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		var parameters []byte
+		parameters, err = asn1.Marshal(pbkdf2Parameters{Salt: salt, IterationCount: keyEncryptionIterations, KeyLength: 32, PRF: pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue}})
+		keyDerivation = pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: parameters}}
+	case "scrypt":
+		key, err = scryptKey([]byte(passphrase), salt, scryptCost, scryptBlockSize, scryptParallelization, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		var parameters []byte
+		parameters, err = asn1.Marshal(scryptParameters{Salt: salt, CostParameter: scryptCost, BlockSize: scryptBlockSize, ParallelizationParameter: scryptParallelization, KeyLength: 32})
+		keyDerivation = pkix.AlgorithmIdentifier{Algorithm: oidScrypt, Parameters: asn1.RawValue{FullBytes: parameters}}
+	default:
+		return nil, validateKeyEncryption(encryption)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ivParameter, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	parameters, err := asn1.Marshal(pbes2Parameters{
+		KeyDerivationFunction: keyDerivation,
+		EncryptionScheme:      pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParameter}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	//PKCS#7 padding always adds between one and a whole block of padding
+	padding := block.BlockSize() - len(der)%block.BlockSize()
+	padded := append(slices.Clone(der), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+
+	return asn1.Marshal(pkcs12EncryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: parameters}},
+		EncryptedData: padded,
+	})
+}
+
+//Decrypts an EncryptedPrivateKeyInfo using PBES2 with PBKDF2 or scrypt and AES-CBC, which covers the keys written by
+//encryptPrivateKey and by openssl 1.1 and later. filename is only used in error messages.
+func decryptPrivateKey(filename string, der []byte, passphrase string) ([]byte, error) {
+	var info pkcs12EncryptedPrivateKeyInfo
+	_, err := asn1.Unmarshal(der, &info)
+	if err != nil {
+		return nil, newError(IOError, "%s: %w", filename, err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, newError(IOError, "%s: unsupported key encryption %s, expected PBES2", filename, info.Algorithm.Algorithm)
+	}
+
+	var parameters pbes2Parameters
+	_, err = asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &parameters)
+	if err != nil {
+		return nil, newError(IOError, "%s: %w", filename, err)
+	}
+
+	var keyLength int
+	switch {
+	case parameters.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keyLength = 16
+	case parameters.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+		keyLength = 24
+	case parameters.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keyLength = 32
+	default:
+		return nil, newError(IOError, "%s: unsupported cipher %s, expected AES-CBC", filename, parameters.EncryptionScheme.Algorithm)
+	}
+
+	var iv []byte
+	_, err = asn1.Unmarshal(parameters.EncryptionScheme.Parameters.FullBytes, &iv)
+	if err != nil || len(iv) != aes.BlockSize {
+		return nil, newError(IOError, "%s: invalid AES-CBC initialization vector", filename)
+	}
+
+	var key []byte
+	keyDerivation := parameters.KeyDerivationFunction
+	switch {
+	case keyDerivation.Algorithm.Equal(oidPBKDF2):
+		var pbkdf2Parameters pbkdf2Parameters
+		_, err = asn1.Unmarshal(keyDerivation.Parameters.FullBytes, &pbkdf2Parameters)
+		if err != nil {
+			return nil, newError(IOError, "%s: %w", filename, err)
+		}
+
+		//The pseudorandom function defaults to HMAC-SHA1 when it is left out
+		var newHash func() hash.Hash
+		switch prf := pbkdf2Parameters.PRF.Algorithm; {
+		case len(prf) == 0 || prf.Equal(oidHMACWithSHA1):
+			newHash = sha1.New
+		case prf.Equal(oidHMACWithSHA256):
+			newHash = sha256.New
+		case prf.Equal(oidHMACWithSHA512):
+			newHash = sha512.New
+		default:
+			return nil, newError(IOError, "%s: unsupported PBKDF2 function %s", filename, prf)
+		}
+		key, err = pbkdf2.Key(newHash, passphrase, pbkdf2Parameters.Salt, pbkdf2Parameters.IterationCount, keyLength)
+	case keyDerivation.Algorithm.Equal(oidScrypt):
+		var scryptParameters scryptParameters
+		_, err = asn1.Unmarshal(keyDerivation.Parameters.FullBytes, &scryptParameters)
+		if err != nil {
+			return nil, newError(IOError, "%s: %w", filename, err)
+		}
+		key, err = scryptKey([]byte(passphrase), scryptParameters.Salt, scryptParameters.CostParameter, scryptParameters.BlockSize, scryptParameters.ParallelizationParameter, keyLength)
+	default:
+		return nil, newError(IOError, "%s: unsupported key derivation %s, expected PBKDF2 or scrypt", filename, keyDerivation.Algorithm)
+	}
+	if err != nil {
+		return nil, newError(IOError, "%s: %w", filename, err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.EncryptedData) == 0 || len(info.EncryptedData)%aes.BlockSize != 0 {
+		return nil, newError(IOError, "%s: the encrypted key is not a whole number of AES blocks", filename)
+	}
+
+	decrypted := slices.Clone(info.EncryptedData)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, decrypted)
+
+	//A wrong passphrase shows up as invalid padding, or failing that, as a key that doesn't parse
+	wrongPassphrase := newError(InvalidError, "wrong passphrase for %s", filename)
+	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(decrypted[len(decrypted)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, wrongPassphrase
+	}
+	decrypted = decrypted[:len(decrypted)-padding]
+	_, err = x509.ParsePKCS8PrivateKey(decrypted)
+	if err != nil {
+		return nil, wrongPassphrase
+	}
+	return decrypted, nil
+}
+
+//Derives a key with scrypt (RFC 7914)
+func scryptKey(passphrase, salt []byte, cost, blockSize, parallelization, keyLength int) ([]byte, error) {
+	if cost <= 1 || cost&(cost-1) != 0 {
+		return nil, fmt.Errorf("the scrypt cost %d must be a power of 2 greater than 1", cost)
+	}
+	//Refuse parameters needing more than 1 GiB, the way openssl refuses those above its memory limit
+	if blockSize <= 0 || parallelization <= 0 || uint64(blockSize)*uint64(parallelization) >= 1<<30 || uint64(cost)*uint64(blockSize) > 1<<23 {
+		return nil, fmt.Errorf("scrypt parameters N=%d, r=%d, p=%d are out of range", cost, blockSize, parallelization)
+	}
+
+	blocks, err := pbkdf2.Key(sha256.New, string(passphrase), salt, 1, parallelization*128*blockSize)
+	if err != nil {
+		return nil, err
+	}
+
+	words := 32 * blockSize
+	x := make([]uint32, words)
+	v := make([]uint32, words*cost)
+	y := make([]uint32, words)
+	for start := 0; start < len(blocks); start += 128 * blockSize {
+		block := blocks[start : start+128*blockSize]
+		for index := range x {
+			x[index] = binary.LittleEndian.Uint32(block[index*4:])
+		}
+
+		//ROMix: fill v with successive mixes of x, then mix x with entries of v picked by x itself
+		for index := 0; index < cost; index++ {
+			copy(v[index*words:], x)
+			scryptBlockMix(x, y, blockSize)
+		}
+		for index := 0; index < cost; index++ {
+			j := int(x[words-16] & uint32(cost-1))
+			for word := range x {
+				x[word] ^= v[j*words+word]
+			}
+			scryptBlockMix(x, y, blockSize)
+		}
+
+		for index, word := range x {
+			binary.LittleEndian.PutUint32(block[index*4:], word)
+		}
+	}
+	return pbkdf2.Key(sha256.New, string(passphrase), blocks, 1, keyLength)
+}
+
+//Replaces b, 2 * blockSize 64 byte blocks, with the output of scrypt's BlockMix, using y as scratch space
+func scryptBlockMix(b, y []uint32, blockSize int) {
+	var x [16]uint32
+	copy(x[:], b[(2*blockSize-1)*16:])
+	for index := 0; index < 2*blockSize; index++ {
+		for word := range x {
+			x[word] ^= b[index*16+word]
+		}
+		salsa208(&x)
+
+		//Even blocks go to the first half of the output and odd blocks to the second half
+		output := (index/2 + (index%2)*blockSize) * 16
+		copy(y[output:output+16], x[:])
+	}
+	copy(b, y)
+}
+
+//Applies the Salsa20/8 core to x
+func salsa208(x *[16]uint32) {
+	input := *x
+	for round := 0; round < 8; round += 2 {
+		x[4] ^= bits.RotateLeft32(x[0]+x[12], 7)
+		x[8] ^= bits.RotateLeft32(x[4]+x[0], 9)
+		x[12] ^= bits.RotateLeft32(x[8]+x[4], 13)
+		x[0] ^= bits.RotateLeft32(x[12]+x[8], 18)
+		x[9] ^= bits.RotateLeft32(x[5]+x[1], 7)
+		x[13] ^= bits.RotateLeft32(x[9]+x[5], 9)
+		x[1] ^= bits.RotateLeft32(x[13]+x[9], 13)
+		x[5] ^= bits.RotateLeft32(x[1]+x[13], 18)
+		x[14] ^= bits.RotateLeft32(x[10]+x[6], 7)
+		x[2] ^= bits.RotateLeft32(x[14]+x[10], 9)
+		x[6] ^= bits.RotateLeft32(x[2]+x[14], 13)
+		x[10] ^= bits.RotateLeft32(x[6]+x[2], 18)
+		x[3] ^= bits.RotateLeft32(x[15]+x[11], 7)
+		x[7] ^= bits.RotateLeft32(x[3]+x[15], 9)
+		x[11] ^= bits.RotateLeft32(x[7]+x[3], 13)
+		x[15] ^= bits.RotateLeft32(x[11]+x[7], 18)
+
+		x[1] ^= bits.RotateLeft32(x[0]+x[3], 7)
+		x[2] ^= bits.RotateLeft32(x[1]+x[0], 9)
+		x[3] ^= bits.RotateLeft32(x[2]+x[1], 13)
+		x[0] ^= bits.RotateLeft32(x[3]+x[2], 18)
+		x[6] ^= bits.RotateLeft32(x[5]+x[4], 7)
+		x[7] ^= bits.RotateLeft32(x[6]+x[5], 9)
+		x[4] ^= bits.RotateLeft32(x[7]+x[6], 13)
+		x[5] ^= bits.RotateLeft32(x[4]+x[7], 18)
+		x[11] ^= bits.RotateLeft32(x[10]+x[9], 7)
+		x[8] ^= bits.RotateLeft32(x[11]+x[10], 9)
+		x[9] ^= bits.RotateLeft32(x[8]+x[11], 13)
+		x[10] ^= bits.RotateLeft32(x[9]+x[8], 18)
+		x[12] ^= bits.RotateLeft32(x[15]+x[14], 7)
+		x[13] ^= bits.RotateLeft32(x[12]+x[15], 9)
+		x[14] ^= bits.RotateLeft32(x[13]+x[12], 13)
+		x[15] ^= bits.RotateLeft32(x[14]+x[13], 18)
+	}
+	for index := range x {
+		x[index] += input[index]
+	}
+}
+
+//Returns the passphrase of the authority key privateKey from Options.Passphrase. An existing key is unlocked with it
+//before it is remembered, so a mistyped passphrase is reported right away and asked for again on the next attempt.
+//The caller must hold ca.mutex.
+func (ca *CA) keyPassphrase(privateKey string, confirm bool) (string, error) {
+	if passphrase, found := ca.passphrases[privateKey]; found {
+		return passphrase, nil
+	}
+	if ca.options.Passphrase == nil {
+		return "", newError(InvalidError, "%s is encrypted, and no passphrase was given to unlock it", privateKey)
+	}
+
+	passphrase, err := ca.options.Passphrase(privateKey, confirm)
+	if err != nil {
+		return "", classify(err, InvalidError)
+	}
+	if passphrase == "" {
+		return "", newError(InvalidError, "the passphrase for %s must not be empty", privateKey)
+	}
+
+	if !confirm {
+		der, err := readPEM(privateKey, "ENCRYPTED PRIVATE KEY")
+		if err != nil {
+			return "", err
+		}
+		_, err = decryptPrivateKey(privateKey, der, passphrase)
+		if err != nil {
+			return "", err
+		}
+	}
+	ca.passphrases[privateKey] = passphrase
+	return passphrase, nil
+}
+
+//Encrypts the existing private keys of the root and intermediate authorities, named ones included, that are still
+//unencrypted, for the authorities whose KeyEncryption is not none, asking for their passphrases with Options.Passphrase. Returns the keys it encrypted.
+func (ca *CA) EncryptKeys() ([]string, error) {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	if ca.options.Root.KeyEncryption != "none" {
+		err := ca.checkRoot()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	authorities := []struct {
+		privateKey string
+		encryption string
+	}{{ca.fragments["rootAuthorityPrivateKey"], ca.fragments["rootAuthorityKeyEncryption"]}}
+	for _, intermediate := range ca.intermediateAuthorities() {
+		authorities = append(authorities, struct {
+			privateKey string
+			encryption string
+		}{ca.fragments[intermediate+"PrivateKey"], ca.fragments[intermediate+"KeyEncryption"]})
+	}
+
+	encrypted := []string{}
+	for _, authority := range authorities {
+		if authority.encryption == "none" || !fileExists(authority.privateKey) || isEncryptedPrivateKey(authority.privateKey) {
+			continue
+		}
+
+		key, err := readPrivateKey(authority.privateKey, nil)
+		if err != nil {
+			return encrypted, err
+		}
+
+		passphrase, err := ca.keyPassphrase(authority.privateKey, true)
+		if err != nil {
+			return encrypted, err
+		}
+
+		//The key is replaced in one step, so that an interruption leaves either the old or the new file
+		err = writeEncryptedPrivateKey(authority.privateKey+".new", key, authority.encryption, passphrase)
+		if err != nil {
+			return encrypted, classify(err, SigningError)
+		}
+		err = os.Rename(authority.privateKey+".new", authority.privateKey)
+		if err != nil {
+			return encrypted, classify(err, IOError)
+		}
+		fmt.Fprintln(ca.log, "Encrypted private key: "+authority.privateKey)
+		encrypted = append(encrypted, authority.privateKey)
+	}
+	return encrypted, nil
+}
@@ -0,0 +1,139 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//The test vector of RFC 7914 section 8
+func TestSalsa208(t *testing.T) {
+	input := decodeTestHex(t, `7e879a21 4f3ec986 7ca940e6 41718f26 baee555b 8c61c1b5 0df84611 6dcd3b1d
+		ee24f319 df9b3d85 14121e4b 5ac5aa32 76021d29 09c74829 edebc68d b8b8c25e`)
+	expected := decodeTestHex(t, `a41f859c 6608cc99 3b81cacb 020cef05 044b2181 a2fd337d fd7b1c63 96682f29
+		b4393168 e3c9e6bc fe6bc5b7 a06d96ba e424cc10 2c91745c 24ad673d c7618f81`)
+
+	var x [16]uint32
+	for index := range x {
+		x[index] = binary.LittleEndian.Uint32(input[index*4:])
+	}
+	salsa208(&x)
+
+	output := make([]byte, 64)
+	for index, word := range x {
+		binary.LittleEndian.PutUint32(output[index*4:], word)
+	}
+	if !bytes.Equal(output, expected) {
+		t.Errorf("salsa208 returned %x, expected %x", output, expected)
+	}
+}
+
+//The test vectors of RFC 7914 section 12, leaving out the last one, which needs 1 GiB of memory
+func TestScryptKey(t *testing.T) {
+	tests := []struct {
+		passphrase, salt                 string
+		cost, blockSize, parallelization int
+		expected                         string
+	}{
+		{"", "", 16, 1, 1, `77 d6 57 62 38 65 7b 20 3b 19 ca 42 c1 8a 04 97 f1 6b 48 44 e3 07 4a e8 df df fa 3f ed e2 14 42
+			fc d0 06 9d ed 09 48 f8 32 6a 75 3a 0f c8 1f 17 e8 d3 e0 fb 2e 0d 36 28 cf 35 e2 0c 38 d1 89 06`},
+		{"password", "NaCl", 1024, 8, 16, `fd ba be 1c 9d 34 72 00 78 56 e7 19 0d 01 e9 fe 7c 6a d7 cb c8 23 78 30 e7 73 76 63 4b 37 31 62
+			2e af 30 d9 2e 22 a3 88 6f f1 09 27 9d 98 30 da c7 27 af b9 4a 83 ee 6d 83 60 cb df a2 cc 06 40`},
+		{"pleaseletmein", "SodiumChloride", 16384, 8, 1, `70 23 bd cb 3a fd 73 48 46 1c 06 cd 81 fd 38 eb fd a8 fb ba 90 4f 8e 3e a9 b5 43 f6 54 5d a1 f2
+			d5 43 29 55 61 3f 0f cf 62 d4 97 05 24 2a 9a f9 e6 1e 85 dc 0d 65 1e 40 df cf 01 7b 45 57 58 87`},
+	}
+	for _, test := range tests {
+		key, err := scryptKey([]byte(test.passphrase), []byte(test.salt), test.cost, test.blockSize, test.parallelization, 64)
+		if err != nil {
+			t.Errorf("scryptKey(%q, %q) returned the error %v", test.passphrase, test.salt, err)
+			continue
+		}
+		if expected := decodeTestHex(t, test.expected); !bytes.Equal(key, expected) {
+			t.Errorf("scryptKey(%q, %q) returned %x, expected %x", test.passphrase, test.salt, key, expected)
+		}
+	}
+}
+
+func TestScryptKeyParameters(t *testing.T) {
+	tests := []struct{ cost, blockSize, parallelization int }{
+		{0, 8, 1},
+		{1, 8, 1},
+		{1000, 8, 1},
+		{16, 0, 1},
+		{16, 8, 0},
+		{1 << 21, 8, 1},
+	}
+	for _, test := range tests {
+		_, err := scryptKey([]byte("password"), []byte("NaCl"), test.cost, test.blockSize, test.parallelization, 32)
+		if err == nil {
+			t.Errorf("scryptKey with N=%d, r=%d, p=%d didn't return an error", test.cost, test.blockSize, test.parallelization)
+		}
+	}
+}
+
+func TestEncryptedPrivateKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, encryption := range []string{"pbkdf2", "scrypt"} {
+		t.Run(encryption, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "private.key")
+			err := writeEncryptedPrivateKey(filename, key, encryption, "correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !isEncryptedPrivateKey(filename) {
+				t.Fatalf("%s is not an encrypted private key", filename)
+			}
+
+			encrypted, err := readPEM(filename, "ENCRYPTED PRIVATE KEY")
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := decryptPrivateKey(filename, encrypted, "correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, der) {
+				t.Errorf("decryptPrivateKey returned a different key")
+			}
+
+			_, err = decryptPrivateKey(filename, encrypted, "wrong horse")
+			if err == nil || KindOf(err) != InvalidError || !strings.Contains(err.Error(), "wrong passphrase") {
+				t.Errorf("decryptPrivateKey with the wrong passphrase returned the error %v, expected a wrong passphrase", err)
+			}
+
+			signer, err := readPrivateKey(filename, func(string, bool) (string, error) { return "correct horse", nil })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !key.PublicKey.Equal(signer.Public()) {
+				t.Errorf("readPrivateKey returned a different key")
+			}
+
+			_, err = readPrivateKey(filename, nil)
+			if KindOf(err) != InvalidError {
+				t.Errorf("readPrivateKey without a passphrase returned the error %v, expected an InvalidError", err)
+			}
+		})
+	}
+}
+
+func TestEncryptPrivateKeyUnknownEncryption(t *testing.T) {
+	_, err := encryptPrivateKey([]byte{}, "rot13", "passphrase")
+	if err == nil || !strings.Contains(err.Error(), "unknown key encryption") {
+		t.Errorf("encryptPrivateKey returned the error %v, expected an unknown key encryption", err)
+	}
+}
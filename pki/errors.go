@@ -0,0 +1,86 @@
+package pki
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+)
+
+//What went wrong, so that callers can react to a kind of failure without matching error messages
+type ErrorKind int
+
+const (
+	//An option, argument or pki.toml value is not valid, or something it needs, such as the authorities or a certificate, doesn't exist yet
+	InvalidError ErrorKind = iota + 1
+	//The openssl backend is selected, but the openssl command is not installed
+	OpensslMissingError
+	//An openssl configuration template couldn't be read, or doesn't take the values filled into it
+	TemplateError
+	//A key, CSR, certificate, CRL or PKCS#12 file couldn't be generated or signed
+	SigningError
+	//A file or directory couldn't be read or written
+	IOError
+)
+
+func (kind ErrorKind) String() string {
+	switch kind {
+	case InvalidError:
+		return "invalid input"
+	case OpensslMissingError:
+		return "openssl not found"
+	case TemplateError:
+		return "template error"
+	case SigningError:
+		return "signing failed"
+	case IOError:
+		return "I/O error"
+	}
+	return "error"
+}
+
+//Every error returned by a CA or by Options wraps an Error, which tells what kind of failure it is
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (err *Error) Error() string {
+	return err.Err.Error()
+}
+
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+//Returns the kind of err: the kind of the Error it wraps, or IOError for file system errors, or 0 for any other error
+func KindOf(err error) ErrorKind {
+	var typed *Error
+	if errors.As(classify(err, 0), &typed) {
+		return typed.Kind
+	}
+	return 0
+}
+
+//Returns an Error of kind with a formatted message
+func newError(kind ErrorKind, format string, args ...any) error {
+	return &Error{kind, fmt.Errorf(format, args...)}
+}
+
+//Wraps err in an Error of kind, unless it is nil or already an Error. File system errors are always IOErrors,
+//and a missing executable is always an OpensslMissingError, since openssl is the only command a CA needs.
+func classify(err error, kind ErrorKind) error {
+	var typed *Error
+	var pathError *fs.PathError
+	var linkError *os.LinkError
+	switch {
+	case err == nil || errors.As(err, &typed):
+		return err
+	case errors.Is(err, exec.ErrNotFound):
+		return &Error{OpensslMissingError, fmt.Errorf("%w, install OpenSSL or use the native backend", err)}
+	case errors.As(err, &pathError) || errors.As(err, &linkError):
+		return &Error{IOError, err}
+	}
+	return &Error{kind, err}
+}
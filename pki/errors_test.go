@@ -0,0 +1,93 @@
+package pki
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err      error
+		kind     ErrorKind
+		expected ErrorKind
+	}{
+		{nil, SigningError, 0},
+		{errors.New("failed"), SigningError, SigningError},
+		{errors.New("failed"), 0, 0},
+		{newError(InvalidError, "invalid"), SigningError, InvalidError},
+		{fmt.Errorf("wrapped: %w", newError(TemplateError, "template")), SigningError, TemplateError},
+		{&exec.Error{Name: "openssl", Err: exec.ErrNotFound}, SigningError, OpensslMissingError},
+		{&fs.PathError{Op: "open", Path: "missing", Err: fs.ErrNotExist}, SigningError, IOError},
+		{fmt.Errorf("reading: %w", &fs.PathError{Op: "open", Path: "missing", Err: fs.ErrPermission}), InvalidError, IOError},
+		{&os.LinkError{Op: "rename", Old: "a", New: "b", Err: fs.ErrExist}, SigningError, IOError},
+	}
+	for _, test := range tests {
+		err := classify(test.err, test.kind)
+		if KindOf(err) != test.expected {
+			t.Errorf("classify(%v, %s) returned an error of kind %s, expected %s", test.err, test.kind, KindOf(err), test.expected)
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("classify(%v, %s) doesn't wrap the error", test.err, test.kind)
+		}
+	}
+
+	if kind := KindOf(&fs.PathError{Op: "open", Path: "missing", Err: fs.ErrNotExist}); kind != IOError {
+		t.Errorf("KindOf of an unclassified file system error returned %s, expected %s", kind, IOError)
+	}
+	if kind := KindOf(nil); kind != 0 {
+		t.Errorf("KindOf(nil) returned %s", kind)
+	}
+}
+
+func TestBackendErrorKinds(t *testing.T) {
+	directory := t.TempDir()
+	native := classifiedBackend{nativeBackend{}}
+
+	err := native.generatePrivateKey(filepath.Join(directory, "key.pem"), "rsa1024", "none")
+	if KindOf(err) != SigningError {
+		t.Errorf("generating a key with an unknown algorithm returned the error %v, expected a SigningError", err)
+	}
+	err = native.generatePrivateKey(filepath.Join(directory, "missing", "key.pem"), "ecdsa-p256", "none")
+	if KindOf(err) != IOError {
+		t.Errorf("generating a key in a missing directory returned the error %v, expected an IOError", err)
+	}
+	err = native.generateCertificateSigningRequest(filepath.Join(directory, "missing.pem"), filepath.Join(directory, "request.csr"), filepath.Join(directory, "missing.conf"))
+	if KindOf(err) != IOError {
+		t.Errorf("generating a CSR from a missing key returned the error %v, expected an IOError", err)
+	}
+
+	t.Setenv("PATH", directory)
+	openssl := classifiedBackend{opensslBackend{log: io.Discard}}
+	err = openssl.generatePrivateKey(filepath.Join(directory, "key.pem"), "ecdsa-p256", "none")
+	if KindOf(err) != OpensslMissingError {
+		t.Errorf("generating a key without openssl installed returned the error %v, expected an OpensslMissingError", err)
+	}
+
+	_, err = backendFromName("gnutls", nil, nil)
+	if err == nil {
+		t.Errorf("backendFromName of an unknown backend returned no error")
+	}
+	options := DefaultOptions()
+	options.OutputDirectory = filepath.Join(directory, "output")
+	options.Backend = "gnutls"
+	_, err = New(options)
+	if KindOf(err) != InvalidError {
+		t.Errorf("New with an unknown backend returned the error %v, expected an InvalidError", err)
+	}
+
+	options.Backend = "native"
+	options.TemplatesDirectory = directory
+	ca, err := New(options)
+	if err == nil {
+		err = ca.Init()
+	}
+	if KindOf(err) != TemplateError {
+		t.Errorf("Init without templates returned the error %v, expected a TemplateError", err)
+	}
+}
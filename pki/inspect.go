@@ -0,0 +1,173 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//The result of inspecting a leaf certificate, which encodes to the JSON printed by inspect -json: the certificates of its bundle, leaf first, and the checks made on them
+type InspectionReport struct {
+	Name         string              `json:"name"`
+	Bundle       string              `json:"bundle"`
+	Certificates []CertificateReport `json:"certificates"`
+	Checks       []CheckReport       `json:"checks"`
+	Valid        bool                `json:"valid"`
+}
+
+//One certificate of an inspected bundle
+type CertificateReport struct {
+	Subject       string    `json:"subject"`
+	Issuer        string    `json:"issuer"`
+	SerialNumber  string    `json:"serial_number"`
+	Names         []string  `json:"names"`
+	Key           string    `json:"key"`
+	IsCA          bool      `json:"is_ca"`
+	NotBefore     time.Time `json:"not_before"`
+	NotAfter      time.Time `json:"not_after"`
+	DaysRemaining int       `json:"days_remaining"`
+}
+
+//One check made by Inspect, such as chain or key, and what it found
+type CheckReport struct {
+	Check  string `json:"check"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+//Adds a check to the report. The report is only valid while every check has passed.
+func (report *InspectionReport) check(check string, err error, detail string) {
+	if err != nil {
+		detail = err.Error()
+	}
+	report.Checks = append(report.Checks, CheckReport{check, err == nil, detail})
+	report.Valid = report.Valid && err == nil
+}
+
+//Checks that the certificate is the first certificate of its chain, that the chain leads to the root authority's certificate
+//for server or client authentication, that the certificate covers hostname, and that the private key is the certificate's key.
+//Server certificates are checked against their domain name, their common name, when hostname is empty, while client
+//certificates are not checked against any.
+func (leaf *Leaf) Inspect(hostname string) InspectionReport {
+	usage := x509.ExtKeyUsageClientAuth
+	if leaf.Kind == "server" {
+		usage = x509.ExtKeyUsageServerAuth
+		if hostname == "" {
+			hostname = leaf.Name
+			//A server kept in a directory of its own isn't named after its domain
+			if certificate, err := readCertificate(leaf.Certificate); err == nil && certificate.Subject.CommonName != "" {
+				hostname = certificate.Subject.CommonName
+			}
+		}
+	}
+	return inspectLeaf(leaf.Name, leaf.Certificate, leaf.Chain, leaf.PrivateKey, leaf.fragments["rootAuthorityCertificate"], hostname, usage)
+}
+
+//Checks that certificate is the first certificate of bundle, that the bundle chains to rootCertificate for usage,
+//that the certificate covers hostname unless it is empty, and that privateKey is the certificate's key
+func inspectLeaf(name, certificateFile, bundle, privateKey, rootCertificate, hostname string, usage x509.ExtKeyUsage) InspectionReport {
+	report := InspectionReport{Name: name, Bundle: bundle, Certificates: []CertificateReport{}, Checks: []CheckReport{}, Valid: true}
+
+	certificates, err := readCertificateBundle(bundle)
+	if err == nil && len(certificates) == 0 {
+		err = fmt.Errorf("%s holds no certificates", bundle)
+	}
+	if err != nil {
+		report.check("bundle", err, "")
+		return report
+	}
+	for _, certificate := range certificates {
+		report.Certificates = append(report.Certificates, describeCertificate(certificate))
+	}
+
+	leaf := certificates[0]
+	certificate, err := readCertificate(certificateFile)
+	if err == nil && !certificate.Equal(leaf) {
+		err = fmt.Errorf("the bundle starts with serial %s instead of serial %s from %s, run renew -force to rebuild it", serialNumberHex(leaf.SerialNumber), serialNumberHex(certificate.SerialNumber), certificateFile)
+	}
+	report.check("bundle", err, "starts with "+certificateFile)
+
+	root, err := readCertificate(rootCertificate)
+	if err == nil {
+		roots := x509.NewCertPool()
+		roots.AddCert(root)
+		intermediates := x509.NewCertPool()
+		for _, certificate := range certificates[1:] {
+			intermediates.AddCert(certificate)
+		}
+
+		var chains [][]*x509.Certificate
+		chains, err = leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{usage}})
+		if err == nil {
+			subjects := []string{}
+			for _, certificate := range chains[0] {
+				subjects = append(subjects, certificate.Subject.String())
+			}
+			report.check("chain", nil, strings.Join(subjects, " <- "))
+		}
+	}
+	if err != nil {
+		report.check("chain", err, "")
+	}
+
+	if hostname != "" {
+		report.check("hostname", leaf.VerifyHostname(hostname), "covers "+hostname)
+	}
+
+	key, err := readPrivateKey(privateKey, nil)
+	if err == nil {
+		publicKey, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+		if !ok || !publicKey.Equal(leaf.PublicKey) {
+			err = fmt.Errorf("%s is not the key of the certificate", privateKey)
+		}
+	}
+	report.check("key", err, privateKey+" matches the certificate")
+	return report
+}
+
+//Returns the parts of a certificate that inspect reports
+func describeCertificate(certificate *x509.Certificate) CertificateReport {
+	return CertificateReport{
+		Subject:       certificate.Subject.String(),
+		Issuer:        certificate.Issuer.String(),
+		SerialNumber:  serialNumberHex(certificate.SerialNumber),
+		Names:         certificateNames(certificate),
+		Key:           describePublicKey(certificate.PublicKey),
+		IsCA:          certificate.IsCA,
+		NotBefore:     certificate.NotBefore,
+		NotAfter:      certificate.NotAfter,
+		DaysRemaining: int(time.Until(certificate.NotAfter).Hours() / 24),
+	}
+}
+
+//Returns the subject alternative names of a certificate: DNS names, IP addresses, email addresses and URIs
+func certificateNames(certificate *x509.Certificate) []string {
+	names := []string{}
+	names = append(names, certificate.DNSNames...)
+	for _, ip := range certificate.IPAddresses {
+		names = append(names, ip.String())
+	}
+	names = append(names, certificate.EmailAddresses...)
+	for _, uri := range certificate.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+//Describes a public key the way KeyAlgorithms names it, such as rsa2048 or ecdsa-p256
+func describePublicKey(publicKey any) string {
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("rsa%d", publicKey.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ecdsa-" + strings.ToLower(strings.ReplaceAll(publicKey.Curve.Params().Name, "-", ""))
+	case ed25519.PublicKey:
+		return "ed25519"
+	}
+	return fmt.Sprintf("%T", publicKey)
+}
@@ -0,0 +1,181 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+//Returns the checks of a report that failed, by name
+func failedChecks(report InspectionReport) []string {
+	failed := []string{}
+	for _, check := range report.Checks {
+		if !check.Passed {
+			failed = append(failed, check.Check)
+		}
+	}
+	return failed
+}
+
+func TestInspect(t *testing.T) {
+	ca := newTestCA(t, nil)
+	server, err := ca.IssueServer(newTestServer("example.test", "*.example.test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClientOptions("alice", []string{"alice@app.test"})
+	client.KeyAlgorithm = "ecdsa-p256"
+	clientLeaf, err := ca.IssueClient(client, "changeit", "modern")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := server.Inspect("")
+	checks := []string{}
+	for _, check := range report.Checks {
+		checks = append(checks, check.Check)
+	}
+	if !report.Valid || len(failedChecks(report)) > 0 || !slices.Equal(checks, []string{"bundle", "chain", "hostname", "key"}) {
+		t.Errorf("Inspect of a valid server certificate returned %+v", report)
+	}
+	if len(report.Certificates) != 3 || report.Certificates[0].Key != "ecdsa-p256" || report.Certificates[0].IsCA || !report.Certificates[2].IsCA ||
+		!slices.Equal(report.Certificates[0].Names, []string{"example.test", "*.example.test", "127.0.0.1"}) {
+		t.Errorf("Inspect of a server certificate described its bundle as %+v", report.Certificates)
+	}
+
+	report = server.Inspect("www.example.test")
+	if !report.Valid {
+		t.Errorf("Inspect of a server certificate with a name covered by its wildcard returned %+v", report)
+	}
+
+	report = server.Inspect("other.test")
+	if report.Valid || !slices.Equal(failedChecks(report), []string{"hostname"}) {
+		t.Errorf("Inspect of a server certificate with another host name failed %q, expected only the hostname check to fail", failedChecks(report))
+	}
+
+	report = clientLeaf.Inspect("")
+	if !report.Valid || slices.ContainsFunc(report.Checks, func(check CheckReport) bool { return check.Check == "hostname" }) {
+		t.Errorf("Inspect of a valid client certificate returned %+v", report)
+	}
+
+	//A client certificate isn't for server authentication
+	report = inspectLeaf("alice", clientLeaf.Certificate, clientLeaf.Chain, clientLeaf.PrivateKey, ca.RootCertificate(), "", x509.ExtKeyUsageServerAuth)
+	if report.Valid || !slices.Equal(failedChecks(report), []string{"chain"}) {
+		t.Errorf("Inspect of a client certificate for server authentication failed %q, expected the chain check to fail", failedChecks(report))
+	}
+
+	//Another key than the certificate's
+	key, err := newPrivateKey("ecdsa-p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey := filepath.Join(t.TempDir(), "other.pem")
+	err = writePrivateKey(otherKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = inspectLeaf(server.Name, server.Certificate, server.Chain, otherKey, ca.RootCertificate(), "example.test", x509.ExtKeyUsageServerAuth)
+	if report.Valid || !slices.Equal(failedChecks(report), []string{"key"}) || !strings.Contains(report.Checks[3].Detail, "is not the key of the certificate") {
+		t.Errorf("Inspect with another key returned %+v, expected the key check to fail", report.Checks)
+	}
+
+	//A bundle that doesn't start with the certificate
+	report = inspectLeaf(server.Name, clientLeaf.Certificate, server.Chain, server.PrivateKey, ca.RootCertificate(), "example.test", x509.ExtKeyUsageServerAuth)
+	if report.Valid || !slices.Equal(failedChecks(report), []string{"bundle"}) {
+		t.Errorf("Inspect of a bundle starting with another certificate failed %q, expected the bundle check to fail", failedChecks(report))
+	}
+
+	report = inspectLeaf(server.Name, server.Certificate, filepath.Join(t.TempDir(), "missing.crt"), server.PrivateKey, ca.RootCertificate(), "example.test", x509.ExtKeyUsageServerAuth)
+	if report.Valid || !slices.Equal(failedChecks(report), []string{"bundle"}) || len(report.Checks) != 1 {
+		t.Errorf("Inspect of a missing bundle returned %+v", report.Checks)
+	}
+}
+
+func TestInspectExpired(t *testing.T) {
+	directory := t.TempDir()
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Root"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, rootKey.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.test"},
+		DNSNames:     []string{"example.test"},
+		NotBefore:    now.Add(-48 * time.Hour),
+		NotAfter:     now.Add(-24 * time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, rootTemplate, leafKey.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, certificate, key := filepath.Join(directory, "root.crt"), filepath.Join(directory, "server.crt"), filepath.Join(directory, "server.pem")
+	for filename, der := range map[string][]byte{root: rootDER, certificate: leafDER} {
+		err = writePEM(filename, "CERTIFICATE", der, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = writePrivateKey(key, leafKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := inspectLeaf("example.test", certificate, certificate, key, root, "example.test", x509.ExtKeyUsageServerAuth)
+	if report.Valid || !slices.Equal(failedChecks(report), []string{"chain"}) || !strings.Contains(report.Checks[1].Detail, "expired") {
+		t.Errorf("Inspect of an expired certificate returned %+v, expected the chain check to fail", report.Checks)
+	}
+	if report.Certificates[0].DaysRemaining != -1 {
+		t.Errorf("Inspect of a certificate that expired a day ago reported %d days remaining", report.Certificates[0].DaysRemaining)
+	}
+}
+
+func TestDescribePublicKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519Key, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for expected, publicKey := range map[string]any{"rsa2048": &rsaKey.PublicKey, "ecdsa-p384": &ecdsaKey.PublicKey, "ed25519": ed25519Key, "string": "key"} {
+		if description := describePublicKey(publicKey); description != expected {
+			t.Errorf("describePublicKey returned %s, expected %s", description, expected)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package pki
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//An intermediate authority of the CA, as returned by Intermediates. Name is empty for the default intermediate
+//authority in output/intermediate_authority. Expires is zero until Init has created the certificate, and Issued
+//is the number of certificates recorded in the authority's database.
+type Intermediate struct {
+	Name           string
+	Directory      string
+	Certificate    string
+	CommonName     string
+	Expires        time.Time
+	PermittedNames []string
+	Issued         int
+}
+
+//Returns the prefix of the string fragments of the intermediate authority called name,
+//intermediateAuthority for the default one
+func intermediateAuthority(name string) string {
+	if name == "" {
+		return "intermediateAuthority"
+	}
+	return name + "IntermediateAuthority"
+}
+
+//Returns the prefixes of the string fragments of the default intermediate authority followed by the named ones
+func (ca *CA) intermediateAuthorities() []string {
+	authorities := []string{intermediateAuthority("")}
+	for _, intermediate := range ca.intermediates {
+		authorities = append(authorities, intermediateAuthority(intermediate.Name))
+	}
+	return authorities
+}
+
+//Returns the named intermediates of options followed by those found in output/intermediates that aren't listed,
+//which get the settings of the default intermediate. The settings of an existing authority only matter for its CRLs,
+//because its certificate and configuration files are kept.
+func findIntermediates(options Options) []IntermediateOptions {
+	intermediates := slices.Clone(options.Intermediates)
+	entries, _ := os.ReadDir(options.OutputDirectory + "/intermediates")
+	for _, entry := range entries {
+		if !entry.IsDir() || validateIntermediateName(entry.Name()) != nil {
+			continue
+		}
+		if !slices.ContainsFunc(intermediates, func(intermediate IntermediateOptions) bool { return intermediate.Name == entry.Name() }) {
+			intermediates = append(intermediates, options.NamedIntermediate(entry.Name()))
+		}
+	}
+	return intermediates
+}
+
+//Derives the paths of the files of the intermediate authority called name, the default one when empty. The default
+//intermediate authority is kept in output/intermediate_authority and a named one in output/intermediates/<name>,
+//with the same file names.
+func (ca *CA) initializeIntermediateStringFragments(name string, options AuthorityOptions) {
+	authority := intermediateAuthority(name)
+	directory := ca.fragments["outputDirectory"] + "/intermediate_authority"
+	publicationName := "intermediate"
+	if name != "" {
+		directory = ca.fragments["intermediatesDirectory"] + "/" + name
+		publicationName = "intermediates/" + name
+	}
+
+	ca.fragments[authority+"Name"] = name
+	//The name of its certificate and CRL on the publication server, without the .crt or .crl extension
+	ca.fragments[authority+"PublicationName"] = publicationName
+	ca.fragments[authority+"CommonName"] = options.CommonName
+	ca.fragments[authority+"ValidityDays"] = strconv.Itoa(options.ValidityDays)
+	ca.fragments[authority+"KeyAlgorithm"] = options.KeyAlgorithm
+	ca.fragments[authority+"KeyEncryption"] = options.KeyEncryption
+	ca.fragments[authority+"PermittedNames"] = strings.Join(options.PermittedNames, " ")
+	ca.fragments[authority+"CRLValidityDays"] = strconv.Itoa(options.CRLValidityDays)
+
+	ca.fragments[authority+"PrivateKeyFilename"] = "intermediate.pem"
+	ca.fragments[authority+"MakeInformationCSRConfigFilename"] = "make_intermediate_information_csr.conf"
+	ca.fragments[authority+"Directory"] = directory
+	ca.fragments[authority+"PrivateKey"] = directory + "/" + ca.fragments[authority+"PrivateKeyFilename"]
+	ca.fragments[authority+"MakeCertificateConfigurationFilename"] = "make_intermediate_certificate.conf"
+	ca.fragments[authority+"MakeCertificateConfiguration"] = directory + "/" + ca.fragments[authority+"MakeCertificateConfigurationFilename"]
+	ca.fragments[authority+"MakeInformationCSRConfig"] = directory + "/" + ca.fragments[authority+"MakeInformationCSRConfigFilename"]
+	ca.fragments[authority+"Database"] = directory + "/intermediate_database.txt"
+	ca.fragments[authority+"SerialNumber"] = directory + "/intermediate_serial_number.txt"
+	ca.fragments[authority+"CSR"] = directory + "/intermediate.csr"
+	ca.fragments[authority+"MakeInformationCSRConfigTemplate"] = ca.fragments["templatesDirectory"] + "/" + ca.fragments[authority+"MakeInformationCSRConfigFilename"]
+	ca.fragments[authority+"ConfigTemplate"] = ca.fragments["templatesDirectory"] + "/" + ca.fragments[authority+"MakeCertificateConfigurationFilename"]
+	ca.fragments[authority+"Certificate"] = directory + "/intermediate.crt"
+	ca.fragments[authority+"SigningCertificate"] = ca.fragments[authority+"Certificate"]
+	ca.fragments[authority+"KeyAlgorithmRecord"] = directory + "/intermediate_key_algorithm.txt"
+	ca.fragments[authority+"CRLConfiguration"] = directory + "/make_intermediate_crl.conf"
+	ca.fragments[authority+"CRLNumber"] = directory + "/intermediate_crl_number.txt"
+	ca.fragments[authority+"CRL"] = directory + "/intermediate_crl.pem"
+	ca.fragments[authority+"CRLDER"] = directory + "/intermediate_crl.der"
+}
+
+//Returns an error unless the intermediate authority called name, the default one when empty, exists
+func (ca *CA) checkIntermediate(name string) error {
+	certificate, known := ca.fragments[intermediateAuthority(name)+"Certificate"]
+	if !known {
+		return newError(InvalidError, "unknown intermediate authority %q, list it in the configuration file and run init", name)
+	}
+	if !fileExists(certificate) {
+		return newError(InvalidError, "%s does not exist, run init first", certificate)
+	}
+	return nil
+}
+
+//Returns the default intermediate authority followed by the named ones, whether or not Init has created them yet
+func (ca *CA) Intermediates() ([]Intermediate, error) {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	intermediates := []Intermediate{}
+	for _, authority := range ca.intermediateAuthorities() {
+		intermediate := Intermediate{
+			Name:        ca.fragments[authority+"Name"],
+			Directory:   ca.fragments[authority+"Directory"],
+			Certificate: ca.fragments[authority+"Certificate"],
+			CommonName:  ca.fragments[authority+"CommonName"],
+		}
+
+		if fileExists(intermediate.Certificate) {
+			certificate, err := readCertificate(intermediate.Certificate)
+			if err != nil {
+				return nil, err
+			}
+			intermediate.CommonName = certificate.Subject.CommonName
+			intermediate.Expires = certificate.NotAfter
+			intermediate.PermittedNames = slices.Clone(certificate.PermittedDNSDomains)
+			for _, network := range certificate.PermittedIPRanges {
+				intermediate.PermittedNames = append(intermediate.PermittedNames, network.String())
+			}
+
+			entries, err := readDatabase(ca.fragments[authority+"Database"])
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			intermediate.Issued = len(entries)
+		}
+		intermediates = append(intermediates, intermediate)
+	}
+	return intermediates, nil
+}
+
+//Points the intermediate authority fragments of the leaf at the intermediate authority that signs it. That is the one
+//recorded in the leaf's directory when it was issued, the default one when the leaf was issued before it could be
+//chosen, and requested otherwise.
+func (leaf *Leaf) selectIntermediate(requested string) {
+	leaf.Intermediate = requested
+	recorded, err := ioutil.ReadFile(leaf.intermediateRecord)
+	if err == nil {
+		leaf.Intermediate = strings.TrimSpace(string(recorded))
+	} else if fileExists(leaf.configuration) {
+		leaf.Intermediate = ""
+	}
+	leaf.requestedIntermediate = requested
+
+	authority := intermediateAuthority(leaf.Intermediate)
+	if _, known := leaf.ca.fragments[authority+"Certificate"]; !known || leaf.Intermediate == "" {
+		return
+	}
+	for key := range leaf.ca.fragments {
+		if suffix, found := strings.CutPrefix(key, "intermediateAuthority"); found {
+			leaf.fragments[key] = leaf.ca.fragments[authority+suffix]
+		}
+	}
+}
+
+//Records the name of the intermediate authority that signs the leaf in its directory, so that renew, revoke and export
+//find it again. Nothing is recorded for the default intermediate authority. A notice is printed when the leaf was
+//already issued by another intermediate authority than the one requested.
+func (leaf *Leaf) recordIntermediate() error {
+	if leaf.Intermediate != leaf.requestedIntermediate {
+		fmt.Fprintln(leaf.ca.log, "Keeping the intermediate authority "+leaf.fragments["intermediateAuthorityCertificate"]+" that "+leaf.Name+" was issued by. Delete "+leaf.Directory+" to issue it from another one.")
+	}
+	if leaf.Intermediate == "" || fileExists(leaf.intermediateRecord) {
+		return nil
+	}
+	return classify(ioutil.WriteFile(leaf.intermediateRecord, []byte(leaf.Intermediate+"\n"), 0644), IOError)
+}
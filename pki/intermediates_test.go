@@ -0,0 +1,117 @@
+package pki
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestValidateIntermediateName(t *testing.T) {
+	for _, name := range []string{"staging", "Team-A", "team_b", "v2.1", "a"} {
+		if err := validateIntermediateName(name); err != nil {
+			t.Errorf("validateIntermediateName(%q) returned the error %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "root", "intermediate", ".hidden", "-flag", "a/b", "a b", "ä", "a\\b"} {
+		if err := validateIntermediateName(name); err == nil {
+			t.Errorf("validateIntermediateName(%q) returned no error", name)
+		}
+	}
+}
+
+func TestFindIntermediates(t *testing.T) {
+	options := DefaultOptions()
+	options.OutputDirectory = t.TempDir()
+	staging := NewIntermediateOptions("staging", options.Intermediate)
+	staging.ValidityDays = 30
+	options.Intermediates = []IntermediateOptions{staging}
+	for _, directory := range []string{"staging", "legacy", ".hidden", "not valid"} {
+		err := os.MkdirAll(filepath.Join(options.OutputDirectory, "intermediates", directory), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.WriteFile(filepath.Join(options.OutputDirectory, "intermediates", "file"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	intermediates := findIntermediates(options)
+	names := []string{}
+	for _, intermediate := range intermediates {
+		names = append(names, intermediate.Name)
+	}
+	if !slices.Equal(names, []string{"staging", "legacy"}) {
+		t.Fatalf("findIntermediates returned %q, expected the listed staging followed by the unlisted legacy", names)
+	}
+	if intermediates[0].ValidityDays != 30 || intermediates[1].ValidityDays != options.Intermediate.ValidityDays || intermediates[1].CommonName != options.Intermediate.CommonName+" legacy" {
+		t.Errorf("findIntermediates returned %+v, expected legacy to have the settings of the default intermediate", intermediates)
+	}
+
+	//Without output/intermediates only the listed ones are found
+	options.OutputDirectory = t.TempDir()
+	if intermediates := findIntermediates(options); len(intermediates) != 1 || intermediates[0].Name != "staging" {
+		t.Errorf("findIntermediates without output/intermediates returned %+v", intermediates)
+	}
+}
+
+func TestSelectIntermediate(t *testing.T) {
+	ca := newTestCA(t, func(options *Options) {
+		staging := NewIntermediateOptions("staging", options.Intermediate)
+		options.Intermediates = []IntermediateOptions{staging}
+	})
+
+	//A new leaf is signed by the requested intermediate authority
+	server := newTestServer("staging.test")
+	server.Intermediate = "staging"
+	leaf, err := ca.IssueServer(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Intermediate != "staging" || leaf.fragments["intermediateAuthorityCertificate"] != ca.fragments["stagingIntermediateAuthorityCertificate"] ||
+		leaf.fragments["intermediateAuthorityDatabase"] != ca.fragments["stagingIntermediateAuthorityDatabase"] {
+		t.Errorf("a server requesting the staging intermediate selected %q, with the certificate %s", leaf.Intermediate, leaf.fragments["intermediateAuthorityCertificate"])
+	}
+	certificate, err := readCertificate(leaf.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certificate.Issuer.CommonName != ca.options.Intermediate.CommonName+" staging" {
+		t.Errorf("the server requesting the staging intermediate was issued by %s", certificate.Issuer.CommonName)
+	}
+
+	//Once issued, a leaf keeps the intermediate recorded in its directory, whatever is requested
+	server.Intermediate = ""
+	leaf = ca.Server(server)
+	if leaf.Intermediate != "staging" || leaf.requestedIntermediate != "" || leaf.fragments["intermediateAuthorityCertificate"] != ca.fragments["stagingIntermediateAuthorityCertificate"] {
+		t.Errorf("a server issued by the staging intermediate selected %q once issued", leaf.Intermediate)
+	}
+
+	//A leaf issued without a record was issued by the default intermediate
+	server = newTestServer("default.test")
+	_, err = ca.IssueServer(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(ca.Server(server).intermediateRecord) {
+		t.Errorf("a record of the default intermediate was written")
+	}
+	server.Intermediate = "staging"
+	leaf = ca.Server(server)
+	if leaf.Intermediate != "" || leaf.fragments["intermediateAuthorityCertificate"] != ca.fragments["intermediateAuthorityCertificate"] {
+		t.Errorf("a server issued by the default intermediate selected %q", leaf.Intermediate)
+	}
+
+	//An unknown intermediate is selected, and issuing fails
+	server = newTestServer("unknown.test")
+	server.Intermediate = "missing"
+	leaf = ca.Server(server)
+	if leaf.Intermediate != "missing" || leaf.fragments["intermediateAuthorityCertificate"] != ca.fragments["intermediateAuthorityCertificate"] {
+		t.Errorf("a server requesting an unknown intermediate selected %q", leaf.Intermediate)
+	}
+	_, err = ca.IssueServer(server)
+	if KindOf(err) != InvalidError {
+		t.Errorf("issuing a server from an unknown intermediate returned the error %v, expected an InvalidError", err)
+	}
+}
@@ -0,0 +1,190 @@
+package pki
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+//The names Kubernetes accepts for namespaces, which are DNS labels, and for Secrets and cert-manager issuers,
+//which are DNS subdomains
+var (
+	kubernetesLabel      = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	kubernetesSubdomain  = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	kubernetesDisallowed = regexp.MustCompile(`[^a-z0-9.-]+`)
+)
+
+//Returns an error unless namespace can name a Kubernetes namespace
+func validateKubernetesNamespace(namespace string) error {
+	if len(namespace) > 63 || !kubernetesLabel.MatchString(namespace) {
+		return fmt.Errorf("invalid Kubernetes namespace %q: it must be at most 63 lower case letters, digits and -, starting and ending with a letter or digit", namespace)
+	}
+	return nil
+}
+
+//Returns an error unless name can name a Kubernetes Secret or cert-manager issuer
+func validateKubernetesName(name string) error {
+	if len(name) > 253 || !kubernetesSubdomain.MatchString(name) {
+		return fmt.Errorf("invalid Kubernetes name %q: it must be at most 253 lower case letters, digits, - and ., starting and ending with a letter or digit", name)
+	}
+	return nil
+}
+
+//Turns name into a valid Kubernetes name by lower casing it and replacing the characters Kubernetes doesn't allow with -
+func kubernetesName(name string) string {
+	return strings.Trim(kubernetesDisallowed.ReplaceAllString(strings.ToLower(name), "-"), "-.")
+}
+
+//Checks the namespaces, issuer kind and names
+func (kubernetes KubernetesOptions) validate() error {
+	err := validateKubernetesNamespace(kubernetes.Namespace)
+	if err != nil {
+		return fmt.Errorf("kubernetes namespace: %w", err)
+	}
+
+	err = validateKubernetesNamespace(kubernetes.ClusterResourceNamespace)
+	if err != nil {
+		return fmt.Errorf("kubernetes cluster_resource_namespace: %w", err)
+	}
+
+	if kubernetes.IssuerKind != "Issuer" && kubernetes.IssuerKind != "ClusterIssuer" {
+		return fmt.Errorf("unknown kubernetes issuer_kind %q, expected Issuer or ClusterIssuer", kubernetes.IssuerKind)
+	}
+
+	for _, name := range []string{kubernetes.IssuerName, kubernetes.CASecretName} {
+		if name == "" {
+			continue
+		}
+		err = validateKubernetesName(name)
+		if err != nil {
+			return fmt.Errorf("kubernetes: %w", err)
+		}
+	}
+	return nil
+}
+
+//A key of the data of a Secret and its contents
+type secretEntry struct {
+	key      string
+	contents []byte
+}
+
+//Renders a kubernetes.io/tls Secret holding data
+func kubernetesTLSSecret(name, namespace string, data []secretEntry) []byte {
+	var secret strings.Builder
+	fmt.Fprintf(&secret, "apiVersion: v1\nkind: Secret\nmetadata:\n  name: %s\n  namespace: %s\n  labels:\n    app.kubernetes.io/managed-by: generate-certificates\ntype: kubernetes.io/tls\ndata:\n", name, namespace)
+	for _, entry := range data {
+		fmt.Fprintf(&secret, "  %s: %s\n", entry.key, base64.StdEncoding.EncodeToString(entry.contents))
+	}
+	return []byte(secret.String())
+}
+
+//Returns a kubernetes.io/tls Secret called name in namespace holding the leaf's key as tls.key, Chain as tls.crt and the
+//root certificate as ca.crt, for an Ingress or a pod serving TLS. name defaults to the leaf's name followed by -tls,
+//and namespace to the Namespace of Options.Kubernetes.
+func (leaf *Leaf) KubernetesSecret(name, namespace string) ([]byte, error) {
+	err := leaf.checkIssued()
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		name = kubernetesName(leaf.Name) + "-tls"
+	}
+	if namespace == "" {
+		namespace = leaf.ca.options.Kubernetes.Namespace
+	}
+	err = validateKubernetesName(name)
+	if err == nil {
+		err = validateKubernetesNamespace(namespace)
+	}
+	if err != nil {
+		return nil, classify(err, InvalidError)
+	}
+
+	data := []secretEntry{}
+	for _, file := range []struct{ key, filename string }{{"tls.crt", leaf.Chain}, {"tls.key", leaf.PrivateKey}, {"ca.crt", leaf.ca.fragments["rootAuthorityCertificate"]}} {
+		contents, err := ioutil.ReadFile(file.filename)
+		if err != nil {
+			return nil, classify(err, IOError)
+		}
+		data = append(data, secretEntry{file.key, contents})
+	}
+	fmt.Fprintln(leaf.ca.log, "Generating Kubernetes Secret "+namespace+"/"+name+" for "+leaf.Name)
+	return kubernetesTLSSecret(name, namespace, data), nil
+}
+
+//Returns the manifests that let cert-manager issue certificates from the intermediate authority called intermediate,
+//the default one when empty: a kubernetes.io/tls Secret holding the authority's key and its certificate followed by the
+//root's, and a CA Issuer or ClusterIssuer reading it, as set by kubernetes. cert-manager can't unlock an encrypted key,
+//so the key is written unencrypted. The issuer gives the certificates it signs the same CRL URL as those issued here,
+//but no OCSP URL, since they aren't recorded in the authority's database and the responder would answer unknown.
+func (ca *CA) KubernetesIssuer(intermediate string, kubernetes KubernetesOptions) ([]byte, error) {
+	err := kubernetes.validate()
+	if err != nil {
+		return nil, classify(err, InvalidError)
+	}
+
+	err = ca.checkIntermediate(intermediate)
+	if err != nil {
+		return nil, err
+	}
+
+	if kubernetes.IssuerName == "" {
+		kubernetes.IssuerName = "generate-certificates"
+		if intermediate != "" {
+			kubernetes.IssuerName += "-" + kubernetesName(intermediate)
+		}
+	}
+	if kubernetes.CASecretName == "" {
+		kubernetes.CASecretName = kubernetes.IssuerName + "-ca"
+	}
+	secretNamespace := kubernetes.Namespace
+	if kubernetes.IssuerKind == "ClusterIssuer" {
+		secretNamespace = kubernetes.ClusterResourceNamespace
+	}
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	authority := intermediateAuthority(intermediate)
+	key, err := readPrivateKey(ca.fragments[authority+"PrivateKey"], ca.keyPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, newError(SigningError, "encoding %s: %w", ca.fragments[authority+"PrivateKey"], err)
+	}
+
+	certificate, err := ioutil.ReadFile(ca.fragments[authority+"Certificate"])
+	if err != nil {
+		return nil, classify(err, IOError)
+	}
+	root, err := ioutil.ReadFile(ca.fragments["rootAuthorityCertificate"])
+	if err != nil {
+		return nil, classify(err, IOError)
+	}
+
+	fmt.Fprintln(ca.log, "Generating cert-manager "+kubernetes.IssuerKind+" "+kubernetes.IssuerName+" with the Secret "+secretNamespace+"/"+kubernetes.CASecretName+" for "+ca.fragments[authority+"Certificate"])
+	manifests := kubernetesTLSSecret(kubernetes.CASecretName, secretNamespace, []secretEntry{
+		{"tls.crt", append(append([]byte{}, certificate...), root...)},
+		{"tls.key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})},
+		{"ca.crt", root},
+	})
+
+	var issuer strings.Builder
+	fmt.Fprintf(&issuer, "---\napiVersion: cert-manager.io/v1\nkind: %s\nmetadata:\n  name: %s\n", kubernetes.IssuerKind, kubernetes.IssuerName)
+	if kubernetes.IssuerKind == "Issuer" {
+		fmt.Fprintf(&issuer, "  namespace: %s\n", kubernetes.Namespace)
+	}
+	fmt.Fprintf(&issuer, "spec:\n  ca:\n    secretName: %s\n", kubernetes.CASecretName)
+	if ca.fragments["publishAddToCertificates"] == "true" {
+		fmt.Fprintf(&issuer, "    crlDistributionPoints:\n      - %s/%s.crl\n", ca.fragments["publishURL"], ca.fragments[authority+"PublicationName"])
+	}
+	return append(manifests, issuer.String()...), nil
+}
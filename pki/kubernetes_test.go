@@ -0,0 +1,245 @@
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidateKubernetesNames(t *testing.T) {
+	for _, namespace := range []string{"default", "cert-manager", "a", "team1", strings.Repeat("a", 63)} {
+		if err := validateKubernetesNamespace(namespace); err != nil {
+			t.Errorf("validateKubernetesNamespace(%q) returned the error %v", namespace, err)
+		}
+	}
+	for _, namespace := range []string{"", "Default", "-a", "a-", "a.b", "a_b", strings.Repeat("a", 64)} {
+		if err := validateKubernetesNamespace(namespace); err == nil {
+			t.Errorf("validateKubernetesNamespace(%q) returned no error", namespace)
+		}
+	}
+
+	label := strings.Repeat("a", 63)
+	for _, name := range []string{"example-tls", "example.test-tls", "a", "1.2.3", label + "." + label + "." + label + "." + strings.Repeat("a", 61)} {
+		if err := validateKubernetesName(name); err != nil {
+			t.Errorf("validateKubernetesName(%q) returned the error %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "Example", ".a", "a.", "a..b", "a.-b", "a_b", "*.example", label + "." + label + "." + label + "." + strings.Repeat("a", 62)} {
+		if err := validateKubernetesName(name); err == nil {
+			t.Errorf("validateKubernetesName(%q) returned no error", name)
+		}
+	}
+}
+
+func TestKubernetesName(t *testing.T) {
+	tests := map[string]string{
+		"example.test":   "example.test",
+		"Example.Test":   "example.test",
+		"*.example.test": "example.test",
+		"alice smith":    "alice-smith",
+		"app_1":          "app-1",
+		"team/a":         "team-a",
+		"-staging-":      "staging",
+	}
+	for name, expected := range tests {
+		if converted := kubernetesName(name); converted != expected {
+			t.Errorf("kubernetesName(%q) returned %q, expected %q", name, converted, expected)
+		}
+		if err := validateKubernetesName(kubernetesName(name)); err != nil {
+			t.Errorf("kubernetesName(%q) returned an invalid name: %v", name, err)
+		}
+	}
+}
+
+func TestKubernetesTLSSecret(t *testing.T) {
+	secret := kubernetesTLSSecret("example-tls", "web", []secretEntry{{"tls.crt", []byte("certificate")}, {"tls.key", []byte("key")}})
+	expected := `apiVersion: v1
+kind: Secret
+metadata:
+  name: example-tls
+  namespace: web
+  labels:
+    app.kubernetes.io/managed-by: generate-certificates
+type: kubernetes.io/tls
+data:
+  tls.crt: Y2VydGlmaWNhdGU=
+  tls.key: a2V5
+`
+	if string(secret) != expected {
+		t.Errorf("kubernetesTLSSecret returned\n%s\nexpected\n%s", secret, expected)
+	}
+}
+
+//Splits the manifests rendered by KubernetesSecret and KubernetesIssuer into their documents, with the values of the
+//data of Secrets replaced by their key, and the decoded data
+func readKubernetesTestManifests(t *testing.T, manifests []byte) ([]string, map[string][]byte) {
+	t.Helper()
+	documents := []string{}
+	data := map[string][]byte{}
+	for _, document := range strings.Split(string(manifests), "---\n") {
+		inData := false
+		var lines strings.Builder
+		for _, line := range strings.SplitAfter(document, "\n") {
+			if inData && strings.HasPrefix(line, "  ") {
+				key, value, _ := strings.Cut(strings.TrimSpace(line), ": ")
+				decoded, err := base64.StdEncoding.DecodeString(value)
+				if err != nil {
+					t.Fatalf("the Secret holds %s with the invalid base64 %q", key, value)
+				}
+				data[key] = decoded
+				line = "  " + key + "\n"
+			}
+			inData = inData && strings.HasPrefix(line, "  ") || line == "data:\n"
+			lines.WriteString(line)
+		}
+		documents = append(documents, lines.String())
+	}
+	return documents, data
+}
+
+func TestKubernetesSecret(t *testing.T) {
+	ca := newTestCA(t, func(options *Options) { options.Kubernetes.Namespace = "web" })
+	leaf, err := ca.IssueServer(newTestServer("Example.test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifests, err := leaf.KubernetesSecret("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	documents, data := readKubernetesTestManifests(t, manifests)
+	expected := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: example.test-tls\n  namespace: web\n  labels:\n    app.kubernetes.io/managed-by: generate-certificates\ntype: kubernetes.io/tls\ndata:\n  tls.crt\n  tls.key\n  ca.crt\n"
+	if len(documents) != 1 || documents[0] != expected {
+		t.Errorf("KubernetesSecret returned\n%s\nexpected\n%s", strings.Join(documents, "---\n"), expected)
+	}
+	for key, filename := range map[string]string{"tls.crt": leaf.Chain, "tls.key": leaf.PrivateKey, "ca.crt": ca.RootCertificate()} {
+		contents, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data[key], contents) {
+			t.Errorf("the Secret's %s doesn't hold %s", key, filename)
+		}
+	}
+
+	manifests, err = leaf.KubernetesSecret("custom", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(manifests), "  name: custom\n  namespace: other\n") {
+		t.Errorf("KubernetesSecret with a name and namespace returned\n%s", manifests)
+	}
+
+	for _, arguments := range [][2]string{{"Custom", ""}, {"", "a.b"}} {
+		_, err = leaf.KubernetesSecret(arguments[0], arguments[1])
+		if KindOf(err) != InvalidError {
+			t.Errorf("KubernetesSecret(%q, %q) returned the error %v, expected an InvalidError", arguments[0], arguments[1], err)
+		}
+	}
+	_, err = ca.Server(newTestServer("missing.test")).KubernetesSecret("", "")
+	if KindOf(err) != InvalidError {
+		t.Errorf("KubernetesSecret of a server that wasn't issued returned the error %v, expected an InvalidError", err)
+	}
+}
+
+func TestKubernetesIssuer(t *testing.T) {
+	ca := newTestCA(t, func(options *Options) {
+		options.Publish.URL = "http://pki.example.test/"
+		options.Publish.AddToCertificates = true
+		options.Intermediates = []IntermediateOptions{NewIntermediateOptions("Team_A", options.Intermediate)}
+	})
+	intermediatePEM, err := os.ReadFile(ca.IntermediateCertificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootPEM, err := os.ReadFile(ca.RootCertificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifests, err := ca.KubernetesIssuer("", ca.options.Kubernetes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	documents, data := readKubernetesTestManifests(t, manifests)
+	expected := []string{
+		"apiVersion: v1\nkind: Secret\nmetadata:\n  name: generate-certificates-ca\n  namespace: default\n  labels:\n    app.kubernetes.io/managed-by: generate-certificates\ntype: kubernetes.io/tls\ndata:\n  tls.crt\n  tls.key\n  ca.crt\n",
+		"apiVersion: cert-manager.io/v1\nkind: Issuer\nmetadata:\n  name: generate-certificates\n  namespace: default\nspec:\n  ca:\n    secretName: generate-certificates-ca\n    crlDistributionPoints:\n      - http://pki.example.test/intermediate.crl\n",
+	}
+	if len(documents) != 2 || documents[0] != expected[0] || documents[1] != expected[1] {
+		t.Errorf("KubernetesIssuer returned\n%s\nexpected\n%s", strings.Join(documents, "---\n"), strings.Join(expected, "---\n"))
+	}
+	if !bytes.Equal(data["tls.crt"], append(append([]byte{}, intermediatePEM...), rootPEM...)) || !bytes.Equal(data["ca.crt"], rootPEM) {
+		t.Errorf("the issuer's Secret doesn't hold the intermediate and root certificates")
+	}
+
+	//cert-manager reads an unencrypted PKCS#8 key, which is the intermediate's
+	block, _ := pem.Decode(data["tls.key"])
+	if block == nil || block.Type != "PRIVATE KEY" {
+		t.Fatalf("the issuer's Secret holds the key\n%s", data["tls.key"])
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediate, err := readCertificate(ca.IntermediateCertificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !samePublicKey(intermediate.PublicKey, key.(crypto.Signer).Public()) {
+		t.Errorf("the issuer's Secret doesn't hold the intermediate's key")
+	}
+
+	//A ClusterIssuer reads its Secret from the cluster resource namespace, and has no namespace itself
+	kubernetes := ca.options.Kubernetes
+	kubernetes.IssuerKind = "ClusterIssuer"
+	manifests, err = ca.KubernetesIssuer("Team_A", kubernetes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	documents, _ = readKubernetesTestManifests(t, manifests)
+	expected = []string{
+		"apiVersion: v1\nkind: Secret\nmetadata:\n  name: generate-certificates-team-a-ca\n  namespace: cert-manager\n  labels:\n    app.kubernetes.io/managed-by: generate-certificates\ntype: kubernetes.io/tls\ndata:\n  tls.crt\n  tls.key\n  ca.crt\n",
+		"apiVersion: cert-manager.io/v1\nkind: ClusterIssuer\nmetadata:\n  name: generate-certificates-team-a\nspec:\n  ca:\n    secretName: generate-certificates-team-a-ca\n    crlDistributionPoints:\n      - http://pki.example.test/intermediates/Team_A.crl\n",
+	}
+	if len(documents) != 2 || documents[0] != expected[0] || documents[1] != expected[1] {
+		t.Errorf("KubernetesIssuer of a ClusterIssuer returned\n%s\nexpected\n%s", strings.Join(documents, "---\n"), strings.Join(expected, "---\n"))
+	}
+
+	//Without publishing, the issuer names no CRL, and it never names the OCSP responder
+	ca.fragments["publishAddToCertificates"] = "false"
+	kubernetes = KubernetesOptions{Namespace: "web", IssuerKind: "Issuer", IssuerName: "internal", CASecretName: "internal-secret", ClusterResourceNamespace: "cert-manager"}
+	manifests, err = ca.KubernetesIssuer("", kubernetes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	documents, _ = readKubernetesTestManifests(t, manifests)
+	if len(documents) != 2 || documents[1] != "apiVersion: cert-manager.io/v1\nkind: Issuer\nmetadata:\n  name: internal\n  namespace: web\nspec:\n  ca:\n    secretName: internal-secret\n" ||
+		!strings.Contains(documents[0], "  name: internal-secret\n  namespace: web\n") {
+		t.Errorf("KubernetesIssuer without publishing returned\n%s", manifests)
+	}
+	if strings.Contains(strings.ToLower(string(manifests)), "ocsp") {
+		t.Errorf("KubernetesIssuer named the OCSP responder")
+	}
+
+	for _, kubernetes := range []KubernetesOptions{
+		{Namespace: "web", IssuerKind: "Certificate", ClusterResourceNamespace: "cert-manager"},
+		{Namespace: "Web", IssuerKind: "Issuer", ClusterResourceNamespace: "cert-manager"},
+		{Namespace: "web", IssuerKind: "Issuer", IssuerName: "Internal", ClusterResourceNamespace: "cert-manager"},
+	} {
+		_, err = ca.KubernetesIssuer("", kubernetes)
+		if KindOf(err) != InvalidError {
+			t.Errorf("KubernetesIssuer with %+v returned the error %v, expected an InvalidError", kubernetes, err)
+		}
+	}
+	_, err = ca.KubernetesIssuer("missing", ca.options.Kubernetes)
+	if KindOf(err) != InvalidError {
+		t.Errorf("KubernetesIssuer of an unknown intermediate returned the error %v, expected an InvalidError", err)
+	}
+}
@@ -0,0 +1,676 @@
+package pki
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//A Leaf is a server or client certificate of a CA and the files it is made from, whether or not it has been issued yet.
+//Kind is server or client. Name names the directory holding its files: the domain name of a server, unless it was given
+//another directory, or the name of a client.
+//Intermediate is the name of the intermediate authority that signs it, empty for the default one.
+type Leaf struct {
+	Kind         string
+	Name         string
+	Intermediate string
+	Directory    string
+	PrivateKey   string
+	Certificate  string
+	//server_bundle.crt, the server certificate followed by the certificates of the intermediate authority that signed it
+	//and of the root, or client_chain.crt, the client certificate followed by the intermediate authority's certificate
+	Chain string
+	//server.p12 or client.p12, written by ExportPKCS12
+	PKCS12 string
+
+	ca *CA
+	//The names placed in the certificate's subjectAltName extension
+	names []string
+	//The CA's string fragments along with the paths of this leaf's files
+	fragments map[string]string
+
+	keyAlgorithm       string
+	keyAlgorithmRecord string
+	//The file recording the encryption and friendly name PKCS12 was exported with, which it is rebuilt with on renewal
+	pkcs12Record              string
+	certificateSigningRequest string
+	requestConfiguration      string
+	configuration             string
+	//The file naming the intermediate authority the leaf was issued by, and the one the options asked for
+	intermediateRecord    string
+	requestedIntermediate string
+	//Set once the certificate has been signed, rather than an existing one kept
+	signed bool
+}
+
+//Returns the server certificate described by server, which is kept in output/<domain>, or output/<directory> when it has one
+func (ca *CA) Server(server ServerOptions) *Leaf {
+	leaf := &Leaf{ca: ca, fragments: maps.Clone(ca.fragments)}
+	leaf.initializeServerStringFragments(server)
+	leaf.Kind = "server"
+	leaf.Name = server.DirectoryName()
+	leaf.Directory = leaf.fragments["domainNameDirectory"]
+	leaf.PrivateKey = leaf.fragments["serverPrivateKey"]
+	leaf.Certificate = leaf.fragments["serverCertificate"]
+	leaf.Chain = leaf.fragments["serverBundleCertificate"]
+	leaf.PKCS12 = leaf.fragments["serverPKCS12"]
+	leaf.keyAlgorithm = leaf.fragments["serverKeyAlgorithm"]
+	leaf.keyAlgorithmRecord = leaf.fragments["serverKeyAlgorithmRecord"]
+	leaf.pkcs12Record = leaf.fragments["serverPKCS12Record"]
+	leaf.certificateSigningRequest = leaf.fragments["serverCSR"]
+	leaf.requestConfiguration = leaf.fragments["serverCSRConfig"]
+	leaf.configuration = leaf.fragments["serverConfig"]
+	leaf.intermediateRecord = leaf.fragments["serverIntermediateRecord"]
+	leaf.selectIntermediate(server.Intermediate)
+	return leaf
+}
+
+//Returns the client certificate described by client, which is kept in output/clients/<name>
+func (ca *CA) Client(client ClientOptions) *Leaf {
+	leaf := &Leaf{ca: ca, fragments: maps.Clone(ca.fragments), names: client.Names}
+	leaf.initializeClientStringFragments(client)
+	leaf.Kind = "client"
+	leaf.Name = client.Name
+	leaf.Directory = leaf.fragments["clientDirectory"]
+	leaf.PrivateKey = leaf.fragments["clientPrivateKey"]
+	leaf.Certificate = leaf.fragments["clientCertificate"]
+	leaf.Chain = leaf.fragments["clientChainCertificate"]
+	leaf.PKCS12 = leaf.fragments["clientPKCS12"]
+	leaf.keyAlgorithm = leaf.fragments["clientKeyAlgorithm"]
+	leaf.keyAlgorithmRecord = leaf.fragments["clientKeyAlgorithmRecord"]
+	leaf.pkcs12Record = leaf.fragments["clientPKCS12Record"]
+	leaf.certificateSigningRequest = leaf.fragments["clientCSR"]
+	leaf.requestConfiguration = leaf.fragments["clientCSRConfig"]
+	leaf.configuration = leaf.fragments["clientConfig"]
+	leaf.intermediateRecord = leaf.fragments["clientIntermediateRecord"]
+	leaf.selectIntermediate(client.Intermediate)
+	return leaf
+}
+
+//Derives the paths of the files of one server
+func (leaf *Leaf) initializeServerStringFragments(server ServerOptions) {
+	leaf.fragments["domainName"] = server.Domain
+	leaf.fragments["domainNameDirectory"] = leaf.fragments["outputDirectory"] + "/" + server.DirectoryName()
+	leaf.fragments["serverValidityDays"] = strconv.Itoa(server.ValidityDays)
+	leaf.fragments["serverKeyAlgorithm"] = server.KeyAlgorithm
+	leaf.names = server.SubjectAlternativeNames()
+
+	leaf.fragments["serverPrivateKey"] = leaf.fragments["domainNameDirectory"] + "/" + leaf.fragments["serverPrivateKeyFilename"]
+	leaf.fragments["serverCSR"] = leaf.fragments["domainNameDirectory"] + "/server.csr"
+	leaf.fragments["serverCSRConfigFilename"] = "make_server_information_csr.conf"
+	leaf.fragments["serverCSRConfig"] = leaf.fragments["domainNameDirectory"] + "/" + leaf.fragments["serverCSRConfigFilename"]
+	leaf.fragments["serverCSRConfigTemplate"] = leaf.fragments["templatesDirectory"] + "/" + leaf.fragments["serverCSRConfigFilename"]
+
+	leaf.fragments["serverConfigFilename"] = "make_server_certificate.conf"
+	leaf.fragments["serverConfig"] = leaf.fragments["domainNameDirectory"] + "/" + leaf.fragments["serverConfigFilename"]
+	leaf.fragments["serverConfigTemplate"] = leaf.fragments["templatesDirectory"] + "/" + leaf.fragments["serverConfigFilename"]
+	leaf.fragments["serverCertificateFilename"] = "server.crt"
+	leaf.fragments["serverCertificate"] = leaf.fragments["domainNameDirectory"] + "/" + leaf.fragments["serverCertificateFilename"]
+	leaf.fragments["serverBundleCertificate"] = leaf.fragments["domainNameDirectory"] + "/server_bundle.crt"
+	leaf.fragments["serverKeyAlgorithmRecord"] = leaf.fragments["domainNameDirectory"] + "/server_key_algorithm.txt"
+	leaf.fragments["serverPKCS12"] = leaf.fragments["domainNameDirectory"] + "/server.p12"
+	leaf.fragments["serverPKCS12Record"] = leaf.fragments["domainNameDirectory"] + "/server_p12.txt"
+	leaf.fragments["serverIntermediateRecord"] = leaf.fragments["domainNameDirectory"] + "/intermediate.txt"
+}
+
+//Derives the paths of the files of one client
+func (leaf *Leaf) initializeClientStringFragments(client ClientOptions) {
+	leaf.fragments["clientName"] = client.Name
+	leaf.fragments["clientDirectory"] = leaf.fragments["clientsDirectory"] + "/" + client.Name
+	leaf.fragments["clientValidityDays"] = strconv.Itoa(client.ValidityDays)
+	leaf.fragments["clientKeyAlgorithm"] = client.KeyAlgorithm
+
+	leaf.fragments["clientPrivateKey"] = leaf.fragments["clientDirectory"] + "/client.pem"
+	leaf.fragments["clientKeyAlgorithmRecord"] = leaf.fragments["clientDirectory"] + "/client_key_algorithm.txt"
+	leaf.fragments["clientCSR"] = leaf.fragments["clientDirectory"] + "/client.csr"
+	leaf.fragments["clientCSRConfig"] = leaf.fragments["clientDirectory"] + "/make_client_information_csr.conf"
+	leaf.fragments["clientCSRConfigTemplate"] = leaf.fragments["templatesDirectory"] + "/make_client_information_csr.conf"
+	leaf.fragments["clientConfig"] = leaf.fragments["clientDirectory"] + "/make_client_certificate.conf"
+	leaf.fragments["clientConfigTemplate"] = leaf.fragments["templatesDirectory"] + "/make_client_certificate.conf"
+	leaf.fragments["clientCertificate"] = leaf.fragments["clientDirectory"] + "/client.crt"
+	leaf.fragments["clientChainCertificate"] = leaf.fragments["clientDirectory"] + "/client_chain.crt"
+	leaf.fragments["clientPKCS12"] = leaf.fragments["clientDirectory"] + "/client.p12"
+	leaf.fragments["clientPKCS12Record"] = leaf.fragments["clientDirectory"] + "/client_p12.txt"
+	leaf.fragments["clientIntermediateRecord"] = leaf.fragments["clientDirectory"] + "/intermediate.txt"
+}
+
+//Issues the server certificate described by server into output/<domain>, along with its key and server_bundle.crt,
+//from the intermediate authority it names. Its names are checked against the name constraints of the authorities
+//first. Existing keys and certificates are kept, along with the intermediate authority that issued them. The key and
+//CSR are generated while other certificates are being signed, and only the signing itself takes turns.
+func (ca *CA) IssueServer(server ServerOptions) (*Leaf, error) {
+	err := server.validate()
+	if err != nil {
+		return nil, classify(err, InvalidError)
+	}
+
+	err = ca.CheckAuthorities()
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := ca.Server(server)
+	err = ca.checkIntermediate(leaf.Intermediate)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ca.CheckNameConstraints(leaf.Intermediate, leaf.names)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := ca.lockLeaf(leaf.Directory)
+	defer unlock()
+	err = leaf.makeServerDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	err = leaf.recordIntermediate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = leaf.makeServerPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	err = leaf.makeServerCertificateSigningRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	err = leaf.makeServerCertificate()
+	if err != nil {
+		return nil, err
+	}
+	return leaf, leaf.makeServerCertificateBundle()
+}
+
+//Issues the client certificate described by client into output/clients/<name>, along with its key, client_chain.crt
+//and client.p12, protected by password with encryption, modern or legacy, from the intermediate authority it names.
+//Existing keys and certificates are kept, along with the intermediate authority that issued them. As for servers,
+//only the signing takes turns.
+func (ca *CA) IssueClient(client ClientOptions, password, encryption string) (*Leaf, error) {
+	err := client.validate()
+	if err != nil {
+		return nil, classify(err, InvalidError)
+	}
+
+	//Checked before anything is generated, since every client gets a client.p12
+	if !slices.Contains(PKCS12Encryptions, encryption) {
+		return nil, newError(InvalidError, "unknown encryption %q, expected one of %s", encryption, strings.Join(PKCS12Encryptions, ", "))
+	}
+	if password == "" {
+		return nil, newError(InvalidError, "a password for client.p12 is required")
+	}
+
+	err = ca.CheckAuthorities()
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := ca.Client(client)
+	err = ca.checkIntermediate(leaf.Intermediate)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ca.CheckNameConstraints(leaf.Intermediate, client.ConstrainedNames())
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := ca.lockLeaf(leaf.Directory)
+	defer unlock()
+	err = leaf.makeClientDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	err = leaf.recordIntermediate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = leaf.makeClientCertificateSigningRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	ca.mutex.Lock()
+	err = leaf.makeClientCertificate()
+	if err == nil {
+		err = leaf.makeClientCertificateChain()
+	}
+	ca.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return leaf, leaf.ExportPKCS12("", password, encryption)
+}
+
+//Ensures a directory named after the server's domain name exists in the output directory
+func (leaf *Leaf) makeServerDirectory() error {
+	if !fileExists(leaf.fragments["domainNameDirectory"]) {
+		fmt.Fprintln(leaf.ca.log, "Generating directory: "+leaf.fragments["domainNameDirectory"])
+		return classify(os.Mkdir(leaf.fragments["domainNameDirectory"], 0700), IOError)
+	}
+	return nil
+}
+
+//Generates the private key of the server
+func (leaf *Leaf) makeServerPrivateKey() error {
+	//4)Generate a server private key
+	fmt.Fprintln(leaf.ca.log, "Server private key: "+leaf.fragments["serverPrivateKey"])
+	return leaf.ca.makePrivateKey(leaf.fragments["serverPrivateKey"], leaf.fragments["serverKeyAlgorithm"], "none", leaf.fragments["serverKeyAlgorithmRecord"])
+}
+
+//Generates the CSR of the server and the configuration it is signed with. Only the leaf's own files are written,
+//so the caller doesn't need to hold the mutex.
+func (leaf *Leaf) makeServerCertificateSigningRequest() error {
+	ca := leaf.ca
+	fmt.Fprintln(ca.log, "serverCSR:", leaf.fragments["serverCSR"])
+	fmt.Fprintln(ca.log, "serverCSRConfig:", leaf.fragments["serverCSRConfig"])
+	fmt.Fprintln(ca.log, "serverCSRConfigTemplate:", leaf.fragments["serverCSRConfigTemplate"])
+
+	if !fileExists(leaf.fragments["serverCSRConfig"]) {
+		err := ca.hydrateTemplate(leaf.fragments["serverCSRConfigTemplate"], leaf.fragments["serverCSRConfig"], leaf.fragments["domainName"])
+		if err != nil {
+			return err
+		}
+	}
+
+	if !fileExists(leaf.fragments["serverConfig"]) {
+		fmt.Fprintln(ca.log, leaf.fragments["serverConfigTemplate"])
+		fmt.Fprintln(ca.log, leaf.fragments["serverConfig"])
+		err := ca.hydrateTemplate(leaf.fragments["serverConfigTemplate"], leaf.fragments["serverConfig"], leaf.fragments["intermediateAuthorityDatabase"], leaf.fragments["intermediateAuthoritySerialNumber"], leaf.fragments["serverValidityDays"], serverKeyUsage(recordedKeyAlgorithm(leaf.fragments["serverKeyAlgorithmRecord"], leaf.fragments["serverKeyAlgorithm"])), ca.serverExtensionLines(intermediateAuthority(leaf.Intermediate)), formatSubjectAlternativeNames(leaf.names))
+		if err != nil {
+			return err
+		}
+	} else {
+		leaf.warnIfSubjectAlternativeNamesChanged()
+	}
+
+	if !fileExists(leaf.fragments["serverCSR"]) {
+		fmt.Fprintln(ca.log, "Generating server CSR")
+		return ca.generateCertificateSigningRequest(leaf.fragments["serverPrivateKey"], leaf.fragments["serverCSR"], leaf.fragments["serverCSRConfig"])
+	}
+	return nil
+}
+
+//Signs the server certificate with its intermediate authority unless it already exists. The caller holds the mutex,
+//since signing updates the authority's database and serial number file.
+func (leaf *Leaf) makeServerCertificate() error {
+	ca := leaf.ca
+	if !fileExists(leaf.fragments["serverCertificate"]) {
+		fmt.Fprintln(ca.log, "Generating server certificate")
+		fmt.Fprintln(ca.log, leaf.fragments["serverCSR"])
+		fmt.Fprintln(ca.log, leaf.fragments["serverCertificateFilename"])
+		fmt.Fprintln(ca.log, leaf.fragments["serverConfig"])
+		fmt.Fprintln(ca.log, leaf.fragments["intermediateAuthorityPrivateKey"])
+		fmt.Fprintln(ca.log, leaf.fragments["intermediateAuthorityCertificate"])
+		fmt.Fprintln(ca.log, leaf.fragments["domainNameDirectory"])
+
+		err := ca.generateSignedCertificate(leaf.fragments["serverCSR"], leaf.fragments["serverCertificate"], leaf.fragments["serverConfig"], leaf.fragments["intermediateAuthorityPrivateKey"], leaf.fragments["intermediateAuthorityCertificate"], leaf.fragments["domainNameDirectory"])
+		leaf.signed = err == nil
+		return err
+	}
+	return nil
+}
+
+//Prints a notice when the names in an existing server configuration are not the ones requested,
+//because the existing configuration and certificate are kept until they are deleted
+func (leaf *Leaf) warnIfSubjectAlternativeNamesChanged() {
+	conf, err := readOpensslConfiguration(leaf.fragments["serverConfig"])
+	if err != nil {
+		fmt.Fprintln(leaf.ca.log, "Error reading "+leaf.fragments["serverConfig"])
+		fmt.Fprintln(leaf.ca.log, err)
+		return
+	}
+
+	configuredNames := []string{}
+	for _, entry := range conf["altNames"] {
+		configuredNames = append(configuredNames, entry.value)
+	}
+
+	if strings.Join(configuredNames, " ") != strings.Join(leaf.names, " ") {
+		fmt.Fprintln(leaf.ca.log, "The existing "+leaf.fragments["serverConfig"]+" covers "+strings.Join(configuredNames, ", ")+" instead of "+strings.Join(leaf.names, ", ")+".")
+		fmt.Fprintln(leaf.ca.log, "Delete it and "+leaf.fragments["serverCertificate"]+" to issue a certificate for the new names.")
+	}
+}
+
+//Ensures the directory of the client exists in output/clients
+func (leaf *Leaf) makeClientDirectory() error {
+	if !fileExists(leaf.fragments["clientDirectory"]) {
+		fmt.Fprintln(leaf.ca.log, "Generating directory: "+leaf.fragments["clientDirectory"])
+		return classify(os.MkdirAll(leaf.fragments["clientDirectory"], 0700), IOError)
+	}
+	return nil
+}
+
+//Generates the key and CSR of the client and the configuration it is signed with. As with servers, existing files are kept.
+//Only the leaf's own files are written, so the caller doesn't need to hold the mutex.
+func (leaf *Leaf) makeClientCertificateSigningRequest() error {
+	ca := leaf.ca
+	fmt.Fprintln(ca.log, "Client private key: "+leaf.fragments["clientPrivateKey"])
+	err := ca.makePrivateKey(leaf.fragments["clientPrivateKey"], leaf.fragments["clientKeyAlgorithm"], "none", leaf.fragments["clientKeyAlgorithmRecord"])
+	if err != nil {
+		return err
+	}
+
+	if !fileExists(leaf.fragments["clientCSRConfig"]) {
+		err = ca.hydrateTemplate(leaf.fragments["clientCSRConfigTemplate"], leaf.fragments["clientCSRConfig"], leaf.fragments["clientName"])
+		if err != nil {
+			return err
+		}
+	}
+
+	if !fileExists(leaf.fragments["clientConfig"]) {
+		err = ca.hydrateTemplate(leaf.fragments["clientConfigTemplate"], leaf.fragments["clientConfig"], leaf.fragments["intermediateAuthorityDatabase"], leaf.fragments["intermediateAuthoritySerialNumber"], leaf.fragments["clientValidityDays"], ca.clientExtensionLines(intermediateAuthority(leaf.Intermediate), leaf.names))
+		if err != nil {
+			return err
+		}
+	}
+
+	if !fileExists(leaf.fragments["clientCSR"]) {
+		fmt.Fprintln(ca.log, "Generating client CSR")
+		return ca.backend.generateCertificateSigningRequest(leaf.fragments["clientPrivateKey"], leaf.fragments["clientCSR"], leaf.fragments["clientCSRConfig"])
+	}
+	return nil
+}
+
+//Signs the client certificate with its intermediate authority unless it already exists. The caller holds the mutex.
+func (leaf *Leaf) makeClientCertificate() error {
+	ca := leaf.ca
+	if !fileExists(leaf.fragments["clientCertificate"]) {
+		fmt.Fprintln(ca.log, "Generating client certificate")
+		err := ca.backend.generateSignedCertificate(leaf.fragments["clientCSR"], leaf.fragments["clientCertificate"], leaf.fragments["clientConfig"], leaf.fragments["intermediateAuthorityPrivateKey"], leaf.fragments["intermediateAuthorityCertificate"], leaf.fragments["clientDirectory"])
+		if err != nil {
+			return err
+		}
+		leaf.signed = true
+	}
+	return nil
+}
+
+//Writes server_bundle.crt: the server certificate followed by the certificates of its intermediate authority and of the root
+func (leaf *Leaf) makeServerCertificateBundle() error {
+	fmt.Fprintln(leaf.ca.log, "Generating server certificate bundle")
+	bundle := []byte{}
+	for _, certificate := range []string{leaf.Certificate, leaf.fragments["intermediateAuthorityCertificate"], leaf.fragments["rootAuthorityCertificate"]} {
+		data, err := ioutil.ReadFile(certificate)
+		if err != nil {
+			return newError(IOError, "reading %s during bundle generation: %w", certificate, err)
+		}
+		bundle = append(bundle, data...)
+	}
+	return classify(ioutil.WriteFile(leaf.Chain, bundle, 0644), IOError)
+}
+
+//Writes client_chain.crt, the client certificate followed by the intermediate authority's certificate,
+//which is what a client presents during the TLS handshake
+func (leaf *Leaf) makeClientCertificateChain() error {
+	fmt.Fprintln(leaf.ca.log, "Generating client certificate chain: "+leaf.Chain)
+	chain := []byte{}
+	for _, certificate := range []string{leaf.Certificate, leaf.fragments["intermediateAuthorityCertificate"]} {
+		data, err := ioutil.ReadFile(certificate)
+		if err != nil {
+			return classify(err, IOError)
+		}
+		chain = append(chain, data...)
+	}
+	return classify(ioutil.WriteFile(leaf.Chain, chain, 0644), IOError)
+}
+
+//Returns an error unless the certificate has been issued, by an intermediate authority that still exists
+func (leaf *Leaf) checkIssued() error {
+	if fileExists(leaf.Certificate) {
+		return leaf.ca.checkIntermediate(leaf.Intermediate)
+	}
+	if leaf.Kind == "client" {
+		return newError(InvalidError, "%s does not exist, issue a client certificate for %s first", leaf.Certificate, leaf.Name)
+	}
+	return newError(InvalidError, "%s does not exist, issue a certificate for %s first", leaf.Certificate, leaf.Name)
+}
+
+//Writes PKCS12, a password protected PKCS#12 keystore holding the key, the certificate and the intermediate
+//authority's certificate, with encryption modern or legacy. friendlyName is the alias of the entry, Name when empty.
+//The encryption and friendly name are recorded next to it, so that Renew rebuilds it the same way.
+func (leaf *Leaf) ExportPKCS12(friendlyName, password, encryption string) error {
+	if !slices.Contains(PKCS12Encryptions, encryption) {
+		return newError(InvalidError, "unknown encryption %q, expected one of %s", encryption, strings.Join(PKCS12Encryptions, ", "))
+	}
+	if password == "" {
+		return newError(InvalidError, "a password for %s is required", filepath.Base(leaf.PKCS12))
+	}
+
+	err := leaf.checkIssued()
+	if err != nil {
+		return err
+	}
+
+	if friendlyName == "" {
+		friendlyName = leaf.Name
+	}
+	fmt.Fprintln(leaf.ca.log, "Generating PKCS#12 keystore: "+leaf.PKCS12)
+	err = leaf.ca.backend.exportPKCS12(leaf.PKCS12, leaf.PrivateKey, []string{leaf.Certificate, leaf.fragments["intermediateAuthorityCertificate"]}, friendlyName, password, encryption)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(leaf.pkcs12Record, []byte(encryption+"\n"+friendlyName+"\n"), 0644)
+	if err != nil {
+		return newError(IOError, "recording the encryption of %s in %s: %w", filepath.Base(leaf.PKCS12), leaf.pkcs12Record, err)
+	}
+	return nil
+}
+
+//Marks the certificate as revoked in the database of the intermediate authority that issued it, and regenerates that authority's CRL.
+//reason is an RFC 5280 reason such as keyCompromise, or empty.
+func (leaf *Leaf) Revoke(reason string) error {
+	err := leaf.checkIssued()
+	if err != nil {
+		return err
+	}
+
+	err = leaf.ca.CheckAuthorities()
+	if err != nil {
+		return err
+	}
+
+	leaf.ca.mutex.Lock()
+	defer leaf.ca.mutex.Unlock()
+	return leaf.ca.revokeIssuedCertificate(intermediateAuthority(leaf.Intermediate), leaf.Certificate, reason)
+}
+
+//Returns the issued server certificates in the output directory and client certificates in output/clients,
+//or only those named in names. A name that has no certificate is an error. Servers and clients listed in
+//the options keep their settings, while the others get the default ones.
+func (ca *CA) Leaves(names []string) ([]*Leaf, error) {
+	leaves := []*Leaf{}
+	for _, directory := range []struct {
+		kind string
+		path string
+	}{{"server", ca.fragments["outputDirectory"]}, {"client", ca.fragments["clientsDirectory"]}} {
+		entries, err := os.ReadDir(directory.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() || (len(names) > 0 && !slices.Contains(names, entry.Name())) {
+				continue
+			}
+
+			var leaf *Leaf
+			if directory.kind == "server" {
+				leaf = ca.Server(ca.options.Server(entry.Name()))
+			} else {
+				leaf = ca.Client(ca.options.Client(entry.Name()))
+			}
+
+			//The authorities, the OCSP responder and the ACME server have directories of their own, without a leaf certificate
+			if fileExists(leaf.Certificate) {
+				leaves = append(leaves, leaf)
+			}
+		}
+	}
+
+	for _, name := range names {
+		if !slices.ContainsFunc(leaves, func(leaf *Leaf) bool { return leaf.Name == name }) {
+			return nil, newError(InvalidError, "no server or client certificate named %s was found in %s", name, ca.fragments["outputDirectory"])
+		}
+	}
+	return leaves, nil
+}
+
+//The outcome of renewing one certificate. Expires is the expiry date of the certificate afterwards, and is zero
+//when it can't be read. Renewed is false when the certificate was skipped, because it expires after the threshold.
+type RenewResult struct {
+	Leaf    *Leaf
+	Expires time.Time
+	Renewed bool
+	Err     error
+}
+
+//Reissues the certificates returned by Leaves(names) that expire within the renew threshold, or all of them with force.
+//With the rotate keys option, they also get new keys. password, when given, is used to rebuild the client.p12 of clients
+//and the server.p12 of servers that have one, the way they were exported.
+//The returned error is only set when the certificates couldn't be listed. Failures to renew are reported in the results.
+func (ca *CA) Renew(names []string, force bool, password string) ([]RenewResult, error) {
+	err := ca.CheckAuthorities()
+	if err != nil {
+		return nil, err
+	}
+
+	leaves, err := ca.Leaves(names)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []RenewResult{}
+	for _, leaf := range leaves {
+		result := RenewResult{Leaf: leaf}
+		certificate, err := readCertificate(leaf.Certificate)
+		if err == nil {
+			result.Expires = certificate.NotAfter
+			if !force && time.Until(certificate.NotAfter) > time.Duration(ca.options.Renew.ThresholdDays)*24*time.Hour {
+				results = append(results, result)
+				continue
+			}
+		}
+
+		result.Err = leaf.Renew(ca.options.Renew.RotateKeys, password)
+		result.Renewed = result.Err == nil
+		if certificate, readError := readCertificate(leaf.Certificate); readError == nil {
+			result.Expires = certificate.NotAfter
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+//Reissues the certificate from its existing configuration, so that it keeps its names and extensions, and rebuilds
+//the files made from it. With rotateKey, a new key of the same algorithm and a new CSR replace the old ones.
+//The new files are written next to the old ones and only replace them once the certificate has been signed.
+func (leaf *Leaf) Renew(rotateKey bool, password string) error {
+	ca := leaf.ca
+	unlock := ca.lockLeaf(leaf.Directory)
+	defer unlock()
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	fmt.Fprintln(ca.log, "Renewing "+leaf.Kind+" certificate: "+leaf.Certificate)
+	err := ca.checkIntermediate(leaf.Intermediate)
+	if err != nil {
+		return err
+	}
+	for _, file := range []string{leaf.configuration, leaf.certificateSigningRequest} {
+		if !fileExists(file) {
+			return newError(InvalidError, "%s is missing, issue the certificate again instead", file)
+		}
+	}
+
+	replacements := map[string]string{leaf.Certificate + ".new": leaf.Certificate}
+	certificateSigningRequest := leaf.certificateSigningRequest
+	if rotateKey {
+		replacements[leaf.PrivateKey+".new"] = leaf.PrivateKey
+		replacements[leaf.certificateSigningRequest+".new"] = leaf.certificateSigningRequest
+		certificateSigningRequest += ".new"
+	}
+	defer func() {
+		for newFile := range replacements {
+			os.Remove(newFile)
+		}
+	}()
+
+	if rotateKey {
+		err := ca.backend.generatePrivateKey(leaf.PrivateKey+".new", recordedKeyAlgorithm(leaf.keyAlgorithmRecord, leaf.keyAlgorithm), "none")
+		if err != nil {
+			return err
+		}
+
+		err = ca.backend.generateCertificateSigningRequest(leaf.PrivateKey+".new", certificateSigningRequest, leaf.requestConfiguration)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ca.backend.generateSignedCertificate(certificateSigningRequest, leaf.Certificate+".new", leaf.configuration, leaf.fragments["intermediateAuthorityPrivateKey"], leaf.fragments["intermediateAuthorityCertificate"], leaf.Directory)
+	if err != nil {
+		return err
+	}
+
+	for newFile, file := range replacements {
+		err = os.Rename(newFile, file)
+		if err != nil {
+			return classify(err, IOError)
+		}
+	}
+
+	if leaf.Kind == "server" {
+		err = leaf.makeServerCertificateBundle()
+	} else {
+		err = leaf.makeClientCertificateChain()
+	}
+	//A server only has a server.p12 once it has been exported
+	if err != nil || !fileExists(leaf.PKCS12) {
+		return err
+	}
+
+	if password == "" {
+		rebuild := "export-p12 " + leaf.Name
+		if leaf.Kind == "client" {
+			rebuild = "issue-client " + leaf.Name
+		}
+		fmt.Fprintln(ca.log, filepath.Base(leaf.PKCS12)+" still holds the old certificate. Set PKCS12_PASSWORD or run "+rebuild+" to rebuild it.")
+		return nil
+	}
+
+	//The keystore keeps the encryption and friendly name it was exported with, modern and Name when they weren't recorded
+	encryption, friendlyName := "modern", ""
+	record, err := ioutil.ReadFile(leaf.pkcs12Record)
+	if err == nil {
+		lines := strings.Split(string(record), "\n")
+		if slices.Contains(PKCS12Encryptions, lines[0]) {
+			encryption = lines[0]
+		}
+		if len(lines) > 1 {
+			friendlyName = lines[1]
+		}
+	}
+	return leaf.ExportPKCS12(friendlyName, password, encryption)
+}
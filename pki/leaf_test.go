@@ -0,0 +1,154 @@
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"os"
+	"strings"
+	"testing"
+)
+
+//Returns the certificate, the friendly name and the public key of the entry of a legacy keystore protected by changeit
+func readRenewTestPKCS12(t *testing.T, filename string) (*x509.Certificate, string, crypto.PublicKey) {
+	t.Helper()
+	certificateBags, keyBags := readPKCS12Test(t, filename, "changeit", oidSHA1, sha1.New)
+	if len(certificateBags) != 2 || len(keyBags) != 1 {
+		t.Fatalf("%s holds %d certificates and %d keys, expected 2 and 1", filename, len(certificateBags), len(keyBags))
+	}
+
+	var certificateBag pkcs12CertificateBag
+	_, err := asn1.Unmarshal(certificateBags[0].Value.Bytes, &certificateBag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(certificateBag.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var shroudedKey pkcs12EncryptedPrivateKeyInfo
+	_, err = asn1.Unmarshal(keyBags[0].Value.Bytes, &shroudedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(decryptPKCS12TestData(t, shroudedKey.Algorithm, "changeit", shroudedKey.EncryptedData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, pkcs12TestFriendlyName(t, certificateBags[0]), key.(crypto.Signer).Public()
+}
+
+//Returns true if a and b are the same public key
+func samePublicKey(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+func TestRenew(t *testing.T) {
+	var log bytes.Buffer
+	ca := newTestCA(t, func(options *Options) { options.Log = &log })
+	server, err := ca.IssueServer(newTestServer("example.test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.ExportPKCS12("app", "changeit", "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClientOptions("alice", nil)
+	client.KeyAlgorithm = "ecdsa-p256"
+	_, err = ca.IssueClient(client, "changeit", "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Nothing expires within the threshold yet
+	results, err := ca.Renew(nil, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Renew returned %d results, expected the server and the client", len(results))
+	}
+	for _, result := range results {
+		if result.Renewed || result.Err != nil || result.Expires.IsZero() {
+			t.Errorf("Renew of %s without force returned %+v, expected it to be skipped", result.Leaf.Name, result)
+		}
+	}
+
+	//Without a password, server.p12 is left alone with the old certificate, and a notice says so
+	original, err := readCertificate(server.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	originalPKCS12, err := os.ReadFile(server.PKCS12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err = ca.Renew([]string{"example.test"}, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].Renewed || results[0].Err != nil {
+		t.Fatalf("Renew of example.test returned %+v", results)
+	}
+	renewed, err := readCertificate(server.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.SerialNumber.Cmp(original.SerialNumber) == 0 || !samePublicKey(renewed.PublicKey, original.PublicKey) {
+		t.Errorf("renewing without rotating keys didn't keep the key in a new certificate")
+	}
+	if PKCS12, err := os.ReadFile(server.PKCS12); err != nil || !bytes.Equal(PKCS12, originalPKCS12) {
+		t.Errorf("renewing without a password changed server.p12")
+	}
+	if !strings.Contains(log.String(), "server.p12 still holds the old certificate") {
+		t.Errorf("renewing without a password didn't say server.p12 is stale:\n%s", log.String())
+	}
+
+	//With a password, server.p12 and client.p12 are rebuilt the way they were exported, with the new keys
+	ca.options.Renew.RotateKeys = true
+	results, err = ca.Renew(nil, true, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if !result.Renewed || result.Err != nil {
+			t.Errorf("Renew of %s returned %+v", result.Leaf.Name, result)
+			continue
+		}
+
+		leaf := result.Leaf
+		certificate, err := readCertificate(leaf.Certificate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain, err := readCertificateBundle(leaf.Chain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !chain[0].Equal(certificate) {
+			t.Errorf("%s doesn't start with the renewed certificate", leaf.Chain)
+		}
+		if leaf.Kind == "server" && samePublicKey(certificate.PublicKey, original.PublicKey) {
+			t.Errorf("renewing with rotate keys kept the key of %s", leaf.Name)
+		}
+		if !result.Expires.Equal(certificate.NotAfter) {
+			t.Errorf("Renew of %s returned the expiry date %s, expected %s", leaf.Name, result.Expires, certificate.NotAfter)
+		}
+
+		PKCS12Certificate, friendlyName, publicKey := readRenewTestPKCS12(t, leaf.PKCS12)
+		expectedName := map[string]string{"server": "app", "client": "alice"}[leaf.Kind]
+		if !PKCS12Certificate.Equal(certificate) || friendlyName != expectedName || !samePublicKey(certificate.PublicKey, publicKey) {
+			t.Errorf("%s holds the certificate %s under the name %q, expected the renewed certificate %s and its key under %q", leaf.PKCS12, PKCS12Certificate.SerialNumber, friendlyName, certificate.SerialNumber, expectedName)
+		}
+	}
+
+	_, err = ca.Renew([]string{"missing.test"}, true, "")
+	if KindOf(err) != InvalidError {
+		t.Errorf("Renew of a certificate that wasn't issued returned the error %v, expected an InvalidError", err)
+	}
+}
@@ -0,0 +1,206 @@
+package pki
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+//A certificate recorded in the database of the root or an intermediate authority. Authority is root, intermediate
+//or the name of a named intermediate, and Issuer is the subject of the authority's certificate. Status is valid,
+//revoked or expired. Names are the subject alternative names, read from File, the copy of the certificate the
+//authority kept when it signed it. Both are empty when the copy is missing. Revoked and RevocationReason are only
+//set for revoked certificates.
+type IssuedCertificate struct {
+	Authority        string    `json:"authority"`
+	Status           string    `json:"status"`
+	Expires          time.Time `json:"expires"`
+	SerialNumber     string    `json:"serial_number"`
+	Subject          string    `json:"subject"`
+	Names            []string  `json:"names"`
+	Issuer           string    `json:"issuer"`
+	Revoked          time.Time `json:"revoked,omitzero"`
+	RevocationReason string    `json:"revocation_reason,omitempty"`
+	File             string    `json:"file,omitempty"`
+}
+
+//The statuses of an IssuedCertificate
+var CertificateStatuses = []string{"valid", "revoked", "expired"}
+
+//Selects some of the certificates returned by List. Empty fields select every certificate. Name selects the
+//certificates whose subject or one of whose names contains it, ignoring case. ExpiresWithin selects the valid
+//certificates that expire within that time.
+type ListFilter struct {
+	Authority     string
+	Status        string
+	Name          string
+	ExpiresWithin time.Duration
+}
+
+//Returns true if the certificate is selected by the filter
+func (filter ListFilter) Matches(certificate IssuedCertificate) bool {
+	if filter.Authority != "" && certificate.Authority != filter.Authority {
+		return false
+	}
+	if filter.Status != "" && certificate.Status != filter.Status {
+		return false
+	}
+	if filter.ExpiresWithin > 0 && (certificate.Status != "valid" || time.Until(certificate.Expires) > filter.ExpiresWithin) {
+		return false
+	}
+	if filter.Name != "" {
+		name := strings.ToLower(filter.Name)
+		contains := func(value string) bool { return strings.Contains(strings.ToLower(value), name) }
+		return contains(certificate.Subject) || slices.ContainsFunc(certificate.Names, contains)
+	}
+	return true
+}
+
+//Returns the certificates recorded in the databases of the root and intermediate authorities, named ones included.
+//The root's database is left out when the root is offline and its storage is not attached. The names of each
+//certificate are read from the copy its authority wrote next to it when signing it, named after its serial number.
+func (ca *CA) List() ([]IssuedCertificate, error) {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	authorities := []struct {
+		name        string
+		database    string
+		certificate string
+	}{{"root", ca.fragments["rootAuthorityDatabase"], ca.fragments["rootAuthorityCertificate"]}}
+	for _, authority := range ca.intermediateAuthorities() {
+		name := ca.fragments[authority+"Name"]
+		if name == "" {
+			name = "intermediate"
+		}
+		authorities = append(authorities, struct {
+			name        string
+			database    string
+			certificate string
+		}{name, ca.fragments[authority+"Database"], ca.fragments[authority+"Certificate"]})
+	}
+
+	copies, err := ca.findCertificateCopies()
+	if err != nil {
+		return nil, err
+	}
+
+	issued := []IssuedCertificate{}
+	for _, authority := range authorities {
+		if authority.name == "root" && ca.rootOffline() && !fileExists(authority.database) {
+			fmt.Fprintln(ca.log, "The root authority directory "+ca.fragments["rootAuthorityDirectory"]+" is not attached, listing the intermediate authorities' certificates only")
+			continue
+		}
+		//A named intermediate listed in the options but not created yet has no database
+		if authority.name != "root" && authority.name != "intermediate" && !fileExists(authority.database) {
+			continue
+		}
+		entries, err := readDatabase(authority.database)
+		if err != nil {
+			return nil, err
+		}
+
+		var issuer *x509.Certificate
+		if fileExists(authority.certificate) {
+			issuer, err = readCertificate(authority.certificate)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, entry := range entries {
+			certificate, err := entry.issuedCertificate(authority.name, issuer, copies)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", authority.database, err)
+			}
+			issued = append(issued, certificate)
+		}
+	}
+	return issued, nil
+}
+
+//Describes a database entry of the authority called name, whose certificate is issuer, nil when it is missing.
+//copies are the certificate copies found by findCertificateCopies.
+func (entry databaseEntry) issuedCertificate(name string, issuer *x509.Certificate, copies map[string]string) (IssuedCertificate, error) {
+	expiryDate, err := time.Parse("060102150405Z", entry.expiryDate)
+	if err != nil {
+		return IssuedCertificate{}, err
+	}
+
+	certificate := IssuedCertificate{Authority: name, Expires: expiryDate, SerialNumber: entry.serialNumber, Subject: entry.subject, Names: []string{}}
+	certificate.Status = map[string]string{"V": "valid", "R": "revoked", "E": "expired"}[entry.status]
+	if certificate.Status == "valid" && time.Now().After(expiryDate) {
+		certificate.Status = "expired"
+	}
+
+	if entry.status == "R" {
+		date, reason, _ := strings.Cut(entry.revocationDate, ",")
+		certificate.Revoked, err = time.Parse("060102150405Z", date)
+		if err != nil {
+			return IssuedCertificate{}, fmt.Errorf("invalid revocation date of certificate %s: %w", entry.serialNumber, err)
+		}
+		certificate.RevocationReason = reason
+	}
+
+	if issuer == nil {
+		return certificate, nil
+	}
+	certificate.Issuer = opensslSubject(issuer.Subject)
+
+	file, found := copies[certificateCopyKey(entry.serialNumber, issuer.RawSubject)]
+	if !found {
+		return certificate, nil
+	}
+	copied, err := readCertificate(file)
+	if err != nil {
+		return IssuedCertificate{}, err
+	}
+	certificate.Names = certificateNames(copied)
+	certificate.File = file
+	return certificate, nil
+}
+
+//The name of the copies that both backends write of every certificate they sign: its serial number in upper case
+//hexadecimal with a .pem extension
+var certificateCopyName = regexp.MustCompile(`^[0-9A-F]+\.pem$`)
+
+//Identifies the copy of a certificate by its serial number and the raw subject of its issuer, since certificates of
+//different authorities can share a serial number
+func certificateCopyKey(serialNumber string, issuer []byte) string {
+	return serialNumber + "/" + string(issuer)
+}
+
+//Finds the copies of signed certificates in the output directory and the root authority's directory, which are kept
+//in the directory of the authority, server, client or ACME order the certificate was signed for
+func (ca *CA) findCertificateCopies() (map[string]string, error) {
+	copies := map[string]string{}
+	for _, directory := range []string{ca.fragments["outputDirectory"], ca.fragments["rootAuthorityDirectory"]} {
+		err := filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || !certificateCopyName.MatchString(entry.Name()) {
+				return nil
+			}
+
+			certificate, err := readCertificate(path)
+			if err != nil {
+				//Anything else named like a copy is not one
+				return nil
+			}
+			copies[certificateCopyKey(serialNumberHex(certificate.SerialNumber), certificate.RawIssuer)] = path
+			return nil
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, newError(IOError, "looking for certificate copies in %s: %w", directory, err)
+		}
+	}
+	return copies, nil
+}
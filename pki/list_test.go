@@ -0,0 +1,159 @@
+package pki
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestListFilterMatches(t *testing.T) {
+	certificate := IssuedCertificate{
+		Authority: "intermediate",
+		Status:    "valid",
+		Expires:   time.Now().Add(10 * 24 * time.Hour),
+		Subject:   "/CN=App Server",
+		Names:     []string{"app.example.test", "10.0.0.1"},
+	}
+	tests := []struct {
+		filter   ListFilter
+		expected bool
+	}{
+		{ListFilter{}, true},
+		{ListFilter{Authority: "intermediate"}, true},
+		{ListFilter{Authority: "root"}, false},
+		{ListFilter{Status: "valid"}, true},
+		{ListFilter{Status: "revoked"}, false},
+		{ListFilter{Name: "app server"}, true},
+		{ListFilter{Name: "EXAMPLE.test"}, true},
+		{ListFilter{Name: "10.0.0"}, true},
+		{ListFilter{Name: "other"}, false},
+		{ListFilter{ExpiresWithin: 30 * 24 * time.Hour}, true},
+		{ListFilter{ExpiresWithin: 5 * 24 * time.Hour}, false},
+		{ListFilter{Authority: "intermediate", Status: "valid", Name: "app", ExpiresWithin: 30 * 24 * time.Hour}, true},
+		{ListFilter{Authority: "intermediate", Status: "valid", Name: "other", ExpiresWithin: 30 * 24 * time.Hour}, false},
+	}
+	for _, test := range tests {
+		if matches := test.filter.Matches(certificate); matches != test.expected {
+			t.Errorf("%+v.Matches returned %t, expected %t", test.filter, matches, test.expected)
+		}
+	}
+
+	//Only valid certificates expire within a time
+	certificate.Status = "revoked"
+	if (ListFilter{ExpiresWithin: 30 * 24 * time.Hour}).Matches(certificate) {
+		t.Errorf("a filter on the expiry date selected a revoked certificate")
+	}
+}
+
+func TestIssuedCertificate(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	past := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+	revoked := past.Add(-time.Hour)
+	format := func(date time.Time) string { return date.Format("060102150405Z") }
+
+	tests := []struct {
+		entry    databaseEntry
+		expected IssuedCertificate
+	}{
+		{
+			databaseEntry{status: "V", expiryDate: format(future), serialNumber: "0A", subject: "/CN=a"},
+			IssuedCertificate{Authority: "intermediate", Status: "valid", Expires: future, SerialNumber: "0A", Subject: "/CN=a", Names: []string{}},
+		},
+		{
+			databaseEntry{status: "V", expiryDate: format(past), serialNumber: "0B", subject: "/CN=b"},
+			IssuedCertificate{Authority: "intermediate", Status: "expired", Expires: past, SerialNumber: "0B", Subject: "/CN=b", Names: []string{}},
+		},
+		{
+			databaseEntry{status: "E", expiryDate: format(past), serialNumber: "0C", subject: "/CN=c"},
+			IssuedCertificate{Authority: "intermediate", Status: "expired", Expires: past, SerialNumber: "0C", Subject: "/CN=c", Names: []string{}},
+		},
+		{
+			databaseEntry{status: "R", expiryDate: format(future), revocationDate: format(revoked) + ",keyCompromise", serialNumber: "0D", subject: "/CN=d"},
+			IssuedCertificate{Authority: "intermediate", Status: "revoked", Expires: future, SerialNumber: "0D", Subject: "/CN=d", Names: []string{}, Revoked: revoked, RevocationReason: "keyCompromise"},
+		},
+		{
+			databaseEntry{status: "R", expiryDate: format(future), revocationDate: format(revoked), serialNumber: "0E", subject: "/CN=e"},
+			IssuedCertificate{Authority: "intermediate", Status: "revoked", Expires: future, SerialNumber: "0E", Subject: "/CN=e", Names: []string{}, Revoked: revoked},
+		},
+	}
+	for _, test := range tests {
+		certificate, err := test.entry.issuedCertificate("intermediate", nil, nil)
+		if err != nil {
+			t.Errorf("issuedCertificate of %+v returned the error %v", test.entry, err)
+			continue
+		}
+		if !certificate.Expires.Equal(test.expected.Expires) || !certificate.Revoked.Equal(test.expected.Revoked) {
+			t.Errorf("issuedCertificate of %+v returned the dates %s and %s, expected %s and %s", test.entry, certificate.Expires, certificate.Revoked, test.expected.Expires, test.expected.Revoked)
+		}
+		certificate.Expires, certificate.Revoked = test.expected.Expires, test.expected.Revoked
+		if certificate.Status != test.expected.Status || certificate.SerialNumber != test.expected.SerialNumber || certificate.Subject != test.expected.Subject ||
+			certificate.RevocationReason != test.expected.RevocationReason || certificate.Issuer != "" || certificate.File != "" || !slices.Equal(certificate.Names, test.expected.Names) {
+			t.Errorf("issuedCertificate of %+v returned %+v, expected %+v", test.entry, certificate, test.expected)
+		}
+	}
+
+	for _, entry := range []databaseEntry{
+		{status: "V", expiryDate: "20270101000000Z", serialNumber: "0F"},
+		{status: "R", expiryDate: format(future), revocationDate: "yesterday,keyCompromise", serialNumber: "10"},
+	} {
+		_, err := entry.issuedCertificate("intermediate", nil, nil)
+		if err == nil {
+			t.Errorf("issuedCertificate of %+v returned no error", entry)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	ca := newTestCA(t, nil)
+	server, err := ca.IssueServer(newTestServer("example.test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Revoke("superseded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClientOptions("alice", []string{"alice@app.test"})
+	client.KeyAlgorithm = "ecdsa-p256"
+	_, err = ca.IssueClient(client, "changeit", "modern")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificates, err := ca.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	subjects := []string{}
+	for _, certificate := range certificates {
+		subjects = append(subjects, certificate.Authority+" "+certificate.Subject)
+	}
+	expected := []string{"root /CN=Root Authority Name", "root /CN=Intermediate Certificate Authority", "intermediate /CN=example.test", "intermediate /CN=alice"}
+	if !slices.Equal(subjects, expected) {
+		t.Fatalf("List returned the certificates %q, expected %q", subjects, expected)
+	}
+
+	revoked, alice := certificates[2], certificates[3]
+	if revoked.Status != "revoked" || revoked.RevocationReason != "superseded" || revoked.Revoked.IsZero() || revoked.Issuer != "/CN=Intermediate Certificate Authority" ||
+		!slices.Equal(revoked.Names, []string{"example.test", "127.0.0.1"}) || filepath.Dir(revoked.File) != filepath.Dir(server.Certificate) {
+		t.Errorf("List described the revoked server as %+v", revoked)
+	}
+	if alice.Status != "valid" || !slices.Equal(alice.Names, []string{"alice@app.test"}) || alice.File == "" {
+		t.Errorf("List described the client as %+v", alice)
+	}
+
+	//Without the copy the certificate is still listed, without its names
+	err = os.Remove(alice.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificates, err = ca.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certificates) != 4 || len(certificates[3].Names) != 0 || certificates[3].File != "" || certificates[3].Issuer == "" {
+		t.Errorf("List described a client whose copy is missing as %+v", certificates[3])
+	}
+}
@@ -0,0 +1,130 @@
+package pki
+
+import (
+	"fmt"
+	"sync"
+)
+
+//A list of server and client certificates to issue in one run with IssueManifest. A manifest file holds [[server]] and
+//[[client]] tables with the same keys as those of pki.toml, where a server can also name its directory.
+type Manifest struct {
+	Servers []ServerOptions
+	Clients []ClientOptions
+}
+
+//The outcome of issuing one certificate of a manifest. Created is false when the certificate already existed and was
+//kept, or when issuing it failed with Err.
+type IssueResult struct {
+	Leaf    *Leaf
+	Created bool
+	Err     error
+}
+
+//Reads a manifest file. Tables other than [[server]] and [[client]] are rejected so that typos are caught.
+func LoadManifest(filename string) (manifest Manifest, err error) {
+	defer func() { err = classify(err, InvalidError) }()
+
+	document, err := readToml(filename)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	err = decodeTomlTable(document, filename, map[string]any{}, "server", "client")
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	manifest.Servers, err = decodeServerTables(document, filename, nil)
+	if err != nil {
+		return Manifest{}, err
+	}
+	manifest.Clients, err = decodeClientTables(document, filename, nil)
+	if err != nil {
+		return Manifest{}, err
+	}
+	return manifest, manifest.Validate()
+}
+
+//Checks every server and client, and that no two of them share a directory
+func (manifest Manifest) Validate() (err error) {
+	defer func() { err = classify(err, InvalidError) }()
+
+	directories := map[string]bool{}
+	for _, server := range manifest.Servers {
+		err = server.validate()
+		if err != nil {
+			return err
+		}
+
+		if directories[server.DirectoryName()] {
+			return fmt.Errorf("the server %s is listed more than once", server.DirectoryName())
+		}
+		directories[server.DirectoryName()] = true
+	}
+
+	clients := map[string]bool{}
+	for _, client := range manifest.Clients {
+		err = client.validate()
+		if err != nil {
+			return err
+		}
+
+		if clients[client.Name] {
+			return fmt.Errorf("the client %s is listed more than once", client.Name)
+		}
+		clients[client.Name] = true
+	}
+	return nil
+}
+
+//Issues the servers and clients of manifest, up to parallel of them at a time. Keys and CSRs are generated side by side,
+//while the signing takes turns, because it updates the database and serial number file of the intermediate authority.
+//password and encryption protect the client.p12 of the clients, as for IssueClient. The results are in the order of
+//the manifest, servers first. The returned error is only set when nothing could be issued, because the manifest is
+//not valid, a client password is missing or the authorities don't exist. Failures to issue a certificate are reported
+//in the results.
+func (ca *CA) IssueManifest(manifest Manifest, parallel int, password, encryption string) ([]IssueResult, error) {
+	err := manifest.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(manifest.Clients) > 0 && password == "" {
+		return nil, newError(InvalidError, "a password for the client.p12 of the clients is required")
+	}
+
+	err = ca.CheckAuthorities()
+	if err != nil {
+		return nil, err
+	}
+
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]IssueResult, len(manifest.Servers)+len(manifest.Clients))
+	turns := make(chan struct{}, parallel)
+	var wait sync.WaitGroup
+	issue := func(index int, leaf *Leaf, issueLeaf func() (*Leaf, error)) {
+		defer wait.Done()
+		turns <- struct{}{}
+		defer func() { <-turns }()
+
+		issued, err := issueLeaf()
+		if issued != nil {
+			leaf = issued
+		}
+		results[index] = IssueResult{Leaf: leaf, Created: err == nil && leaf.signed, Err: err}
+	}
+
+	for index, server := range manifest.Servers {
+		wait.Add(1)
+		go issue(index, ca.Server(server), func() (*Leaf, error) { return ca.IssueServer(server) })
+	}
+	for index, client := range manifest.Clients {
+		wait.Add(1)
+		go issue(len(manifest.Servers)+index, ca.Client(client), func() (*Leaf, error) { return ca.IssueClient(client, password, encryption) })
+	}
+	wait.Wait()
+	return results, nil
+}
@@ -0,0 +1,91 @@
+package pki
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestManifestValidate(t *testing.T) {
+	server := func(domain, directory string) ServerOptions {
+		server := newTestServer(domain)
+		server.Directory = directory
+		return server
+	}
+	client := func(name string) ClientOptions { return NewClientOptions(name, nil) }
+
+	tests := []struct {
+		manifest Manifest
+		problem  string
+	}{
+		{Manifest{}, ""},
+		{Manifest{Servers: []ServerOptions{server("a.test", ""), server("b.test", "")}, Clients: []ClientOptions{client("alice"), client("bob")}}, ""},
+		{Manifest{Servers: []ServerOptions{server("a.test", ""), server("a.test", "a.test-rsa")}}, ""},
+		{Manifest{Servers: []ServerOptions{server("a.test", ""), server("a.test", "")}}, "the server a.test is listed more than once"},
+		{Manifest{Servers: []ServerOptions{server("a.test", "app"), server("b.test", "app")}}, "the server app is listed more than once"},
+		{Manifest{Servers: []ServerOptions{server("a.test", ""), server("b.test", "a.test")}}, "the server a.test is listed more than once"},
+		{Manifest{Clients: []ClientOptions{client("alice"), client("bob"), client("alice")}}, "the client alice is listed more than once"},
+		{Manifest{Servers: []ServerOptions{server("a.test", "../a")}}, "a.test"},
+	}
+	for _, test := range tests {
+		err := test.manifest.Validate()
+		if test.problem == "" {
+			if err != nil {
+				t.Errorf("Validate of %+v returned the error %v", test.manifest, err)
+			}
+			continue
+		}
+		if KindOf(err) != InvalidError || !strings.Contains(err.Error(), test.problem) {
+			t.Errorf("Validate of %+v returned the error %v, expected an InvalidError saying %q", test.manifest, err, test.problem)
+		}
+	}
+}
+
+func TestIssueManifest(t *testing.T) {
+	ca := newTestCA(t, nil)
+	manifest := Manifest{}
+	expected := []string{}
+	for index := range 6 {
+		manifest.Servers = append(manifest.Servers, newTestServer(fmt.Sprintf("server%d.test", index)))
+		expected = append(expected, fmt.Sprintf("server%d.test", index))
+	}
+	for index := range 4 {
+		client := NewClientOptions(fmt.Sprintf("client%d", index), nil)
+		client.KeyAlgorithm = "ecdsa-p256"
+		manifest.Clients = append(manifest.Clients, client)
+		expected = append(expected, client.Name)
+	}
+
+	_, err := ca.IssueManifest(manifest, 4, "", "modern")
+	if KindOf(err) != InvalidError {
+		t.Errorf("IssueManifest of clients without a password returned the error %v, expected an InvalidError", err)
+	}
+
+	//The results keep the order of the manifest although they are issued 4 at a time
+	for _, created := range []bool{true, false} {
+		results, err := ca.IssueManifest(manifest, 4, "changeit", "modern")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != len(expected) {
+			t.Fatalf("IssueManifest returned %d results, expected %d", len(results), len(expected))
+		}
+		for index, result := range results {
+			if result.Err != nil || result.Created != created || result.Leaf.Name != expected[index] {
+				t.Errorf("result %d of IssueManifest is %s created %t with the error %v, expected %s created %t", index, result.Leaf.Name, result.Created, result.Err, expected[index], created)
+			}
+		}
+	}
+
+	certificates, err := ca.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialNumbers := map[string]bool{}
+	for _, certificate := range certificates {
+		serialNumbers[certificate.SerialNumber] = true
+	}
+	if len(certificates) != 2+len(expected) || len(serialNumbers) != len(certificates) {
+		t.Errorf("the databases hold %d certificates with %d serial numbers after issuing the manifest, expected %d", len(certificates), len(serialNumbers), 2+len(expected))
+	}
+}
@@ -0,0 +1,214 @@
+package pki
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+//Returns the keyUsage bits of a server certificate whose key uses algorithm. TLS servers sign with their key,
+//and with RSA, clients of TLS 1.2 and older may also encrypt the key exchange to it. Other keys can't encrypt,
+//so RFC 8813 and strict clients reject keyEncipherment on them.
+func serverKeyUsage(algorithm string) string {
+	if strings.HasPrefix(algorithm, "rsa") {
+		return "digitalSignature,keyEncipherment"
+	}
+	return "digitalSignature"
+}
+
+//Returns the algorithm recorded in algorithmRecord by makePrivateKey, which is that of the key actually in use,
+//or requested when there is no record
+func recordedKeyAlgorithm(algorithmRecord, requested string) string {
+	recordedAlgorithm, err := ioutil.ReadFile(algorithmRecord)
+	if err != nil || strings.TrimSpace(string(recordedAlgorithm)) == "" {
+		return requested
+	}
+	return strings.TrimSpace(string(recordedAlgorithm))
+}
+
+//Returns the openssl subjectAltName type of name: IP for IPv4 and IPv6 addresses, URI for names with a scheme
+//such as spiffe://cluster.test/api, email for addresses such as alice@app.test, and DNS for host names,
+//which may start with a *. wildcard label
+func subjectAlternativeNameType(name string) (string, error) {
+	if net.ParseIP(name) != nil {
+		return "IP", nil
+	}
+
+	if strings.Contains(name, "@") && !strings.Contains(name, "://") {
+		address, err := mail.ParseAddress(name)
+		if err != nil || address.Address != name {
+			return "", fmt.Errorf("invalid email address %q", name)
+		}
+		return "email", nil
+	}
+
+	if strings.Contains(name, "://") {
+		uri, err := url.Parse(name)
+		if err != nil {
+			return "", fmt.Errorf("invalid URI %q: %w", name, err)
+		}
+		if uri.Scheme == "" || uri.Host == "" {
+			return "", fmt.Errorf("invalid URI %q: a scheme and host are required", name)
+		}
+		return "URI", nil
+	}
+
+	labels := strings.Split(strings.TrimPrefix(name, "*."), ".")
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", fmt.Errorf("invalid DNS name %q", name)
+		}
+		for _, character := range label {
+			if !(character >= 'a' && character <= 'z' || character >= 'A' && character <= 'Z' || character >= '0' && character <= '9' || character == '-') {
+				return "", fmt.Errorf("invalid DNS name %q: wildcards are only allowed as the whole leftmost label, as in *.example.test", name)
+			}
+		}
+	}
+	return "DNS", nil
+}
+
+//Renders names as the body of an openssl [altNames] section, numbering each type separately:
+//DNS.1 = example.test, DNS.2 = *.example.test, IP.1 = 127.0.0.1 and so on
+func formatSubjectAlternativeNames(names []string) string {
+	counts := map[string]int{}
+	lines := []string{}
+	for _, name := range names {
+		nameType, err := subjectAlternativeNameType(name)
+		if err != nil {
+			//Names are validated with the options, so this is not expected to happen
+			continue
+		}
+		counts[nameType]++
+		lines = append(lines, fmt.Sprintf("%s.%d = %s", nameType, counts[nameType], name))
+	}
+	return strings.Join(lines, "\n")
+}
+
+//The local names an authority is limited to by init -name-constraints: the domains reserved for testing and
+//private networks, and the loopback, private and link-local address ranges
+var LocalPermittedNames = []string{
+	"localhost", "test", "internal", "local", "home.arpa", "example", "invalid",
+	"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16",
+	"::1/128", "fc00::/7", "fe80::/10",
+}
+
+//Renders the nameConstraints line of an authority's x509_extensions section from its space separated permitted names,
+//or nothing when there are none. openssl writes IP ranges as an address and a netmask.
+func nameConstraintsLine(permittedNames string) string {
+	subtrees := []string{"critical"}
+	for _, name := range strings.Fields(permittedNames) {
+		_, network, err := net.ParseCIDR(name)
+		if err != nil {
+			subtrees = append(subtrees, "permitted;DNS:"+name)
+			continue
+		}
+		subtrees = append(subtrees, "permitted;IP:"+network.IP.String()+"/"+net.IP(network.Mask).String())
+	}
+
+	if len(subtrees) == 1 {
+		return ""
+	}
+	return "nameConstraints=" + strings.Join(subtrees, ",")
+}
+
+//Returns an error naming the first of names that the nameConstraints of the root certificate or of the certificate of
+//the intermediate authority called intermediate, the default one when empty, don't permit, so that it is refused before
+//anything is signed. Names of a type the constraints don't mention, such as email addresses when only DNS suffixes are
+//permitted, are not restricted.
+func (ca *CA) CheckNameConstraints(intermediate string, names []string) error {
+	err := ca.checkIntermediate(intermediate)
+	if err != nil {
+		return err
+	}
+
+	for _, authorityCertificate := range []string{ca.fragments["rootAuthorityCertificate"], ca.fragments[intermediateAuthority(intermediate)+"Certificate"]} {
+		authority, err := readCertificate(authorityCertificate)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			permitted := true
+			nameType, _ := subjectAlternativeNameType(name)
+			switch nameType {
+			case "DNS":
+				permitted = domainPermitted(name, authority.PermittedDNSDomains, authority.ExcludedDNSDomains)
+			case "IP":
+				ip := net.ParseIP(name)
+				for _, excluded := range authority.ExcludedIPRanges {
+					permitted = permitted && !excluded.Contains(ip)
+				}
+				if permitted && len(authority.PermittedIPRanges) > 0 {
+					permitted = slices.ContainsFunc(authority.PermittedIPRanges, func(network *net.IPNet) bool { return network.Contains(ip) })
+				}
+			case "URI":
+				uri, _ := url.Parse(name)
+				permitted = domainPermitted(uri.Hostname(), authority.PermittedURIDomains, authority.ExcludedURIDomains)
+			case "email":
+				permitted = emailPermitted(name, authority.PermittedEmailAddresses, authority.ExcludedEmailAddresses)
+			}
+
+			if !permitted {
+				return newError(InvalidError, "%s is outside the names permitted by %s (%s), so it can't be issued", name, authorityCertificate, describeNameConstraints(authority))
+			}
+		}
+	}
+	return nil
+}
+
+//Reports whether domain is covered by one of permitted, or permitted is empty, and isn't covered by any of excluded.
+//A constraint starting with a dot only covers names under it, while other constraints also cover themselves.
+func domainPermitted(domain string, permitted, excluded []string) bool {
+	covers := func(constraint string) bool {
+		domain := strings.ToLower(domain)
+		constraint = strings.ToLower(constraint)
+		if strings.HasPrefix(constraint, ".") {
+			return strings.HasSuffix(domain, constraint)
+		}
+		return domain == constraint || strings.HasSuffix(domain, "."+constraint)
+	}
+
+	if slices.ContainsFunc(excluded, covers) {
+		return false
+	}
+	return len(permitted) == 0 || slices.ContainsFunc(permitted, covers)
+}
+
+//Reports whether address is covered by one of permitted, or permitted is empty, and isn't covered by any of excluded.
+//A constraint is a whole address, a host whose addresses it covers, or a domain starting with a dot that covers the addresses of hosts under it.
+func emailPermitted(address string, permitted, excluded []string) bool {
+	covers := func(constraint string) bool {
+		_, host, _ := strings.Cut(address, "@")
+		switch {
+		case strings.Contains(constraint, "@"):
+			return strings.EqualFold(address, constraint)
+		case strings.HasPrefix(constraint, "."):
+			return strings.HasSuffix(strings.ToLower(host), strings.ToLower(constraint))
+		}
+		return strings.EqualFold(host, constraint)
+	}
+
+	if slices.ContainsFunc(excluded, covers) {
+		return false
+	}
+	return len(permitted) == 0 || slices.ContainsFunc(permitted, covers)
+}
+
+//Lists the permitted names of an authority certificate for error messages
+func describeNameConstraints(authority *x509.Certificate) string {
+	names := append([]string{}, authority.PermittedDNSDomains...)
+	for _, network := range authority.PermittedIPRanges {
+		names = append(names, network.String())
+	}
+	names = append(names, authority.PermittedEmailAddresses...)
+	names = append(names, authority.PermittedURIDomains...)
+	if len(names) == 0 {
+		return "only exclusions"
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,283 @@
+package pki
+
+import (
+	"crypto/x509"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSubjectAlternativeNameType(t *testing.T) {
+	tests := []struct {
+		name     string
+		nameType string
+	}{
+		{"example.test", "DNS"},
+		{"Example-1.TEST", "DNS"},
+		{"localhost", "DNS"},
+		{"*.example.test", "DNS"},
+		{"127.0.0.1", "IP"},
+		{"::1", "IP"},
+		{"2001:db8::1", "IP"},
+		{"::ffff:192.0.2.1", "IP"},
+		{"spiffe://cluster.test/ns/default/sa/billing", "URI"},
+		{"https://example.test:8443/callback", "URI"},
+		{"alice@app.test", "email"},
+		{"alice.smith+tls@app.test", "email"},
+	}
+	for _, test := range tests {
+		nameType, err := subjectAlternativeNameType(test.name)
+		if err != nil || nameType != test.nameType {
+			t.Errorf("subjectAlternativeNameType(%q) returned %q and the error %v, expected %s", test.name, nameType, err, test.nameType)
+		}
+	}
+
+	for _, name := range []string{
+		"",
+		"example..test",
+		".example.test",
+		"example.test.",
+		"-example.test",
+		"example-.test",
+		"under_score.test",
+		"exa mple.test",
+		strings.Repeat("a", 64) + ".test",
+		"*",
+		"*.",
+		"**.example.test",
+		"*example.test",
+		"www.*.example.test",
+		"*.*.example.test",
+		"fe80::1%eth0",
+		"spiffe://",
+		"spiffe:///path",
+		"://example.test",
+		"Alice <alice@app.test>",
+		"alice@",
+		"@app.test",
+	} {
+		nameType, err := subjectAlternativeNameType(name)
+		if err == nil {
+			t.Errorf("subjectAlternativeNameType(%q) returned %q, expected an error", name, nameType)
+		}
+	}
+}
+
+func TestFormatSubjectAlternativeNames(t *testing.T) {
+	names := []string{"example.test", "127.0.0.1", "*.example.test", "::1", "spiffe://cluster.test/api", "alice@app.test", "not a name", "2001:db8::1"}
+	expected := "DNS.1 = example.test\n" +
+		"IP.1 = 127.0.0.1\n" +
+		"DNS.2 = *.example.test\n" +
+		"IP.2 = ::1\n" +
+		"URI.1 = spiffe://cluster.test/api\n" +
+		"email.1 = alice@app.test\n" +
+		"IP.3 = 2001:db8::1"
+	if formatted := formatSubjectAlternativeNames(names); formatted != expected {
+		t.Errorf("formatSubjectAlternativeNames returned\n%s\nexpected\n%s", formatted, expected)
+	}
+	if formatted := formatSubjectAlternativeNames(nil); formatted != "" {
+		t.Errorf("formatSubjectAlternativeNames of no names returned %q", formatted)
+	}
+}
+
+func TestNameConstraintsLine(t *testing.T) {
+	tests := []struct {
+		permittedNames string
+		line           string
+	}{
+		{"", ""},
+		{"  ", ""},
+		{"test .example.test", "nameConstraints=critical,permitted;DNS:test,permitted;DNS:.example.test"},
+		{"10.1.2.3/8 192.168.0.0/16", "nameConstraints=critical,permitted;IP:10.0.0.0/255.0.0.0,permitted;IP:192.168.0.0/255.255.0.0"},
+		{"2001:db8::/32", "nameConstraints=critical,permitted;IP:2001:db8::/ffff:ffff::"},
+		{"::1/128", "nameConstraints=critical,permitted;IP:::1/ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+		{"fe80::/10 fc00::/7", "nameConstraints=critical,permitted;IP:fe80::/ffc0::,permitted;IP:fc00::/fe00::"},
+		{"test 127.0.0.0/8 ::1/128", "nameConstraints=critical,permitted;DNS:test,permitted;IP:127.0.0.0/255.0.0.0,permitted;IP:::1/ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+	}
+	for _, test := range tests {
+		if line := nameConstraintsLine(test.permittedNames); line != test.line {
+			t.Errorf("nameConstraintsLine(%q) returned\n%s\nexpected\n%s", test.permittedNames, line, test.line)
+		}
+	}
+}
+
+func TestDomainPermitted(t *testing.T) {
+	tests := []struct {
+		domain              string
+		permitted, excluded []string
+		expected            bool
+	}{
+		{"example.test", nil, nil, true},
+		{"example.test", []string{"example.test"}, nil, true},
+		{"www.example.test", []string{"example.test"}, nil, true},
+		{"WWW.Example.TEST", []string{"example.test"}, nil, true},
+		{"notexample.test", []string{"example.test"}, nil, false},
+		{"example.test.evil", []string{"example.test"}, nil, false},
+		{"example.test", []string{".example.test"}, nil, false},
+		{"www.example.test", []string{".example.test"}, nil, true},
+		{"*.example.test", []string{"example.test"}, nil, true},
+		{"*.example.test", []string{".example.test"}, nil, true},
+		{"*.test", []string{".example.test"}, nil, false},
+		{"*.example.test", []string{"other.test"}, nil, false},
+		{"example.test", []string{"other.test", "test"}, nil, true},
+		{"www.example.test", []string{"test"}, []string{"example.test"}, false},
+		{"example.test", []string{"test"}, []string{"example.test"}, false},
+		{"example.test", []string{"test"}, []string{".example.test"}, true},
+		{"www.example.test", []string{"test"}, []string{".example.test"}, false},
+		{"*.example.test", []string{"test"}, []string{"example.test"}, false},
+		{"www.example.test", nil, []string{"example.test"}, false},
+		{"other.test", nil, []string{"example.test"}, true},
+	}
+	for _, test := range tests {
+		if permitted := domainPermitted(test.domain, test.permitted, test.excluded); permitted != test.expected {
+			t.Errorf("domainPermitted(%q, %q, %q) returned %v, expected %v", test.domain, test.permitted, test.excluded, permitted, test.expected)
+		}
+	}
+}
+
+func TestEmailPermitted(t *testing.T) {
+	tests := []struct {
+		address             string
+		permitted, excluded []string
+		expected            bool
+	}{
+		{"alice@app.test", nil, nil, true},
+		{"alice@app.test", []string{"app.test"}, nil, true},
+		{"alice@APP.test", []string{"app.test"}, nil, true},
+		{"alice@mail.app.test", []string{"app.test"}, nil, false},
+		{"alice@mail.app.test", []string{".app.test"}, nil, true},
+		{"alice@app.test", []string{".app.test"}, nil, false},
+		{"alice@evilapp.test", []string{".app.test"}, nil, false},
+		{"alice@app.test", []string{"alice@app.test"}, nil, true},
+		{"Alice@App.Test", []string{"alice@app.test"}, nil, true},
+		{"bob@app.test", []string{"alice@app.test"}, nil, false},
+		{"bob@app.test", []string{"app.test"}, []string{"bob@app.test"}, false},
+		{"alice@app.test", []string{"app.test"}, []string{"bob@app.test"}, true},
+		{"alice@mail.app.test", nil, []string{".app.test"}, false},
+		{"alice@app.test", nil, []string{".app.test"}, true},
+	}
+	for _, test := range tests {
+		if permitted := emailPermitted(test.address, test.permitted, test.excluded); permitted != test.expected {
+			t.Errorf("emailPermitted(%q, %q, %q) returned %v, expected %v", test.address, test.permitted, test.excluded, permitted, test.expected)
+		}
+	}
+}
+
+func TestApplyNameConstraints(t *testing.T) {
+	var template x509.Certificate
+	err := applyNameConstraints("critical,permitted;DNS:.example.test,excluded;DNS:bad.example.test,permitted;IP:10.0.0.0/255.0.0.0,"+
+		"permitted;IP:2001:db8::/ffff:ffff::,excluded;IP:10.1.0.0/255.255.0.0,permitted;email:.app.test,excluded;email:bob@app.test,"+
+		"permitted;URI:.cluster.test,excluded;URI:bad.cluster.test", &template)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ranges := func(networks []*net.IPNet) []string {
+		strings := []string{}
+		for _, network := range networks {
+			strings = append(strings, network.String())
+		}
+		return strings
+	}
+	for name, field := range map[string]struct{ value, expected any }{
+		"critical":                {template.PermittedDNSDomainsCritical, true},
+		"PermittedDNSDomains":     {template.PermittedDNSDomains, []string{".example.test"}},
+		"ExcludedDNSDomains":      {template.ExcludedDNSDomains, []string{"bad.example.test"}},
+		"PermittedIPRanges":       {ranges(template.PermittedIPRanges), []string{"10.0.0.0/8", "2001:db8::/32"}},
+		"ExcludedIPRanges":        {ranges(template.ExcludedIPRanges), []string{"10.1.0.0/16"}},
+		"PermittedEmailAddresses": {template.PermittedEmailAddresses, []string{".app.test"}},
+		"ExcludedEmailAddresses":  {template.ExcludedEmailAddresses, []string{"bob@app.test"}},
+		"PermittedURIDomains":     {template.PermittedURIDomains, []string{".cluster.test"}},
+		"ExcludedURIDomains":      {template.ExcludedURIDomains, []string{"bad.cluster.test"}},
+	} {
+		if !reflect.DeepEqual(field.value, field.expected) {
+			t.Errorf("applyNameConstraints set %s to %v, expected %v", name, field.value, field.expected)
+		}
+	}
+	if len(template.PermittedIPRanges[0].IP) != net.IPv4len || len(template.PermittedIPRanges[0].Mask) != net.IPv4len {
+		t.Errorf("applyNameConstraints kept the IPv4 range %v in 16 bytes, which is encoded as an IPv6 range", template.PermittedIPRanges[0])
+	}
+
+	for _, value := range []string{
+		"allowed;DNS:example.test",
+		"permitted;dirName:example",
+		"permitted;IP:10.0.0.0",
+		"permitted;IP:10.0.0.0/8",
+		"permitted;IP:example.test/255.0.0.0",
+	} {
+		err := applyNameConstraints(value, &x509.Certificate{})
+		if err == nil {
+			t.Errorf("applyNameConstraints(%q) didn't return an error", value)
+		}
+	}
+}
+
+func TestCheckNameConstraints(t *testing.T) {
+	//Server certificates always cover 127.0.0.1
+	ca := newTestCA(t, func(options *Options) {
+		options.Intermediate.PermittedNames = []string{"test", ".example", "10.0.0.0/8", "127.0.0.0/8", "fd00::/8"}
+	})
+
+	intermediate, err := readCertificate(ca.IntermediateCertificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranges := []string{}
+	for _, network := range intermediate.PermittedIPRanges {
+		ranges = append(ranges, network.String())
+	}
+	if !intermediate.PermittedDNSDomainsCritical || !reflect.DeepEqual(intermediate.PermittedDNSDomains, []string{"test", ".example"}) || !reflect.DeepEqual(ranges, []string{"10.0.0.0/8", "127.0.0.0/8", "fd00::/8"}) {
+		t.Errorf("the intermediate certificate has the name constraints %q and %q, critical %v", intermediate.PermittedDNSDomains, ranges, intermediate.PermittedDNSDomainsCritical)
+	}
+
+	tests := []struct {
+		name      string
+		permitted bool
+	}{
+		{"test", true},
+		{"www.app.test", true},
+		{"*.app.test", true},
+		{"example", false},
+		{"www.example", true},
+		{"*.example", true},
+		{"example.com", false},
+		{"*.com", false},
+		{"10.1.2.3", true},
+		{"192.168.0.1", false},
+		{"fd00::1", true},
+		{"2001:db8::1", false},
+		{"alice@example.com", true},
+		{"spiffe://cluster.com/api", true},
+	}
+	for _, test := range tests {
+		err := ca.CheckNameConstraints("", []string{test.name})
+		if test.permitted && err != nil {
+			t.Errorf("CheckNameConstraints refused %s: %v", test.name, err)
+		}
+		if !test.permitted && (KindOf(err) != InvalidError || !strings.Contains(err.Error(), test.name+" is outside the names permitted")) {
+			t.Errorf("CheckNameConstraints of %s returned the error %v, expected it to be outside the permitted names", test.name, err)
+		}
+	}
+
+	_, err = ca.IssueServer(newTestServer("app.example.com"))
+	if KindOf(err) != InvalidError || !strings.Contains(err.Error(), "app.example.com is outside") {
+		t.Errorf("issuing a server outside the name constraints returned the error %v, expected an InvalidError", err)
+	}
+
+	leaf, err := ca.IssueServer(newTestServer("app.test", "*.app.test", "10.0.0.1", "fd00::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := readCertificateBundle(leaf.Chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(chain[2])
+	intermediates.AddCert(chain[1])
+	_, err = chain[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, DNSName: "www.app.test"})
+	if err != nil {
+		t.Errorf("crypto/x509 doesn't accept a certificate within the name constraints: %v", err)
+	}
+}
@@ -0,0 +1,440 @@
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+//OCSP messages from RFC 6960, laid out for encoding/asn1. Request fields that the responder does not use are left out.
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+}
+
+type ocspTBSRequest struct {
+	Version       int           `asn1:"explicit,tag:0,default:0,optional"`
+	RequestorName asn1.RawValue `asn1:"explicit,tag:1,optional"`
+	RequestList   []ocspSingleRequest
+	Extensions    []pkix.Extension `asn1:"explicit,tag:2,optional"`
+}
+
+type ocspSingleRequest struct {
+	CertID ocspCertID
+}
+
+type ocspCertID struct {
+	HashAlgorithm  pkix.AlgorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+type ocspResponse struct {
+	Status        asn1.Enumerated
+	ResponseBytes ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    ocspResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []ocspSingleResponse
+	Extensions  []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+//Exactly one of Good, Revoked and Unknown is set
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag       `asn1:"tag:0,optional"`
+	Revoked    ocspRevokedInfo `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag       `asn1:"tag:2,optional"`
+	ThisUpdate time.Time       `asn1:"generalized"`
+	NextUpdate time.Time       `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+//OCSP response statuses
+const (
+	ocspSuccessful       = 0
+	ocspMalformedRequest = 1
+	ocspInternalError    = 2
+	ocspUnauthorized     = 6
+)
+
+var (
+	oidOCSPBasicResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidOCSPNonce         = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+)
+
+//The hash algorithms a request may use to identify the issuer
+var ocspHashAlgorithms = map[string]crypto.Hash{
+	"1.3.14.3.2.26":          crypto.SHA1,
+	"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+	"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+	"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+}
+
+//The algorithm identifiers of the signature algorithms signatureAlgorithmFor picks
+var signatureAlgorithmIdentifiers = map[x509.SignatureAlgorithm]pkix.AlgorithmIdentifier{
+	x509.SHA256WithRSA:   {Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, Parameters: asn1.NullRawValue},
+	x509.SHA384WithRSA:   {Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, Parameters: asn1.NullRawValue},
+	x509.SHA512WithRSA:   {Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, Parameters: asn1.NullRawValue},
+	x509.ECDSAWithSHA256: {Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+	x509.ECDSAWithSHA384: {Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}},
+	x509.ECDSAWithSHA512: {Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}},
+	x509.PureEd25519:     {Algorithm: asn1.ObjectIdentifier{1, 3, 101, 112}},
+}
+
+//Answers OCSP requests about certificates issued by issuer, using the issuer's database and a delegated
+//responder certificate and key. The database is read for every request, so revocations show up immediately.
+type ocspResponder struct {
+	ca                 *CA
+	issuer             *x509.Certificate
+	database           string
+	responder          *x509.Certificate
+	responderKey       crypto.Signer
+	responseValidity   time.Duration
+	issuerPublicKey    []byte
+	responderPublicKey []byte
+	//The escaped path of the ocsp url, without a trailing slash, which GET requests append the encoded request to
+	path string
+}
+
+func newOCSPResponder(ca *CA, issuerCertificate, database, responderCertificate, responderPrivateKey string, responseValidity time.Duration) (*ocspResponder, error) {
+	issuer, err := readCertificate(issuerCertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	responder, err := readCertificate(responderCertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	responderKey, err := readPrivateKey(responderPrivateKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	issuerPublicKey, err := subjectPublicKeyBits(issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	responderPublicKey, err := subjectPublicKeyBits(responder)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ocspResponder{ca, issuer, database, responder, responderKey, responseValidity, issuerPublicKey, responderPublicKey, ""}, nil
+}
+
+//Returns the contents of the subjectPublicKey BIT STRING of a certificate, which OCSP key hashes are computed over
+func subjectPublicKeyBits(certificate *x509.Certificate) ([]byte, error) {
+	var publicKeyInfo struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	_, err := asn1.Unmarshal(certificate.RawSubjectPublicKeyInfo, &publicKeyInfo)
+	return publicKeyInfo.PublicKey.RightAlign(), err
+}
+
+//Accepts requests POSTed to the responder URL, and GET requests with the base64 encoded request appended to it
+func (responder *ocspResponder) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	escapedPath := request.URL.EscapedPath()
+	if escapedPath != responder.path && !strings.HasPrefix(escapedPath, responder.path+"/") {
+		http.NotFound(writer, request)
+		return
+	}
+
+	var body []byte
+	var err error
+	switch request.Method {
+	case http.MethodPost:
+		body, err = io.ReadAll(io.LimitReader(request.Body, 64*1024))
+	case http.MethodGet:
+		//Base64 contains slashes, which clients may or may not escape, so everything after the responder's path is
+		//the encoded request
+		encoded := strings.TrimPrefix(strings.TrimPrefix(escapedPath, responder.path), "/")
+		encoded, err = url.PathUnescape(encoded)
+		if err == nil {
+			body, err = base64.StdEncoding.DecodeString(encoded)
+		}
+	default:
+		http.Error(writer, "OCSP requests must use GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := responder.respond(body, err)
+	writer.Header().Set("Content-Type", "application/ocsp-response")
+	writer.Write(response)
+}
+
+//Builds the DER response to a DER request. readError is any error from reading the request.
+func (responder *ocspResponder) respond(requestBytes []byte, readError error) []byte {
+	var request ocspRequest
+	if readError == nil {
+		_, readError = asn1.Unmarshal(requestBytes, &request)
+	}
+	if readError != nil || len(request.TBSRequest.RequestList) == 0 {
+		fmt.Fprintln(responder.ca.log, "OCSP: malformed request:", readError)
+		return ocspStatusResponse(ocspMalformedRequest)
+	}
+
+	responder.ca.mutex.Lock()
+	entries, err := readDatabase(responder.database)
+	responder.ca.mutex.Unlock()
+	if err != nil {
+		fmt.Fprintln(responder.ca.log, "OCSP: error reading", responder.database, err)
+		return ocspStatusResponse(ocspInternalError)
+	}
+
+	thisUpdate := time.Now().UTC().Truncate(time.Second)
+	responseData := ocspResponseData{ProducedAt: thisUpdate}
+	authoritative := false
+	for _, singleRequest := range request.TBSRequest.RequestList {
+		singleResponse := ocspSingleResponse{CertID: singleRequest.CertID, ThisUpdate: thisUpdate, NextUpdate: thisUpdate.Add(responder.responseValidity)}
+		status := "unknown"
+		if responder.issuedBy(singleRequest.CertID) {
+			authoritative = true
+			status = "good"
+			singleResponse.Good = true
+
+			serialNumber := serialNumberHex(singleRequest.CertID.SerialNumber)
+			entry, found := findDatabaseEntry(entries, serialNumber)
+			switch {
+			case !found:
+				status = "unknown"
+				singleResponse.Good = false
+				singleResponse.Unknown = true
+			case entry.status == "R":
+				revokedEntry, err := entry.revocationListEntry()
+				if err != nil {
+					fmt.Fprintln(responder.ca.log, "OCSP:", err)
+					return ocspStatusResponse(ocspInternalError)
+				}
+				status = "revoked"
+				singleResponse.Good = false
+				singleResponse.Revoked = ocspRevokedInfo{RevocationTime: revokedEntry.RevocationTime, Reason: asn1.Enumerated(revokedEntry.ReasonCode)}
+			}
+		} else {
+			singleResponse.Unknown = true
+		}
+		fmt.Fprintln(responder.ca.log, "OCSP: serial", serialNumberHex(singleRequest.CertID.SerialNumber), status)
+		responseData.Responses = append(responseData.Responses, singleResponse)
+	}
+
+	//A responder that knows none of the certificates is not authorized to answer
+	if !authoritative {
+		return ocspStatusResponse(ocspUnauthorized)
+	}
+
+	for _, extension := range request.TBSRequest.Extensions {
+		if extension.Id.Equal(oidOCSPNonce) {
+			responseData.Extensions = append(responseData.Extensions, extension)
+		}
+	}
+
+	response, err := responder.sign(responseData)
+	if err != nil {
+		fmt.Fprintln(responder.ca.log, "OCSP: error signing response:", err)
+		return ocspStatusResponse(ocspInternalError)
+	}
+	return response
+}
+
+//Returns true if the issuer name and key hashes of id match the issuer certificate
+func (responder *ocspResponder) issuedBy(id ocspCertID) bool {
+	hash, ok := ocspHashAlgorithms[id.HashAlgorithm.Algorithm.String()]
+	if !ok || !hash.Available() {
+		return false
+	}
+
+	nameHash := hash.New()
+	nameHash.Write(responder.issuer.RawSubject)
+	keyHash := hash.New()
+	keyHash.Write(responder.issuerPublicKey)
+	return bytes.Equal(nameHash.Sum(nil), id.IssuerNameHash) && bytes.Equal(keyHash.Sum(nil), id.IssuerKeyHash)
+}
+
+//Signs responseData with the responder key and wraps it in a successful response that includes the responder certificate
+func (responder *ocspResponder) sign(responseData ocspResponseData) ([]byte, error) {
+	keyHash := sha1.Sum(responder.responderPublicKey)
+	keyHashBytes, err := asn1.Marshal(keyHash[:])
+	if err != nil {
+		return nil, err
+	}
+	//ResponderID byKey [2] EXPLICIT KeyHash
+	responseData.ResponderID = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: keyHashBytes}
+
+	tbsResponseData, err := asn1.Marshal(responseData)
+	if err != nil {
+		return nil, err
+	}
+
+	signatureAlgorithm, err := signatureAlgorithmFor(responder.responderKey, "sha256")
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := signMessage(responder.responderKey, signatureAlgorithm, tbsResponseData)
+	if err != nil {
+		return nil, err
+	}
+
+	basicResponse, err := asn1.Marshal(ocspBasicResponse{
+		TBSResponseData:    responseData,
+		SignatureAlgorithm: signatureAlgorithmIdentifiers[signatureAlgorithm],
+		Signature:          asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+		Certificates:       []asn1.RawValue{{FullBytes: responder.responder.Raw}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(ocspResponse{
+		Status:        ocspSuccessful,
+		ResponseBytes: ocspResponseBytes{ResponseType: oidOCSPBasicResponse, Response: basicResponse},
+	})
+}
+
+//Signs message with one of the algorithms signatureAlgorithmFor picks, hashing it first unless the algorithm is Ed25519
+func signMessage(signer crypto.Signer, signatureAlgorithm x509.SignatureAlgorithm, message []byte) ([]byte, error) {
+	hashes := map[x509.SignatureAlgorithm]crypto.Hash{
+		x509.SHA256WithRSA: crypto.SHA256, x509.SHA384WithRSA: crypto.SHA384, x509.SHA512WithRSA: crypto.SHA512,
+		x509.ECDSAWithSHA256: crypto.SHA256, x509.ECDSAWithSHA384: crypto.SHA384, x509.ECDSAWithSHA512: crypto.SHA512,
+	}
+
+	hash, ok := hashes[signatureAlgorithm]
+	if !ok {
+		return signer.Sign(rand.Reader, message, crypto.Hash(0))
+	}
+
+	digest := hash.New()
+	digest.Write(message)
+	return signer.Sign(rand.Reader, digest.Sum(nil), hash)
+}
+
+//Returns an unsuccessful response, which carries no response bytes
+func ocspStatusResponse(status int) []byte {
+	response, _ := asn1.Marshal(ocspResponse{Status: asn1.Enumerated(status)})
+	return response
+}
+
+//Returns the entry with the given serial number
+func findDatabaseEntry(entries []databaseEntry, serialNumber string) (databaseEntry, bool) {
+	for _, entry := range entries {
+		if entry.serialNumber == serialNumber {
+			return entry, true
+		}
+	}
+	return databaseEntry{}, false
+}
+
+//Returns the OCSP responder for certificates issued by the intermediate authority, routed at the path of the ocsp url.
+//Responses are signed by a dedicated OCSP signing certificate in output/ocsp_responder, which is issued by the
+//intermediate authority when missing or about to expire. Clients may cache each response for responseValidity.
+func (ca *CA) OCSPHandler(responseValidity time.Duration) (http.Handler, error) {
+	err := ca.CheckAuthorities()
+	if err != nil {
+		return nil, err
+	}
+
+	ca.mutex.Lock()
+	err = ca.makeOCSPResponderCertificate()
+	ca.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	responder, err := newOCSPResponder(ca, ca.fragments["intermediateAuthorityCertificate"], ca.fragments["intermediateAuthorityDatabase"], ca.fragments["ocspResponderCertificate"], ca.fragments["ocspResponderPrivateKey"], responseValidity)
+	if err != nil {
+		return nil, err
+	}
+
+	//The url was validated with the options. The responder matches its path itself, since a ServeMux would redirect
+	//requests whose base64 contains two slashes in a row.
+	ocspURL, _ := url.Parse(ca.options.OCSP.URL)
+	responder.path = strings.TrimSuffix(ocspURL.EscapedPath(), "/")
+	return responder, nil
+}
+
+//Generates the key and certificate the OCSP responder signs its responses with. The certificate is issued by the
+//intermediate authority for OCSP signing only, and is regenerated once it has less than a day left.
+func (ca *CA) makeOCSPResponderCertificate() error {
+	if !fileExists(ca.fragments["ocspResponderDirectory"]) {
+		fmt.Fprintln(ca.log, "Generating directory: "+ca.fragments["ocspResponderDirectory"])
+		err := os.Mkdir(ca.fragments["ocspResponderDirectory"], 0700)
+		if err != nil {
+			return classify(err, IOError)
+		}
+	}
+
+	err := ca.makePrivateKey(ca.fragments["ocspResponderPrivateKey"], ca.fragments["ocspResponderKeyAlgorithm"], "none", ca.fragments["ocspResponderKeyAlgorithmRecord"])
+	if err != nil {
+		return err
+	}
+
+	if !fileExists(ca.fragments["ocspResponderCSRConfig"]) {
+		err = ca.hydrateTemplate(ca.fragments["ocspResponderCSRConfigTemplate"], ca.fragments["ocspResponderCSRConfig"], ca.fragments["intermediateAuthorityCommonName"]+" OCSP Responder")
+		if err != nil {
+			return err
+		}
+	}
+
+	if !fileExists(ca.fragments["ocspResponderConfig"]) {
+		err = ca.hydrateTemplate(ca.fragments["ocspResponderConfigTemplate"], ca.fragments["ocspResponderConfig"], ca.fragments["intermediateAuthorityDatabase"], ca.fragments["intermediateAuthoritySerialNumber"], ca.fragments["ocspResponderValidityDays"])
+		if err != nil {
+			return err
+		}
+	}
+
+	if !fileExists(ca.fragments["ocspResponderCSR"]) {
+		err = ca.generateCertificateSigningRequest(ca.fragments["ocspResponderPrivateKey"], ca.fragments["ocspResponderCSR"], ca.fragments["ocspResponderCSRConfig"])
+		if err != nil {
+			return err
+		}
+	}
+
+	if fileExists(ca.fragments["ocspResponderCertificate"]) {
+		certificate, err := readCertificate(ca.fragments["ocspResponderCertificate"])
+		if err != nil {
+			return err
+		}
+		if time.Until(certificate.NotAfter) > 24*time.Hour {
+			return nil
+		}
+	}
+
+	fmt.Fprintln(ca.log, "Generating OCSP responder certificate: "+ca.fragments["ocspResponderCertificate"])
+	return ca.generateSignedCertificate(ca.fragments["ocspResponderCSR"], ca.fragments["ocspResponderCertificate"], ca.fragments["ocspResponderConfig"], ca.fragments["intermediateAuthorityPrivateKey"], ca.fragments["intermediateAuthorityCertificate"], ca.fragments["ocspResponderDirectory"])
+}
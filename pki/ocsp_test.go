@@ -0,0 +1,173 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+//Returns the DER of a request about serialNumber, as issued by issuer, with a nonce of 0xff bytes that puts
+//slashes in its base64
+func newOCSPTestRequest(t *testing.T, issuer *x509.Certificate, serialNumber *big.Int) []byte {
+	t.Helper()
+	issuerPublicKey, err := subjectPublicKeyBits(issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuerNameHash := sha1.Sum(issuer.RawSubject)
+	issuerKeyHash := sha1.Sum(issuerPublicKey)
+	nonce, err := asn1.Marshal(bytes.Repeat([]byte{0xff}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := asn1.Marshal(ocspRequest{ocspTBSRequest{
+		RequestList: []ocspSingleRequest{{ocspCertID{
+			HashAlgorithm:  pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}, Parameters: asn1.NullRawValue},
+			IssuerNameHash: issuerNameHash[:],
+			IssuerKeyHash:  issuerKeyHash[:],
+			SerialNumber:   serialNumber,
+		}}},
+		Extensions: []pkix.Extension{{Id: oidOCSPNonce, Value: nonce}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return request
+}
+
+//Returns the status of an OCSP response, and the single response of a successful one once its signature has been
+//checked against responder
+func readOCSPTestResponse(t *testing.T, der []byte, responder *x509.Certificate) (int, ocspSingleResponse) {
+	t.Helper()
+	var response ocspResponse
+	_, err := asn1.Unmarshal(der, &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != ocspSuccessful {
+		return int(response.Status), ocspSingleResponse{}
+	}
+
+	var basic struct {
+		TBSResponseData    asn1.RawValue
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		Signature          asn1.BitString
+		Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+	}
+	_, err = asn1.Unmarshal(response.ResponseBytes.Response, &basic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = responder.CheckSignature(x509.ECDSAWithSHA256, basic.TBSResponseData.FullBytes, basic.Signature.RightAlign())
+	if err != nil {
+		t.Fatalf("the response isn't signed by the responder: %v", err)
+	}
+
+	var responseData ocspResponseData
+	_, err = asn1.Unmarshal(basic.TBSResponseData.FullBytes, &responseData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responseData.Responses) != 1 || len(responseData.Extensions) != 1 || !responseData.Extensions[0].Id.Equal(oidOCSPNonce) {
+		t.Fatalf("the response holds %d responses and the extensions %v, expected one response and the nonce", len(responseData.Responses), responseData.Extensions)
+	}
+	return ocspSuccessful, responseData.Responses[0]
+}
+
+func TestOCSPHandler(t *testing.T) {
+	ca := newTestCA(t, nil)
+	leaf, err := ca.IssueServer(newTestServer("example.test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := ca.OCSPHandler(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer, err := readCertificate(ca.IntermediateCertificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder, err := readCertificate(ca.fragments["ocspResponderCertificate"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := readCertificate(leaf.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := newOCSPTestRequest(t, issuer, certificate.SerialNumber)
+	encoded := base64.StdEncoding.EncodeToString(request)
+	if !strings.Contains(encoded, "//") {
+		t.Fatalf("the base64 of the request, %s, has no slashes in a row", encoded)
+	}
+
+	send := func(method, target string, body []byte) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, target, bytes.NewReader(body)))
+		return recorder
+	}
+	for name, recorder := range map[string]*httptest.ResponseRecorder{
+		"POST":                         send(http.MethodPost, "/ocsp", request),
+		"GET":                          send(http.MethodGet, "/ocsp/"+encoded, nil),
+		"GET with the slashes escaped": send(http.MethodGet, "/ocsp/"+url.PathEscape(encoded), nil),
+		"GET with everything escaped":  send(http.MethodGet, "/ocsp/"+url.QueryEscape(encoded), nil),
+	} {
+		if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/ocsp-response" {
+			t.Errorf("%s returned %d %s", name, recorder.Code, recorder.Header().Get("Content-Type"))
+			continue
+		}
+		status, single := readOCSPTestResponse(t, recorder.Body.Bytes(), responder)
+		if status != ocspSuccessful || !bool(single.Good) || single.CertID.SerialNumber.Cmp(certificate.SerialNumber) != 0 {
+			t.Errorf("%s returned the status %d and the response %+v, expected the certificate to be good", name, status, single)
+		}
+	}
+
+	err = leaf.Revoke("keyCompromise")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, single := readOCSPTestResponse(t, send(http.MethodGet, "/ocsp/"+encoded, nil).Body.Bytes(), responder)
+	if status != ocspSuccessful || bool(single.Good) || single.Revoked.RevocationTime.IsZero() || single.Revoked.Reason != 1 {
+		t.Errorf("GET of a revoked certificate returned the status %d and the response %+v, expected it to be revoked for keyCompromise", status, single)
+	}
+
+	status, single = readOCSPTestResponse(t, send(http.MethodGet, "/ocsp/"+base64.StdEncoding.EncodeToString(newOCSPTestRequest(t, issuer, big.NewInt(1))), nil).Body.Bytes(), responder)
+	if status != ocspSuccessful || !bool(single.Unknown) {
+		t.Errorf("GET of a certificate missing from the database returned the status %d and the response %+v, expected it to be unknown", status, single)
+	}
+
+	status, _ = readOCSPTestResponse(t, send(http.MethodGet, "/ocsp/"+base64.StdEncoding.EncodeToString(newOCSPTestRequest(t, responder, big.NewInt(1))), nil).Body.Bytes(), responder)
+	if status != ocspUnauthorized {
+		t.Errorf("GET of a certificate of another issuer returned the status %d, expected unauthorized", status)
+	}
+
+	for _, target := range []string{"/ocsp/" + encoded[:len(encoded)-4], "/ocsp/not-base64!", "/ocsp"} {
+		status, _ := readOCSPTestResponse(t, send(http.MethodGet, target, nil).Body.Bytes(), responder)
+		if status != ocspMalformedRequest {
+			t.Errorf("GET %s returned the status %d, expected a malformed request", target, status)
+		}
+	}
+
+	for _, target := range []string{"/", "/ocspx/" + encoded, "/other/" + encoded} {
+		if recorder := send(http.MethodGet, target, nil); recorder.Code != http.StatusNotFound {
+			t.Errorf("GET %s returned %d, expected the responder not to be found there", target, recorder.Code)
+		}
+	}
+	if recorder := send(http.MethodPut, "/ocsp", request); recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT returned %d, expected the method not to be allowed", recorder.Code)
+	}
+}
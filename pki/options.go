@@ -0,0 +1,688 @@
+package pki
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+//The certificate hierarchy a CA generates. It starts out as DefaultOptions, and can be overlaid with a pki.toml
+//configuration file with Load. TemplatesDirectory holds the openssl configuration templates, the built-in ones
+//when it is empty. RootDirectory holds the root authority's key, database and serial numbers instead of
+//OutputDirectory/root_authority, so that the root can be kept offline, for example on a removable drive: only Init,
+//RevokeIntermediate and UpdateRootCRL need it, and they fail when it is not attached. Log receives the progress
+//messages of the CA, which are discarded when it is nil. Passphrase is asked for the passphrase of an encrypted
+//authority key the first time the key is generated or needed to sign. Intermediates are named intermediate authorities
+//signed by the same root next to the default Intermediate, which servers and clients can be issued from instead.
+//SerialNumberRecord is the file recording the random serial number of every certificate signed, so that none is used
+//twice, OutputDirectory/serial_numbers.txt when it is empty. CAs sharing a record never issue the same serial number,
+//even when one of them is deleted and created again.
+type Options struct {
+	OutputDirectory    string
+	TemplatesDirectory string
+	RootDirectory      string
+	SerialNumberRecord string
+	Backend            string
+	Root               AuthorityOptions
+	Intermediate       AuthorityOptions
+	Intermediates      []IntermediateOptions
+	Servers            []ServerOptions
+	Clients            []ClientOptions
+	OCSP               OCSPOptions
+	Publish            PublishOptions
+	ACME               ACMEOptions
+	Renew              RenewOptions
+	Kubernetes         KubernetesOptions
+	Log                io.Writer
+	Passphrase         PassphraseFunc
+}
+
+//The subject, lifetime and key algorithm of the root or intermediate authority, and the lifetime of its CRLs.
+//PermittedNames become the critical nameConstraints of its certificate: DNS suffixes such as test, which covers
+//test and every name under it, or .test, which only covers names under it, and IP ranges such as 10.0.0.0/8.
+//KeyEncryption is one of KeyEncryptions, and decides how a new key is written.
+type AuthorityOptions struct {
+	CommonName      string
+	ValidityDays    int
+	KeyAlgorithm    string
+	KeyEncryption   string
+	CRLValidityDays int
+	PermittedNames  []string
+}
+
+//A named intermediate authority, kept in output/intermediates/<name> with its own key, database, serial numbers,
+//CRL and name constraints. The name is what servers and clients select it by.
+type IntermediateOptions struct {
+	Name string
+	AuthorityOptions
+}
+
+//One server certificate. The domain is the certificate's common name, and names the directory inside the output
+//directory unless Directory is set, for example to keep an RSA and an ECDSA certificate for the same domain. The
+//certificate covers the domain, 127.0.0.1 and the extra names. Intermediate names the intermediate authority that
+//signs it, the default one when empty.
+type ServerOptions struct {
+	Domain       string
+	Names        []string
+	ValidityDays int
+	KeyAlgorithm string
+	Intermediate string
+	Directory    string
+}
+
+//One client certificate for mutual TLS. The name is the certificate's common name, naming a user or service, and
+//the directory inside output/clients. The optional names are email addresses and URIs such as spiffe:// IDs.
+//Intermediate names the intermediate authority that signs it, the default one when empty.
+type ClientOptions struct {
+	Name         string
+	Names        []string
+	ValidityDays int
+	KeyAlgorithm string
+	Intermediate string
+}
+
+//The OCSP responder returned by OCSPHandler. When AddToCertificates is set, issued server certificates
+//carry URL in their authorityInfoAccess extension. Listen defaults to the host and port of URL.
+type OCSPOptions struct {
+	URL               string
+	Listen            string
+	KeyAlgorithm      string
+	ValidityDays      int
+	AddToCertificates bool
+}
+
+//The publication server returned by PublicationHandler, which serves the authorities' certificates and CRLs under URL.
+//When AddToCertificates is set, the intermediate and server certificates carry the matching caIssuers
+//and CRL distribution point URLs. Listen defaults to the host and port of URL.
+type PublishOptions struct {
+	URL               string
+	Listen            string
+	AddToCertificates bool
+}
+
+//The ACME server returned by ACMEHandler. Its directory is URL/directory. With AutoApprove, orders for names in
+//LocalDomains and for private addresses are approved without validation. http-01 challenges are fetched from
+//HTTPPort and dns-01 records are looked up through DNSResolver, or the system resolver when it is empty.
+type ACMEOptions struct {
+	URL          string
+	Listen       string
+	ValidityDays int
+	AutoApprove  bool
+	LocalDomains []string
+	HTTPPort     int
+	DNSResolver  string
+}
+
+//How Renew picks the certificates it reissues: those that expire within ThresholdDays. With RotateKeys, they also get new keys.
+type RenewOptions struct {
+	ThresholdDays int
+	RotateKeys    bool
+}
+
+//The Kubernetes manifests returned by KubernetesSecret and KubernetesIssuer. Secrets and Issuers are created in
+//Namespace. IssuerKind is Issuer or ClusterIssuer, which cert-manager reads its Secret from the cluster resource
+//namespace for, ClusterResourceNamespace. IssuerName names the issuer, generate-certificates when it is empty, followed
+//by - and the name of a named intermediate. CASecretName names the Secret holding the intermediate authority's key,
+//the issuer's name followed by -ca when it is empty.
+type KubernetesOptions struct {
+	Namespace                string
+	IssuerKind               string
+	IssuerName               string
+	CASecretName             string
+	ClusterResourceNamespace string
+}
+
+const defaultServerValidityDays = 397
+
+const defaultClientValidityDays = 365
+
+//Returns the options used when nothing else is configured
+func DefaultOptions() Options {
+	return Options{
+		OutputDirectory: "output",
+		Backend:         "native",
+		Root:            AuthorityOptions{CommonName: "Root Authority Name", ValidityDays: 3650, KeyAlgorithm: "rsa2048", KeyEncryption: "none", CRLValidityDays: 1},
+		Intermediate:    AuthorityOptions{CommonName: "Intermediate Certificate Authority", ValidityDays: 398, KeyAlgorithm: "rsa2048", KeyEncryption: "none", CRLValidityDays: 1},
+		OCSP:            OCSPOptions{URL: "http://127.0.0.1:8082/ocsp", KeyAlgorithm: "rsa2048", ValidityDays: 30},
+		Publish:         PublishOptions{URL: "http://127.0.0.1:8083"},
+		Renew:           RenewOptions{ThresholdDays: 30},
+		Kubernetes:      KubernetesOptions{Namespace: "default", IssuerKind: "Issuer", ClusterResourceNamespace: "cert-manager"},
+		ACME: ACMEOptions{
+			URL:          "https://localhost:8443/acme",
+			ValidityDays: 90,
+			LocalDomains: []string{"localhost", "test", "internal", "local", "home.arpa", "example", "invalid"},
+			HTTPPort:     80,
+		},
+	}
+}
+
+//Returns a server with the default lifetime and key algorithm
+func NewServerOptions(domain string, names []string) ServerOptions {
+	return ServerOptions{Domain: domain, Names: names, ValidityDays: defaultServerValidityDays, KeyAlgorithm: "rsa2048"}
+}
+
+//Returns a client with the default lifetime and key algorithm
+func NewClientOptions(name string, names []string) ClientOptions {
+	return ClientOptions{Name: name, Names: names, ValidityDays: defaultClientValidityDays, KeyAlgorithm: "rsa2048"}
+}
+
+//Returns a named intermediate authority with the settings of defaults, usually Options.Intermediate, and a common
+//name made of the default common name followed by the name
+func NewIntermediateOptions(name string, defaults AuthorityOptions) IntermediateOptions {
+	defaults.CommonName += " " + name
+	defaults.PermittedNames = slices.Clone(defaults.PermittedNames)
+	return IntermediateOptions{Name: name, AuthorityOptions: defaults}
+}
+
+//Overlays the values in a pki.toml file on options. Keys that are not part of the format are rejected so that typos are caught.
+func (options *Options) Load(filename string) (err error) {
+	defer func() { err = classify(err, InvalidError) }()
+
+	document, err := readToml(filename)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range document {
+		switch key {
+		case "root", "intermediate", "intermediates", "server", "client", "ocsp", "publish", "acme", "renew", "kubernetes":
+		default:
+			if _, isTable := value.(map[string]any); isTable {
+				return fmt.Errorf("%s: unknown table [%s]", filename, key)
+			}
+		}
+	}
+
+	err = decodeTomlTable(document, filename, map[string]any{
+		"output_directory":     &options.OutputDirectory,
+		"templates_directory":  &options.TemplatesDirectory,
+		"root_directory":       &options.RootDirectory,
+		"serial_number_record": &options.SerialNumberRecord,
+		"backend":              &options.Backend,
+	}, "root", "intermediate", "intermediates", "server", "client", "ocsp", "publish", "acme", "renew", "kubernetes")
+	if err != nil {
+		return err
+	}
+
+	if table, found := document["ocsp"]; found {
+		tableValues, ok := table.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: ocsp must be an [ocsp] table", filename)
+		}
+
+		err = decodeTomlTable(tableValues, filename+" [ocsp]", map[string]any{
+			"url":                 &options.OCSP.URL,
+			"listen":              &options.OCSP.Listen,
+			"key":                 &options.OCSP.KeyAlgorithm,
+			"validity_days":       &options.OCSP.ValidityDays,
+			"add_to_certificates": &options.OCSP.AddToCertificates,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if table, found := document["publish"]; found {
+		tableValues, ok := table.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: publish must be a [publish] table", filename)
+		}
+
+		err = decodeTomlTable(tableValues, filename+" [publish]", map[string]any{
+			"url":                 &options.Publish.URL,
+			"listen":              &options.Publish.Listen,
+			"add_to_certificates": &options.Publish.AddToCertificates,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if table, found := document["acme"]; found {
+		tableValues, ok := table.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: acme must be an [acme] table", filename)
+		}
+
+		err = decodeTomlTable(tableValues, filename+" [acme]", map[string]any{
+			"url":           &options.ACME.URL,
+			"listen":        &options.ACME.Listen,
+			"validity_days": &options.ACME.ValidityDays,
+			"auto_approve":  &options.ACME.AutoApprove,
+			"local_domains": &options.ACME.LocalDomains,
+			"http_port":     &options.ACME.HTTPPort,
+			"dns_resolver":  &options.ACME.DNSResolver,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if table, found := document["renew"]; found {
+		tableValues, ok := table.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: renew must be a [renew] table", filename)
+		}
+
+		err = decodeTomlTable(tableValues, filename+" [renew]", map[string]any{
+			"threshold_days": &options.Renew.ThresholdDays,
+			"rotate_keys":    &options.Renew.RotateKeys,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if table, found := document["kubernetes"]; found {
+		tableValues, ok := table.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: kubernetes must be a [kubernetes] table", filename)
+		}
+
+		err = decodeTomlTable(tableValues, filename+" [kubernetes]", map[string]any{
+			"namespace":                  &options.Kubernetes.Namespace,
+			"issuer_kind":                &options.Kubernetes.IssuerKind,
+			"issuer":                     &options.Kubernetes.IssuerName,
+			"ca_secret":                  &options.Kubernetes.CASecretName,
+			"cluster_resource_namespace": &options.Kubernetes.ClusterResourceNamespace,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, authority := range []struct {
+		name    string
+		options *AuthorityOptions
+	}{{"root", &options.Root}, {"intermediate", &options.Intermediate}} {
+		table, found := document[authority.name]
+		if !found {
+			continue
+		}
+
+		tableValues, ok := table.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %s must be a [%s] table", filename, authority.name, authority.name)
+		}
+
+		err = decodeTomlTable(tableValues, filename+" ["+authority.name+"]", map[string]any{
+			"common_name":     &authority.options.CommonName,
+			"validity_days":   &authority.options.ValidityDays,
+			"key":             &authority.options.KeyAlgorithm,
+			"key_encryption":  &authority.options.KeyEncryption,
+			"crl_days":        &authority.options.CRLValidityDays,
+			"permitted_names": &authority.options.PermittedNames,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	//Named intermediates start out with the settings of the [intermediate] table
+	if intermediates, found := document["intermediates"]; found {
+		intermediateTables, ok := intermediates.([]map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: named intermediates must be listed as [[intermediates]] tables", filename)
+		}
+
+		for index, table := range intermediateTables {
+			name, _ := table["name"].(string)
+			intermediate := NewIntermediateOptions(name, options.Intermediate)
+			err = decodeTomlTable(table, fmt.Sprintf("%s [[intermediates]] %d", filename, index+1), map[string]any{
+				"name":            &intermediate.Name,
+				"common_name":     &intermediate.CommonName,
+				"validity_days":   &intermediate.ValidityDays,
+				"key":             &intermediate.KeyAlgorithm,
+				"key_encryption":  &intermediate.KeyEncryption,
+				"crl_days":        &intermediate.CRLValidityDays,
+				"permitted_names": &intermediate.PermittedNames,
+			})
+			if err != nil {
+				return err
+			}
+			options.Intermediates = append(options.Intermediates, intermediate)
+		}
+	}
+
+	options.Servers, err = decodeServerTables(document, filename, options.Servers)
+	if err != nil {
+		return err
+	}
+
+	options.Clients, err = decodeClientTables(document, filename, options.Clients)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//Appends the servers listed as [[server]] tables in document, read from filename, to servers
+func decodeServerTables(document map[string]any, filename string, servers []ServerOptions) ([]ServerOptions, error) {
+	tables, found := document["server"]
+	if !found {
+		return servers, nil
+	}
+
+	serverTables, ok := tables.([]map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: servers must be listed as [[server]] tables", filename)
+	}
+
+	for index, table := range serverTables {
+		server := NewServerOptions("", nil)
+		err := decodeTomlTable(table, fmt.Sprintf("%s [[server]] %d", filename, index+1), map[string]any{
+			"domain":        &server.Domain,
+			"names":         &server.Names,
+			"validity_days": &server.ValidityDays,
+			"key":           &server.KeyAlgorithm,
+			"intermediate":  &server.Intermediate,
+			"directory":     &server.Directory,
+		})
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+//Appends the clients listed as [[client]] tables in document, read from filename, to clients
+func decodeClientTables(document map[string]any, filename string, clients []ClientOptions) ([]ClientOptions, error) {
+	tables, found := document["client"]
+	if !found {
+		return clients, nil
+	}
+
+	clientTables, ok := tables.([]map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: clients must be listed as [[client]] tables", filename)
+	}
+
+	for index, table := range clientTables {
+		client := NewClientOptions("", nil)
+		err := decodeTomlTable(table, fmt.Sprintf("%s [[client]] %d", filename, index+1), map[string]any{
+			"name":          &client.Name,
+			"names":         &client.Names,
+			"validity_days": &client.ValidityDays,
+			"key":           &client.KeyAlgorithm,
+			"intermediate":  &client.Intermediate,
+		})
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+//Checks every value before anything is generated
+func (options Options) Validate() (err error) {
+	defer func() { err = classify(err, InvalidError) }()
+
+	if options.OutputDirectory == "" {
+		return errors.New("output_directory must not be empty")
+	}
+
+	if options.TemplatesDirectory != "" {
+		info, err := os.Stat(options.TemplatesDirectory)
+		if err != nil || !info.IsDir() {
+			return fmt.Errorf("templates_directory %s is not a directory", options.TemplatesDirectory)
+		}
+	}
+
+	_, err = backendFromName(options.Backend, io.Discard, nil)
+	if err != nil {
+		return err
+	}
+
+	authorities := []struct {
+		name    string
+		options AuthorityOptions
+	}{{"root", options.Root}, {"intermediate", options.Intermediate}}
+	intermediates := map[string]bool{}
+	for _, intermediate := range options.Intermediates {
+		err = validateIntermediateName(intermediate.Name)
+		if err != nil {
+			return err
+		}
+
+		if intermediates[intermediate.Name] {
+			return fmt.Errorf("the intermediate %s is listed more than once", intermediate.Name)
+		}
+		intermediates[intermediate.Name] = true
+		authorities = append(authorities, struct {
+			name    string
+			options AuthorityOptions
+		}{"intermediate " + intermediate.Name, intermediate.AuthorityOptions})
+	}
+
+	for _, authority := range authorities {
+		if authority.options.CommonName == "" {
+			return fmt.Errorf("the %s common_name must not be empty", authority.name)
+		}
+
+		//The common name is written into an openssl configuration file, where these characters have special meanings
+		if strings.ContainsAny(authority.options.CommonName, "#\"'\\$\n") {
+			return fmt.Errorf("the %s common_name must not contain #, quotes, backslashes, $ or line breaks", authority.name)
+		}
+
+		if authority.options.ValidityDays <= 0 {
+			return fmt.Errorf("the %s validity_days must be positive", authority.name)
+		}
+
+		if authority.options.CRLValidityDays <= 0 {
+			return fmt.Errorf("the %s crl_days must be positive", authority.name)
+		}
+
+		err = validateKeyAlgorithm(authority.options.KeyAlgorithm)
+		if err != nil {
+			return fmt.Errorf("%s key: %w", authority.name, err)
+		}
+
+		err = validateKeyEncryption(authority.options.KeyEncryption)
+		if err != nil {
+			return fmt.Errorf("%s key_encryption: %w", authority.name, err)
+		}
+
+		for _, name := range authority.options.PermittedNames {
+			_, _, err = net.ParseCIDR(name)
+			if err == nil {
+				continue
+			}
+
+			nameType, err := subjectAlternativeNameType(strings.TrimPrefix(name, "."))
+			if err != nil || nameType != "DNS" || strings.HasPrefix(name, "*") {
+				return fmt.Errorf("invalid %s permitted_names entry %q: expected a DNS suffix such as test or .test, or an IP range such as 10.0.0.0/8", authority.name, name)
+			}
+		}
+	}
+
+	ocspURL, err := url.Parse(options.OCSP.URL)
+	if err != nil || ocspURL.Scheme != "http" || ocspURL.Host == "" {
+		return fmt.Errorf("the ocsp url %q must be an http:// URL", options.OCSP.URL)
+	}
+
+	publishURL, err := url.Parse(options.Publish.URL)
+	if err != nil || publishURL.Scheme != "http" || publishURL.Host == "" || publishURL.RawQuery != "" {
+		return fmt.Errorf("the publish url %q must be an http:// URL without a query", options.Publish.URL)
+	}
+
+	acmeURL, err := url.Parse(options.ACME.URL)
+	if err != nil || (acmeURL.Scheme != "https" && acmeURL.Scheme != "http") || acmeURL.Host == "" || acmeURL.RawQuery != "" {
+		return fmt.Errorf("the acme url %q must be an https:// or http:// URL without a query", options.ACME.URL)
+	}
+
+	if options.ACME.ValidityDays <= 0 {
+		return errors.New("the acme validity_days must be positive")
+	}
+
+	if options.ACME.HTTPPort <= 0 || options.ACME.HTTPPort > 65535 {
+		return fmt.Errorf("invalid acme http_port %d", options.ACME.HTTPPort)
+	}
+
+	if options.Renew.ThresholdDays <= 0 {
+		return errors.New("the renew threshold_days must be positive")
+	}
+
+	if options.OCSP.ValidityDays <= 0 {
+		return errors.New("the ocsp validity_days must be positive")
+	}
+
+	err = validateKeyAlgorithm(options.OCSP.KeyAlgorithm)
+	if err != nil {
+		return fmt.Errorf("ocsp key: %w", err)
+	}
+
+	err = options.Kubernetes.validate()
+	if err != nil {
+		return err
+	}
+
+	return Manifest{options.Servers, options.Clients}.Validate()
+}
+
+//Checks the domain, directory, lifetime, key algorithm and names of one server
+func (server ServerOptions) validate() error {
+	if server.Domain == "" || strings.ContainsAny(server.Domain, "/\\") {
+		return fmt.Errorf("invalid server domain %q", server.Domain)
+	}
+
+	if strings.ContainsAny(server.Directory, "/\\ ") || server.Directory == "." || server.Directory == ".." {
+		return fmt.Errorf("invalid directory %q of %s: it must not contain slashes or spaces", server.Directory, server.Domain)
+	}
+
+	if server.ValidityDays <= 0 {
+		return fmt.Errorf("the validity_days of %s must be positive", server.Domain)
+	}
+
+	err := validateKeyAlgorithm(server.KeyAlgorithm)
+	if err != nil {
+		return fmt.Errorf("%s key: %w", server.Domain, err)
+	}
+
+	if server.Intermediate != "" {
+		err = validateIntermediateName(server.Intermediate)
+		if err != nil {
+			return fmt.Errorf("%s: %w", server.Domain, err)
+		}
+	}
+
+	for _, name := range server.SubjectAlternativeNames() {
+		_, err = subjectAlternativeNameType(name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", server.Domain, err)
+		}
+	}
+	return nil
+}
+
+//Checks the name, lifetime, key algorithm and names of one client
+func (client ClientOptions) validate() error {
+	//The name is a directory, a common name in an openssl configuration file and a path passed to openssl on a command line split on spaces
+	if client.Name == "" || strings.ContainsAny(client.Name, "/\\#\"'$ \t\n") || client.Name == "." || client.Name == ".." {
+		return fmt.Errorf("invalid client name %q: it must not contain spaces, slashes, #, quotes or $", client.Name)
+	}
+
+	if client.ValidityDays <= 0 {
+		return fmt.Errorf("the validity_days of client %s must be positive", client.Name)
+	}
+
+	err := validateKeyAlgorithm(client.KeyAlgorithm)
+	if err != nil {
+		return fmt.Errorf("client %s key: %w", client.Name, err)
+	}
+
+	if client.Intermediate != "" {
+		err = validateIntermediateName(client.Intermediate)
+		if err != nil {
+			return fmt.Errorf("client %s: %w", client.Name, err)
+		}
+	}
+
+	for _, name := range client.Names {
+		_, err = subjectAlternativeNameType(name)
+		if err != nil {
+			return fmt.Errorf("client %s: %w", client.Name, err)
+		}
+	}
+	return nil
+}
+
+//Checks the name of a named intermediate, which names its directory in output/intermediates, the prefix of its
+//files on the publication server and the authority of its certificates in List
+func validateIntermediateName(name string) error {
+	if name == "" || name == "root" || name == "intermediate" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "-") {
+		return fmt.Errorf("invalid intermediate name %q", name)
+	}
+	for _, character := range name {
+		if !strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.", character) {
+			return fmt.Errorf("invalid intermediate name %q: it may only contain letters, digits, -, _ and .", name)
+		}
+	}
+	return nil
+}
+
+//Returns the named intermediate called name in the options, or one with the settings of Intermediate if it isn't listed
+func (options Options) NamedIntermediate(name string) IntermediateOptions {
+	for _, intermediate := range options.Intermediates {
+		if intermediate.Name == name {
+			return intermediate
+		}
+	}
+	return NewIntermediateOptions(name, options.Intermediate)
+}
+
+//Returns the server named domain in the options, or kept in the directory called domain, or a server with default
+//settings if it isn't listed
+func (options Options) Server(domain string) ServerOptions {
+	for _, server := range options.Servers {
+		if server.DirectoryName() == domain {
+			return server
+		}
+	}
+	return NewServerOptions(domain, nil)
+}
+
+//Returns the name of the directory of the server inside the output directory: Directory, or the domain when it is empty
+func (server ServerOptions) DirectoryName() string {
+	if server.Directory != "" {
+		return server.Directory
+	}
+	return server.Domain
+}
+
+//Returns the client called name in the options, or a client with default settings if it isn't listed
+func (options Options) Client(name string) ClientOptions {
+	for _, client := range options.Clients {
+		if client.Name == name {
+			return client
+		}
+	}
+	return NewClientOptions(name, nil)
+}
+
+//The names covered by the server certificate: the domain and 127.0.0.1, followed by the extra names in order, without duplicates
+func (server ServerOptions) SubjectAlternativeNames() []string {
+	names := []string{}
+	for _, name := range append([]string{server.Domain, "127.0.0.1"}, server.Names...) {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+//The names of the client certificate that name constraints apply to: its names, preceded by its common name
+//when that looks like a host name, since openssl checks such a common name against the DNS constraints
+func (client ClientOptions) ConstrainedNames() []string {
+	nameType, _ := subjectAlternativeNameType(client.Name)
+	if nameType == "DNS" && strings.Contains(client.Name, ".") {
+		return append([]string{client.Name}, client.Names...)
+	}
+	return client.Names
+}
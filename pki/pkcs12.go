@@ -0,0 +1,402 @@
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"slices"
+	"strings"
+	"unicode/utf16"
+)
+
+//PKCS#12 (RFC 7292) keystores. legacy encrypts with pbeWithSHAAnd3-KeyTripleDES-CBC and a SHA-1 MAC, which older
+//Java, .NET and Windows versions need. modern uses PBES2 with PBKDF2-HMAC-SHA256 and AES-256-CBC and a SHA-256 MAC,
+//as OpenSSL 3 does by default.
+var PKCS12Encryptions = []string{"modern", "legacy"}
+
+const pkcs12Iterations = 2048
+
+var (
+	oidPKCS7Data                     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7EncryptedData            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidPKCS8ShroudedKeyBag           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertificateBag                = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509CertificateType           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName                  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHAAnd3KeyTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBES2                         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2                        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256                = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC                     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidSHA1                          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256                        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	//Java only treats certificate entries of a PKCS#12 truststore as trusted when they carry this attribute,
+	//whose value lists the purposes they are trusted for
+	oidJavaTrustedKeyUsage = asn1.ObjectIdentifier{2, 16, 840, 1, 113894, 746875, 1, 1}
+	oidAnyExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+)
+
+type pkcs12PFX struct {
+	Version  int
+	AuthSafe pkcs12ContentInfo
+	MacData  pkcs12MacData
+}
+
+type pkcs12ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type pkcs12EncryptedData struct {
+	Version              int
+	EncryptedContentInfo pkcs12EncryptedContentInfo
+}
+
+type pkcs12EncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type pkcs12SafeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID     asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type pkcs12CertificateBag struct {
+	ID          asn1.ObjectIdentifier
+	Certificate []byte `asn1:"tag:0,explicit"`
+}
+
+type pkcs12EncryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pkcs12MacData struct {
+	Mac        pkcs12DigestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type pkcs12DigestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type pkcs12PBEParameters struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pbes2Parameters struct {
+	KeyDerivationFunction pkix.AlgorithmIdentifier
+	EncryptionScheme      pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Parameters struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+//Writes a PKCS#12 file holding the private key, if there is one, and the certificates. The first certificate is the
+//one matching the key, and is the only one given friendlyName. Without a key, every certificate is marked as trusted
+//for Java, so that the file can serve as a truststore.
+func writePKCS12(output string, privateKey crypto.Signer, certificates []*x509.Certificate, friendlyName, password, encryption string) error {
+	if !slices.Contains(PKCS12Encryptions, encryption) {
+		return fmt.Errorf("unknown PKCS#12 encryption %q, expected one of %s", encryption, strings.Join(PKCS12Encryptions, ", "))
+	}
+
+	certificateBags := []pkcs12SafeBag{}
+	for index, certificate := range certificates {
+		bag, err := asn1.Marshal(pkcs12CertificateBag{ID: oidX509CertificateType, Certificate: certificate.Raw})
+		if err != nil {
+			return err
+		}
+
+		attributes := []pkcs12Attribute{}
+		if index == 0 {
+			attributes = append(attributes, pkcs12FriendlyName(friendlyName))
+		}
+		if index == 0 && privateKey != nil {
+			attributes = append(attributes, pkcs12LocalKeyID(certificates[0]))
+		}
+		if privateKey == nil {
+			attributes = append(attributes, pkcs12SetAttribute(oidJavaTrustedKeyUsage, oidAnyExtendedKeyUsage))
+		}
+		certificateBags = append(certificateBags, pkcs12SafeBag{ID: oidCertificateBag, Value: pkcs12Explicit(bag), Attributes: attributes})
+	}
+
+	certificateContents, err := asn1.Marshal(certificateBags)
+	if err != nil {
+		return err
+	}
+
+	algorithm, encryptedCertificates, err := pkcs12Encrypt(encryption, password, certificateContents)
+	if err != nil {
+		return err
+	}
+
+	encryptedData, err := asn1.Marshal(pkcs12EncryptedData{EncryptedContentInfo: pkcs12EncryptedContentInfo{ContentType: oidPKCS7Data, ContentEncryptionAlgorithm: algorithm, EncryptedContent: encryptedCertificates}})
+	if err != nil {
+		return err
+	}
+	authenticatedSafe := []pkcs12ContentInfo{{ContentType: oidPKCS7EncryptedData, Content: pkcs12Explicit(encryptedData)}}
+
+	if privateKey != nil {
+		key, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return err
+		}
+
+		algorithm, encryptedKey, err := pkcs12Encrypt(encryption, password, key)
+		if err != nil {
+			return err
+		}
+
+		shroudedKey, err := asn1.Marshal(pkcs12EncryptedPrivateKeyInfo{Algorithm: algorithm, EncryptedData: encryptedKey})
+		if err != nil {
+			return err
+		}
+
+		keyContents, err := asn1.Marshal([]pkcs12SafeBag{{
+			ID:         oidPKCS8ShroudedKeyBag,
+			Value:      pkcs12Explicit(shroudedKey),
+			Attributes: []pkcs12Attribute{pkcs12FriendlyName(friendlyName), pkcs12LocalKeyID(certificates[0])},
+		}})
+		if err != nil {
+			return err
+		}
+
+		keyData, err := asn1.Marshal(keyContents)
+		if err != nil {
+			return err
+		}
+		authenticatedSafe = append(authenticatedSafe, pkcs12ContentInfo{ContentType: oidPKCS7Data, Content: pkcs12Explicit(keyData)})
+	}
+
+	authenticatedSafeDER, err := asn1.Marshal(authenticatedSafe)
+	if err != nil {
+		return err
+	}
+
+	authenticatedSafeData, err := asn1.Marshal(authenticatedSafeDER)
+	if err != nil {
+		return err
+	}
+
+	//The MAC covers the contents of the authenticated safe, keyed with the PKCS#12 key derivation of the password
+	macHash, macAlgorithm := sha256.New, oidSHA256
+	if encryption == "legacy" {
+		macHash, macAlgorithm = sha1.New, oidSHA1
+	}
+	macSalt := make([]byte, 8)
+	_, err = rand.Read(macSalt)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(macHash, pkcs12DeriveKey(macHash, password, macSalt, 3, pkcs12Iterations, macHash().Size()))
+	mac.Write(authenticatedSafeDER)
+
+	pfx, err := asn1.Marshal(pkcs12PFX{
+		Version:  3,
+		AuthSafe: pkcs12ContentInfo{ContentType: oidPKCS7Data, Content: pkcs12Explicit(authenticatedSafeData)},
+		MacData: pkcs12MacData{
+			Mac:        pkcs12DigestInfo{Algorithm: pkix.AlgorithmIdentifier{Algorithm: macAlgorithm, Parameters: asn1.NullRawValue}, Digest: mac.Sum(nil)},
+			MacSalt:    macSalt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(output, pfx, 0600)
+}
+
+//Wraps an encoded value in the [0] EXPLICIT tag that ContentInfo and SafeBag values carry. encoding/asn1 doesn't
+//add explicit tags around a RawValue, so the tag is built by hand.
+func pkcs12Explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+//Returns a bag attribute with a single value
+func pkcs12SetAttribute(id asn1.ObjectIdentifier, value any) pkcs12Attribute {
+	encoded, _ := asn1.Marshal(value)
+	return pkcs12Attribute{ID: id, Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: encoded}}
+}
+
+//The friendlyName attribute, which keystores show as the alias of an entry. It is a BMPString.
+func pkcs12FriendlyName(name string) pkcs12Attribute {
+	return pkcs12SetAttribute(oidFriendlyName, asn1.RawValue{Tag: asn1.TagBMPString, Bytes: bmpString(name, false)})
+}
+
+//The localKeyID attribute, which pairs a key with its certificate. Like OpenSSL, it is the SHA-1 hash of the certificate.
+func pkcs12LocalKeyID(certificate *x509.Certificate) pkcs12Attribute {
+	id := sha1.Sum(certificate.Raw)
+	return pkcs12SetAttribute(oidLocalKeyID, id[:])
+}
+
+//Returns text in UTF-16 big endian, optionally followed by two zero bytes as PKCS#12 passwords are
+func bmpString(text string, terminated bool) []byte {
+	encoded := []byte{}
+	for _, unit := range utf16.Encode([]rune(text)) {
+		encoded = append(encoded, byte(unit>>8), byte(unit))
+	}
+	if terminated {
+		encoded = append(encoded, 0, 0)
+	}
+	return encoded
+}
+
+//Encrypts data with a new random salt and returns the algorithm identifier describing how
+func pkcs12Encrypt(encryption, password string, data []byte) (pkix.AlgorithmIdentifier, []byte, error) {
+	salt := make([]byte, 8)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	var block cipher.Block
+	var iv []byte
+	var algorithm pkix.AlgorithmIdentifier
+	if encryption == "legacy" {
+		key := pkcs12DeriveKey(sha1.New, password, salt, 1, pkcs12Iterations, 24)
+		iv = pkcs12DeriveKey(sha1.New, password, salt, 2, pkcs12Iterations, 8)
+		block, err = des.NewTripleDESCipher(key)
+		if err != nil {
+			return algorithm, nil, err
+		}
+
+		parameters, err := asn1.Marshal(pkcs12PBEParameters{Salt: salt, Iterations: pkcs12Iterations})
+		if err != nil {
+			return algorithm, nil, err
+		}
+		algorithm = pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHAAnd3KeyTripleDESCBC, Parameters: asn1.RawValue{FullBytes: parameters}}
+	} else {
+		//PBES2 takes the password as UTF-8 rather than as a BMPString
+		key, err := pbkdf2.Key(sha256.New, password, salt, pkcs12Iterations, 32)
+		if err != nil {
+			return algorithm, nil, err
+		}
+
+		iv = make([]byte, aes.BlockSize)
+		_, err = rand.Read(iv)
+		if err != nil {
+			return algorithm, nil, err
+		}
+
+		block, err = aes.NewCipher(key)
+		if err != nil {
+			return algorithm, nil, err
+		}
+
+		algorithm, err = pbes2AlgorithmIdentifier(salt, pkcs12Iterations, iv)
+		if err != nil {
+			return algorithm, nil, err
+		}
+	}
+
+	//PKCS#7 padding always adds between one and a whole block of padding
+	padding := block.BlockSize() - len(data)%block.BlockSize()
+	padded := append(slices.Clone(data), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+	return algorithm, padded, nil
+}
+
+//Describes PBES2 encryption with a PBKDF2-HMAC-SHA256 key and AES-256-CBC
+func pbes2AlgorithmIdentifier(salt []byte, iterations int, iv []byte) (pkix.AlgorithmIdentifier, error) {
+	keyDerivation, err := asn1.Marshal(pbkdf2Parameters{Salt: salt, IterationCount: iterations, KeyLength: 32, PRF: pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue}})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+
+	ivParameter, err := asn1.Marshal(iv)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+
+	parameters, err := asn1.Marshal(pbes2Parameters{
+		KeyDerivationFunction: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: keyDerivation}},
+		EncryptionScheme:      pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParameter}},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: parameters}}, nil
+}
+
+//The key derivation of RFC 7292 appendix B. id is 1 for encryption keys, 2 for IVs and 3 for MAC keys.
+func pkcs12DeriveKey(newHash func() hash.Hash, password string, salt []byte, id byte, iterations, size int) []byte {
+	hashSize := newHash().Size()
+	blockSize := newHash().BlockSize()
+
+	//I is the salt and the password, each repeated to a whole number of blocks
+	repeat := func(data []byte) []byte {
+		if len(data) == 0 {
+			return nil
+		}
+		length := blockSize * ((len(data) + blockSize - 1) / blockSize)
+		repeated := make([]byte, length)
+		for index := range repeated {
+			repeated[index] = data[index%len(data)]
+		}
+		return repeated
+	}
+	passwordBytes := []byte(nil)
+	if password != "" {
+		passwordBytes = bmpString(password, true)
+	}
+	input := append(repeat(salt), repeat(passwordBytes)...)
+	diversifier := bytes.Repeat([]byte{id}, blockSize)
+
+	derived := []byte{}
+	for len(derived) < size {
+		hash := newHash()
+		hash.Write(diversifier)
+		hash.Write(input)
+		digest := hash.Sum(nil)
+		for iteration := 1; iteration < iterations; iteration++ {
+			hash.Reset()
+			hash.Write(digest)
+			digest = hash.Sum(nil)
+		}
+		derived = append(derived, digest...)
+
+		//Each block of I becomes I + B + 1, where B is the digest repeated to the block size
+		b := make([]byte, blockSize)
+		for index := range b {
+			b[index] = digest[index%hashSize]
+		}
+		for start := 0; start < len(input); start += blockSize {
+			carry := 1
+			for index := blockSize - 1; index >= 0; index-- {
+				sum := int(input[start+index]) + int(b[index]) + carry
+				input[start+index] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return derived[:size]
+}
@@ -0,0 +1,367 @@
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"hash"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+//Decodes hexadecimal with optional spaces, as the RFCs print their test vectors
+func decodeTestHex(t *testing.T, text string) []byte {
+	t.Helper()
+	decoded, err := hex.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+//The vectors of the PKCS#12 key derivation that OpenSSL and Bouncy Castle test against, and MAC keys checked with
+//openssl kdf PKCS12KDF
+func TestPKCS12DeriveKey(t *testing.T) {
+	tests := []struct {
+		newHash    func() hash.Hash
+		password   string
+		salt       string
+		id         byte
+		iterations int
+		expected   string
+	}{
+		{sha1.New, "smeg", "0A58CF64530D823F", 1, 1, "8AAAE6297B6CB04642AB5B077851284EB7128F1A2A7FBCA3"},
+		{sha1.New, "smeg", "0A58CF64530D823F", 2, 1, "79993DFE048D3B76"},
+		{sha1.New, "queeg", "05DEC959ACFF72F7", 1, 1000, "ED2034E36328830FF09DF1E1A07DD357185DAC0D4F9EB3D4"},
+		{sha1.New, "queeg", "05DEC959ACFF72F7", 2, 1000, "11DEDAD7758D4860"},
+		{sha1.New, "sesame", "0102030405060708", 3, 2048, "3BBE6444746190CAE0145A19AC887784FDBD4A6F"},
+		{sha256.New, "sesame", "0102030405060708", 3, 2048, "387E92981F3AF02830D01363708FDF6F85B057DCDB05DB4A9517F71109CB2D56"},
+	}
+	for _, test := range tests {
+		expected := decodeTestHex(t, test.expected)
+		key := pkcs12DeriveKey(test.newHash, test.password, decodeTestHex(t, test.salt), test.id, test.iterations, len(expected))
+		if !bytes.Equal(key, expected) {
+			t.Errorf("pkcs12DeriveKey(%q, %s, %d, %d) returned %X, expected %X", test.password, test.salt, test.id, test.iterations, key, expected)
+		}
+	}
+}
+
+//Returns a key and a chain of a leaf, an intermediate and a root certificate, each signed by the next
+func newPKCS12TestChain(t *testing.T) (crypto.Signer, []*x509.Certificate) {
+	t.Helper()
+	certificates := []*x509.Certificate{}
+	var issuer *x509.Certificate
+	var issuerKey crypto.Signer
+	var key *ecdsa.PrivateKey
+	for index, name := range []string{"Root", "Intermediate", "example.test"} {
+		var err error
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(int64(index + 1)),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now(),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  index < 2,
+			BasicConstraintsValid: true,
+		}
+		if issuer == nil {
+			issuer, issuerKey = template, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), issuerKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certificate, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certificates = append([]*x509.Certificate{certificate}, certificates...)
+		issuer, issuerKey = certificate, key
+	}
+	return key, certificates
+}
+
+//Decrypts the contents of a keystore encrypted as pkcs12Encrypt does
+func decryptPKCS12TestData(t *testing.T, algorithm pkix.AlgorithmIdentifier, password string, data []byte) []byte {
+	t.Helper()
+	var block cipher.Block
+	var iv []byte
+	var err error
+	switch {
+	case algorithm.Algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDESCBC):
+		var parameters pkcs12PBEParameters
+		_, err = asn1.Unmarshal(algorithm.Parameters.FullBytes, &parameters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iv = pkcs12DeriveKey(sha1.New, password, parameters.Salt, 2, parameters.Iterations, 8)
+		block, err = des.NewTripleDESCipher(pkcs12DeriveKey(sha1.New, password, parameters.Salt, 1, parameters.Iterations, 24))
+	case algorithm.Algorithm.Equal(oidPBES2):
+		var parameters pbes2Parameters
+		_, err = asn1.Unmarshal(algorithm.Parameters.FullBytes, &parameters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keyDerivation pbkdf2Parameters
+		_, err = asn1.Unmarshal(parameters.KeyDerivationFunction.Parameters.FullBytes, &keyDerivation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !keyDerivation.PRF.Algorithm.Equal(oidHMACWithSHA256) || !parameters.EncryptionScheme.Algorithm.Equal(oidAES256CBC) {
+			t.Fatalf("unexpected PBES2 algorithms %s and %s", keyDerivation.PRF.Algorithm, parameters.EncryptionScheme.Algorithm)
+		}
+		_, err = asn1.Unmarshal(parameters.EncryptionScheme.Parameters.FullBytes, &iv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var key []byte
+		key, err = pbkdf2.Key(sha256.New, password, keyDerivation.Salt, keyDerivation.IterationCount, 32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, err = aes.NewCipher(key)
+	default:
+		t.Fatalf("unexpected encryption %s", algorithm.Algorithm)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted := bytes.Clone(data)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, decrypted)
+	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > block.BlockSize() {
+		t.Fatalf("invalid padding %d", padding)
+	}
+	return decrypted[:len(decrypted)-padding]
+}
+
+//Returns the value of the attribute id of a bag, or nil
+func pkcs12TestAttribute(bag pkcs12SafeBag, id asn1.ObjectIdentifier) []byte {
+	for _, attribute := range bag.Attributes {
+		if attribute.ID.Equal(id) {
+			return attribute.Values.Bytes
+		}
+	}
+	return nil
+}
+
+//Returns the friendlyName attribute of a bag
+func pkcs12TestFriendlyName(t *testing.T, bag pkcs12SafeBag) string {
+	t.Helper()
+	encoded := pkcs12TestAttribute(bag, oidFriendlyName)
+	if encoded == nil {
+		return ""
+	}
+	var value asn1.RawValue
+	_, err := asn1.Unmarshal(encoded, &value)
+	if err != nil || value.Tag != asn1.TagBMPString {
+		t.Fatalf("invalid friendlyName %x", encoded)
+	}
+	units := []uint16{}
+	for index := 0; index+1 < len(value.Bytes); index += 2 {
+		units = append(units, uint16(value.Bytes[index])<<8|uint16(value.Bytes[index+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+//Parses a keystore written by writePKCS12, checking its MAC, and returns its certificate bags and its key bags
+func readPKCS12Test(t *testing.T, filename, password string, macAlgorithm asn1.ObjectIdentifier, macHash func() hash.Hash) ([]pkcs12SafeBag, []pkcs12SafeBag) {
+	t.Helper()
+	der, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pfx pkcs12PFX
+	_, err = asn1.Unmarshal(der, &pfx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pfx.Version != 3 || !pfx.AuthSafe.ContentType.Equal(oidPKCS7Data) {
+		t.Fatalf("unexpected version %d and content type %s", pfx.Version, pfx.AuthSafe.ContentType)
+	}
+
+	var authenticatedSafeDER []byte
+	_, err = asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authenticatedSafeDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !pfx.MacData.Mac.Algorithm.Algorithm.Equal(macAlgorithm) {
+		t.Errorf("the MAC uses %s, expected %s", pfx.MacData.Mac.Algorithm.Algorithm, macAlgorithm)
+	}
+	mac := hmac.New(macHash, pkcs12DeriveKey(macHash, password, pfx.MacData.MacSalt, 3, pfx.MacData.Iterations, macHash().Size()))
+	mac.Write(authenticatedSafeDER)
+	if !hmac.Equal(mac.Sum(nil), pfx.MacData.Mac.Digest) {
+		t.Errorf("the MAC doesn't match")
+	}
+
+	var authenticatedSafe []pkcs12ContentInfo
+	_, err = asn1.Unmarshal(authenticatedSafeDER, &authenticatedSafe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var certificateBags, keyBags []pkcs12SafeBag
+	for _, contentInfo := range authenticatedSafe {
+		var contents []byte
+		switch {
+		case contentInfo.ContentType.Equal(oidPKCS7EncryptedData):
+			var encryptedData pkcs12EncryptedData
+			_, err = asn1.Unmarshal(contentInfo.Content.Bytes, &encryptedData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info := encryptedData.EncryptedContentInfo
+			contents = decryptPKCS12TestData(t, info.ContentEncryptionAlgorithm, password, info.EncryptedContent)
+		case contentInfo.ContentType.Equal(oidPKCS7Data):
+			_, err = asn1.Unmarshal(contentInfo.Content.Bytes, &contents)
+			if err != nil {
+				t.Fatal(err)
+			}
+		default:
+			t.Fatalf("unexpected content type %s", contentInfo.ContentType)
+		}
+
+		var bags []pkcs12SafeBag
+		_, err = asn1.Unmarshal(contents, &bags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, bag := range bags {
+			switch {
+			case bag.ID.Equal(oidCertificateBag):
+				certificateBags = append(certificateBags, bag)
+			case bag.ID.Equal(oidPKCS8ShroudedKeyBag):
+				keyBags = append(keyBags, bag)
+			default:
+				t.Fatalf("unexpected bag %s", bag.ID)
+			}
+		}
+	}
+	return certificateBags, keyBags
+}
+
+func TestWritePKCS12(t *testing.T) {
+	key, chain := newPKCS12TestChain(t)
+	localKeyID := pkcs12LocalKeyID(chain[0]).Values.Bytes
+
+	for _, test := range []struct {
+		encryption   string
+		macAlgorithm asn1.ObjectIdentifier
+		macHash      func() hash.Hash
+	}{
+		{"legacy", oidSHA1, sha1.New},
+		{"modern", oidSHA256, sha256.New},
+	} {
+		t.Run(test.encryption, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "client.p12")
+			err := writePKCS12(filename, key, chain, "example.test", "sesame", test.encryption)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			certificateBags, keyBags := readPKCS12Test(t, filename, "sesame", test.macAlgorithm, test.macHash)
+			if len(certificateBags) != len(chain) {
+				t.Fatalf("the keystore holds %d certificates, expected %d", len(certificateBags), len(chain))
+			}
+			for index, bag := range certificateBags {
+				var certificateBag pkcs12CertificateBag
+				_, err = asn1.Unmarshal(bag.Value.Bytes, &certificateBag)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(certificateBag.Certificate, chain[index].Raw) {
+					t.Errorf("certificate %d is not %s", index, chain[index].Subject.CommonName)
+				}
+
+				name := pkcs12TestFriendlyName(t, bag)
+				if index == 0 && name != "example.test" || index > 0 && name != "" {
+					t.Errorf("certificate %d has the friendly name %q", index, name)
+				}
+				if index == 0 && !bytes.Equal(pkcs12TestAttribute(bag, oidLocalKeyID), localKeyID) {
+					t.Errorf("the first certificate doesn't have the localKeyID of the key")
+				}
+				if pkcs12TestAttribute(bag, oidJavaTrustedKeyUsage) != nil {
+					t.Errorf("certificate %d is marked as trusted in a keystore holding a key", index)
+				}
+			}
+
+			if len(keyBags) != 1 {
+				t.Fatalf("the keystore holds %d keys, expected 1", len(keyBags))
+			}
+			if name := pkcs12TestFriendlyName(t, keyBags[0]); name != "example.test" {
+				t.Errorf("the key has the friendly name %q", name)
+			}
+			if !bytes.Equal(pkcs12TestAttribute(keyBags[0], oidLocalKeyID), localKeyID) {
+				t.Errorf("the key doesn't have the localKeyID of the first certificate")
+			}
+
+			var shroudedKey pkcs12EncryptedPrivateKeyInfo
+			_, err = asn1.Unmarshal(keyBags[0].Value.Bytes, &shroudedKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := x509.ParsePKCS8PrivateKey(decryptPKCS12TestData(t, shroudedKey.Algorithm, "sesame", shroudedKey.EncryptedData))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !key.Public().(*ecdsa.PublicKey).Equal(decrypted.(crypto.Signer).Public()) {
+				t.Errorf("the keystore holds a different key")
+			}
+		})
+	}
+}
+
+func TestWritePKCS12Truststore(t *testing.T) {
+	_, chain := newPKCS12TestChain(t)
+	filename := filepath.Join(t.TempDir(), "truststore.p12")
+	err := writePKCS12(filename, nil, chain[1:], "root", "changeit", "modern")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificateBags, keyBags := readPKCS12Test(t, filename, "changeit", oidSHA256, sha256.New)
+	if len(certificateBags) != 2 || len(keyBags) != 0 {
+		t.Fatalf("the truststore holds %d certificates and %d keys, expected 2 certificates", len(certificateBags), len(keyBags))
+	}
+	for index, bag := range certificateBags {
+		if pkcs12TestAttribute(bag, oidJavaTrustedKeyUsage) == nil {
+			t.Errorf("certificate %d is not marked as trusted", index)
+		}
+		if pkcs12TestAttribute(bag, oidLocalKeyID) != nil {
+			t.Errorf("certificate %d has a localKeyID without a key", index)
+		}
+	}
+}
+
+func TestWritePKCS12UnknownEncryption(t *testing.T) {
+	key, chain := newPKCS12TestChain(t)
+	err := writePKCS12(filepath.Join(t.TempDir(), "client.p12"), key, chain, "example.test", "sesame", "rc2")
+	if err == nil {
+		t.Errorf("writePKCS12 accepted the encryption rc2")
+	}
+}
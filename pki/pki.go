@@ -0,0 +1,826 @@
+//Package pki creates a local certificate authority: a root authority, an intermediate authority signed by it, and the
+//server and client certificates the intermediate authority issues. Every file of a CA is kept in its output directory,
+//so several CAs with different output directories can be used side by side, from separate goroutines if need be.
+//
+//	ca, err := pki.New(pki.DefaultOptions())
+//	...
+//	err = ca.Init()
+//	...
+//	leaf, err := ca.IssueServer(pki.NewServerOptions("app.test", []string{"localhost"}))
+package pki
+
+import (
+	"bytes"
+	"crypto/x509"
+	"embed"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//The openssl configuration templates the authorities and certificates are made from, unless Options.TemplatesDirectory is set
+//
+//go:embed templates/*.conf
+var templates embed.FS
+
+//A CA is the root and intermediate authority kept in one output directory, along with the certificates they issued.
+//Its methods can be called from several goroutines. Those that sign or revoke certificates or write CRLs take turns,
+//because they update the databases and serial number files of the authorities, while the keys and CSRs of different
+//servers and clients are generated side by side.
+type CA struct {
+	options   Options
+	backend   certificateBackend
+	fragments map[string]string
+	log       io.Writer
+	mutex     sync.Mutex
+	//The passphrases of the authority keys, by key file, once they have unlocked the key
+	passphrases map[string]string
+	//The named intermediate authorities: those in the options followed by those found in output/intermediates
+	intermediates []IntermediateOptions
+	//The locks of the leaf directories, by directory, which keep two goroutines from writing the same leaf's key and CSR
+	leafMutexes map[string]*sync.Mutex
+}
+
+//Returns the CA described by options once they have been validated. Nothing is written until a method needs to,
+//so Init has to be called before certificates can be issued, unless the output directory already holds the authorities.
+func New(options Options) (*CA, error) {
+	err := options.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	log := options.Log
+	if log == nil {
+		log = io.Discard
+	}
+	ca := &CA{options: options, fragments: map[string]string{}, log: log, passphrases: map[string]string{}, intermediates: findIntermediates(options), leafMutexes: map[string]*sync.Mutex{}}
+	backend, _ := backendFromName(options.Backend, log, ca.keyPassphrase)
+	ca.initializeStringFragments()
+	ca.backend = classifiedBackend{serialNumberBackend{backend, ca.fragments["serialNumberRecord"]}}
+	return ca, nil
+}
+
+//Returns the options the CA was created with
+func (ca *CA) Options() Options {
+	return ca.options
+}
+
+//Returns the filename of the root authority's certificate, the one clients have to trust
+func (ca *CA) RootCertificate() string {
+	return ca.fragments["rootAuthorityCertificate"]
+}
+
+//Returns the filename of the default intermediate authority's certificate
+func (ca *CA) IntermediateCertificate() string {
+	return ca.fragments["intermediateAuthorityCertificate"]
+}
+
+//Creates the root authority, the default intermediate authority and the named intermediates in Options.Intermediates:
+//their directories, databases, keys, certificates and CRLs. Anything that already exists is kept, so calling Init again
+//changes nothing, unless a named intermediate was added to the options since.
+func (ca *CA) Init() error {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	//An offline root is only needed to create the root, to sign an intermediate and to write the first root CRL
+	root := !ca.rootOffline() || !fileExists(ca.fragments["rootAuthorityCertificate"]) || !fileExists(ca.fragments["rootAuthorityCRL"])
+	for _, authority := range ca.intermediateAuthorities() {
+		root = root || !fileExists(ca.fragments[authority+"Certificate"])
+	}
+	if root {
+		err := ca.checkRoot()
+		if err != nil {
+			return err
+		}
+	}
+
+	//Stage 2
+	err := ca.makeDirectories()
+	if err != nil {
+		return err
+	}
+	err = ca.makeDatabaseFiles(root)
+	if err != nil {
+		return err
+	}
+
+	//Stage 3
+	err = ca.makePrivateKeys(root)
+	if err != nil {
+		return err
+	}
+
+	//Stage 4.
+	if root {
+		err = ca.makeRootAuthorityCertificate()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, authority := range ca.intermediateAuthorities() {
+		err = ca.makeIntermediateAuthorityCertificate(authority)
+		if err != nil {
+			return err
+		}
+	}
+
+	if root {
+		err = ca.makeCertificateRevocationList("rootAuthority", false)
+		if err != nil {
+			return err
+		}
+	}
+	for _, authority := range ca.intermediateAuthorities() {
+		err = ca.makeCertificateRevocationList(authority, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Locks the directory of a leaf until the returned function is called, so that its key and CSR can be generated
+//without holding the mutex. A leaf's lock is always taken before the mutex.
+func (ca *CA) lockLeaf(directory string) func() {
+	ca.mutex.Lock()
+	mutex, found := ca.leafMutexes[directory]
+	if !found {
+		mutex = &sync.Mutex{}
+		ca.leafMutexes[directory] = mutex
+	}
+	ca.mutex.Unlock()
+
+	mutex.Lock()
+	return mutex.Unlock
+}
+
+//Returns true if the root authority is kept in Options.RootDirectory rather than in the output directory
+func (ca *CA) rootOffline() bool {
+	return filepath.Clean(ca.fragments["rootAuthorityDirectory"]) != filepath.Clean(ca.fragments["rootAuthorityPublicDirectory"])
+}
+
+//Returns an error unless the root authority can be used. An offline root directory is never created, so that a
+//missing removable drive is reported rather than a new root being made in its place, and once root.crt has been
+//published, the root directory must hold the key and certificate it was made from.
+func (ca *CA) checkRoot() error {
+	if !ca.rootOffline() {
+		return nil
+	}
+
+	directory := ca.fragments["rootAuthorityDirectory"]
+	info, err := os.Stat(directory)
+	if err != nil || !info.IsDir() {
+		return newError(InvalidError, "the root authority directory %s is not reachable, attach the root storage and try again", directory)
+	}
+
+	if !fileExists(ca.fragments["rootAuthorityCertificate"]) {
+		return nil
+	}
+	if !fileExists(ca.fragments["rootAuthorityPrivateKey"]) || !fileExists(ca.fragments["rootAuthoritySigningCertificate"]) {
+		return newError(InvalidError, "%s holds no root authority, attach the root storage that %s was made with", directory, ca.fragments["rootAuthorityCertificate"])
+	}
+
+	published, err := ioutil.ReadFile(ca.fragments["rootAuthorityCertificate"])
+	if err != nil {
+		return classify(err, IOError)
+	}
+	signing, err := ioutil.ReadFile(ca.fragments["rootAuthoritySigningCertificate"])
+	if err != nil {
+		return classify(err, IOError)
+	}
+	if !bytes.Equal(published, signing) {
+		return newError(InvalidError, "the root authority in %s is not the one whose certificate is %s", directory, ca.fragments["rootAuthorityCertificate"])
+	}
+	return nil
+}
+
+//Returns an error unless Init has created the root and default intermediate certificates
+func (ca *CA) CheckAuthorities() error {
+	for _, certificate := range []string{ca.fragments["rootAuthorityCertificate"], ca.fragments["intermediateAuthorityCertificate"]} {
+		if !fileExists(certificate) {
+			return newError(InvalidError, "%s does not exist, run init first", certificate)
+		}
+	}
+	return nil
+}
+
+//Regenerates the CRLs of the root and intermediate authorities, named ones included, once less than half of their
+//lifetime remains, or straight away with force. An offline root's CRL is left to UpdateRootCRL, and only a notice is printed when it is due.
+func (ca *CA) UpdateCRLs(force bool) error {
+	err := ca.CheckAuthorities()
+	if err != nil {
+		return err
+	}
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	if ca.rootOffline() {
+		halfway, err := ca.certificateRevocationListHalfway("rootAuthority")
+		if err != nil {
+			return err
+		}
+		if !time.Now().Before(halfway) {
+			fmt.Fprintln(ca.log, "Notice: the root CRL "+ca.fragments["rootAuthorityCRL"]+" is due to be regenerated, attach the root storage and update it")
+		}
+	} else {
+		err = ca.makeCertificateRevocationList("rootAuthority", force)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, authority := range ca.intermediateAuthorities() {
+		//A named intermediate listed in the options but not created yet has no CRL to update
+		if !fileExists(ca.fragments[authority+"Certificate"]) {
+			continue
+		}
+		err = ca.makeCertificateRevocationList(authority, force)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Regenerates the CRL of the root authority once less than half of its lifetime remains, or straight away with force.
+//Fails if the root is offline and its storage is not attached.
+func (ca *CA) UpdateRootCRL(force bool) error {
+	err := ca.CheckAuthorities()
+	if err != nil {
+		return err
+	}
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	err = ca.checkRoot()
+	if err != nil {
+		return err
+	}
+	return ca.makeCertificateRevocationList("rootAuthority", force)
+}
+
+//Marks the certificate of the intermediate authority called name, the default one when empty, as revoked in the root
+//authority's database, and regenerates the root's CRL. reason is an RFC 5280 reason such as keyCompromise, or empty.
+func (ca *CA) RevokeIntermediate(name, reason string) error {
+	err := ca.CheckAuthorities()
+	if err != nil {
+		return err
+	}
+	err = ca.checkIntermediate(name)
+	if err != nil {
+		return err
+	}
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	err = ca.checkRoot()
+	if err != nil {
+		return err
+	}
+	return ca.revokeIssuedCertificate("rootAuthority", ca.fragments[intermediateAuthority(name)+"Certificate"], reason)
+}
+
+//Writes a password protected PKCS#12 truststore to output, holding only the root certificate
+func (ca *CA) ExportTruststore(output, password, encryption string) error {
+	if !slices.Contains(PKCS12Encryptions, encryption) {
+		return newError(InvalidError, "unknown encryption %q, expected one of %s", encryption, strings.Join(PKCS12Encryptions, ", "))
+	}
+	if password == "" {
+		return newError(InvalidError, "a password for %s is required", output)
+	}
+
+	fmt.Fprintln(ca.log, "Generating PKCS#12 truststore: "+output)
+	return ca.backend.exportPKCS12(output, "", []string{ca.fragments["rootAuthorityCertificate"]}, ca.fragments["rootAuthorityCommonName"], password, encryption)
+}
+
+//Derives the paths of the root and intermediate authority files from the options
+func (ca *CA) initializeStringFragments() {
+	options := ca.options
+	//The built-in templates are read from the embedded templates directory
+	templatesDirectory := options.TemplatesDirectory
+	if templatesDirectory == "" {
+		templatesDirectory = "templates"
+	}
+
+	ca.fragments["rootAuthorityPrivateKeyFilename"] = "root.pem"
+	ca.fragments["serverPrivateKeyFilename"] = "server.pem"
+
+	ca.fragments["outputDirectory"] = options.OutputDirectory
+	ca.fragments["templatesDirectory"] = templatesDirectory
+	ca.fragments["serialNumberRecord"] = options.SerialNumberRecord
+	if ca.fragments["serialNumberRecord"] == "" {
+		ca.fragments["serialNumberRecord"] = options.OutputDirectory + "/serial_numbers.txt"
+	}
+
+	ca.fragments["rootAuthorityCommonName"] = options.Root.CommonName
+	ca.fragments["rootAuthorityValidityDays"] = strconv.Itoa(options.Root.ValidityDays)
+	ca.fragments["rootAuthorityKeyAlgorithm"] = options.Root.KeyAlgorithm
+	ca.fragments["rootAuthorityKeyEncryption"] = options.Root.KeyEncryption
+	ca.fragments["rootAuthorityPermittedNames"] = strings.Join(options.Root.PermittedNames, " ")
+	ca.fragments["rootAuthorityCRLValidityDays"] = strconv.Itoa(options.Root.CRLValidityDays)
+	ca.fragments["crlConfigTemplate"] = ca.fragments["templatesDirectory"] + "/make_crl.conf"
+	ca.fragments["ocspURL"] = options.OCSP.URL
+	ca.fragments["ocspAddToCertificates"] = strconv.FormatBool(options.OCSP.AddToCertificates)
+	ca.fragments["ocspResponderKeyAlgorithm"] = options.OCSP.KeyAlgorithm
+	ca.fragments["ocspResponderValidityDays"] = strconv.Itoa(options.OCSP.ValidityDays)
+	ca.fragments["publishURL"] = strings.TrimSuffix(options.Publish.URL, "/")
+	ca.fragments["publishAddToCertificates"] = strconv.FormatBool(options.Publish.AddToCertificates)
+
+	ca.fragments["rootAuthorityMakeInformationCSRConfigFilename"] = "make_root_information_csr.conf"
+	ca.fragments["rootAuthorityMakeCertificateFilename"] = "make_root_certificate.conf"
+	ca.fragments["rootAuthorityPublicDirectory"] = ca.fragments["outputDirectory"] + "/root_authority"
+	ca.fragments["rootAuthorityDirectory"] = ca.fragments["rootAuthorityPublicDirectory"]
+	if options.RootDirectory != "" {
+		ca.fragments["rootAuthorityDirectory"] = options.RootDirectory
+	}
+	ca.fragments["rootAuthorityPrivateKey"] = ca.fragments["rootAuthorityDirectory"] + "/" + ca.fragments["rootAuthorityPrivateKeyFilename"]
+	ca.fragments["rootAuthorityDatabaseFilename"] = "root_database.txt"
+	ca.fragments["rootAuthoritySerialNumberFilename"] = "root_serial_number.txt"
+	ca.fragments["rootAuthorityCertificateFilename"] = "root.crt"
+	ca.fragments["rootAuthorityMakeCertificateConfiguration"] = ca.fragments["rootAuthorityDirectory"] + "/" + ca.fragments["rootAuthorityMakeCertificateFilename"]
+	ca.fragments["rootAuthorityDatabase"] = ca.fragments["rootAuthorityDirectory"] + "/" + ca.fragments["rootAuthorityDatabaseFilename"]
+	ca.fragments["rootAuthoritySerialNumber"] = ca.fragments["rootAuthorityDirectory"] + "/" + ca.fragments["rootAuthoritySerialNumberFilename"]
+	ca.fragments["rootAuthorityConfigTemplate"] = ca.fragments["templatesDirectory"] + "/" + ca.fragments["rootAuthorityMakeCertificateFilename"]
+	//The root signs with the certificate next to its key, and everything else reads the copy published in the output directory
+	ca.fragments["rootAuthoritySigningCertificate"] = ca.fragments["rootAuthorityDirectory"] + "/" + ca.fragments["rootAuthorityCertificateFilename"]
+	ca.fragments["rootAuthorityCertificate"] = ca.fragments["rootAuthorityPublicDirectory"] + "/" + ca.fragments["rootAuthorityCertificateFilename"]
+	ca.fragments["rootAuthorityKeyAlgorithmRecord"] = ca.fragments["rootAuthorityDirectory"] + "/root_key_algorithm.txt"
+	ca.fragments["rootAuthorityCRLConfiguration"] = ca.fragments["rootAuthorityDirectory"] + "/make_root_crl.conf"
+	ca.fragments["rootAuthorityCRLNumber"] = ca.fragments["rootAuthorityDirectory"] + "/root_crl_number.txt"
+	ca.fragments["rootAuthorityCRL"] = ca.fragments["rootAuthorityPublicDirectory"] + "/root_crl.pem"
+	ca.fragments["rootAuthorityCRLDER"] = ca.fragments["rootAuthorityPublicDirectory"] + "/root_crl.der"
+	ca.fragments["rootCSR"] = ca.fragments["rootAuthorityDirectory"] + "/root.csr"
+	ca.fragments["rootAuthorityCSRConfig"] = ca.fragments["rootAuthorityDirectory"] + "/" + ca.fragments["rootAuthorityMakeInformationCSRConfigFilename"]
+
+	ca.fragments["intermediatesDirectory"] = ca.fragments["outputDirectory"] + "/intermediates"
+	ca.initializeIntermediateStringFragments("", options.Intermediate)
+	for _, intermediate := range ca.intermediates {
+		ca.initializeIntermediateStringFragments(intermediate.Name, intermediate.AuthorityOptions)
+	}
+
+	ca.fragments["ocspResponderDirectory"] = ca.fragments["outputDirectory"] + "/ocsp_responder"
+	ca.fragments["ocspResponderPrivateKey"] = ca.fragments["ocspResponderDirectory"] + "/ocsp.pem"
+	ca.fragments["ocspResponderKeyAlgorithmRecord"] = ca.fragments["ocspResponderDirectory"] + "/ocsp_key_algorithm.txt"
+	ca.fragments["ocspResponderCSR"] = ca.fragments["ocspResponderDirectory"] + "/ocsp.csr"
+	ca.fragments["ocspResponderCSRConfig"] = ca.fragments["ocspResponderDirectory"] + "/make_ocsp_information_csr.conf"
+	ca.fragments["ocspResponderCSRConfigTemplate"] = ca.fragments["templatesDirectory"] + "/make_ocsp_information_csr.conf"
+	ca.fragments["ocspResponderConfig"] = ca.fragments["ocspResponderDirectory"] + "/make_ocsp_certificate.conf"
+	ca.fragments["ocspResponderConfigTemplate"] = ca.fragments["templatesDirectory"] + "/make_ocsp_certificate.conf"
+	ca.fragments["ocspResponderCertificate"] = ca.fragments["ocspResponderDirectory"] + "/ocsp.crt"
+
+	ca.fragments["clientsDirectory"] = ca.fragments["outputDirectory"] + "/clients"
+
+	ca.fragments["acmeDirectory"] = ca.fragments["outputDirectory"] + "/acme"
+	ca.fragments["acmeAccounts"] = ca.fragments["acmeDirectory"] + "/accounts.json"
+	ca.fragments["acmeCertificateAccounts"] = ca.fragments["acmeDirectory"] + "/certificate_accounts.json"
+	ca.fragments["acmeCertificatesDirectory"] = ca.fragments["acmeDirectory"] + "/certificates"
+	ca.fragments["acmeCertificateConfigFilename"] = "make_server_certificate.conf"
+	ca.fragments["acmeCertificateConfigTemplate"] = ca.fragments["templatesDirectory"] + "/" + ca.fragments["acmeCertificateConfigFilename"]
+}
+
+//Generates the output directory and the directories of the root and intermediate authorities. An offline root's
+//directory is left to checkRoot, and only the directory its certificate and CRL are published in is generated.
+func (ca *CA) makeDirectories() error {
+	//1)Ensure the output directory and the authority directories always exist
+	directories := []string{ca.fragments["outputDirectory"], ca.fragments["rootAuthorityPublicDirectory"]}
+	for _, authority := range ca.intermediateAuthorities() {
+		directories = append(directories, ca.fragments[authority+"Directory"])
+	}
+	for _, directory := range directories {
+		if !fileExists(directory) {
+			fmt.Fprintln(ca.log, "Generating directory: "+directory)
+			err := os.MkdirAll(directory, 0700)
+			if err != nil {
+				return classify(err, IOError)
+			}
+		}
+	}
+	return nil
+}
+
+//Generates the private keys of the intermediate authorities, and of the root authority when root is set
+func (ca *CA) makePrivateKeys(root bool) error {
+	//2)Create a root authority private key if it doesn't already exist. Do not replace an existing one
+	//openssl genpkey -outform pem -out root.pem -algorithm rsa
+	if root {
+		fmt.Fprintln(ca.log, "Root private key: "+ca.fragments["rootAuthorityPrivateKey"])
+		err := ca.makePrivateKey(ca.fragments["rootAuthorityPrivateKey"], ca.fragments["rootAuthorityKeyAlgorithm"], ca.fragments["rootAuthorityKeyEncryption"], ca.fragments["rootAuthorityKeyAlgorithmRecord"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//3)Create the intermediate authority private keys
+	for _, authority := range ca.intermediateAuthorities() {
+		fmt.Fprintln(ca.log, "Intermediate private key: "+ca.fragments[authority+"PrivateKey"])
+		err := ca.makePrivateKey(ca.fragments[authority+"PrivateKey"], ca.fragments[authority+"KeyAlgorithm"], ca.fragments[authority+"KeyEncryption"], ca.fragments[authority+"KeyAlgorithmRecord"])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Generates privateKey with algorithm if it doesn't already exist, encrypted with one of the KeyEncryptions, and writes the
+//algorithm to algorithmRecord. An existing key is never replaced. If it was recorded with a different algorithm than the
+//one requested, a notice is printed.
+func (ca *CA) makePrivateKey(privateKey, algorithm, encryption, algorithmRecord string) error {
+	if fileExists(privateKey) {
+		recordedAlgorithm, err := ioutil.ReadFile(algorithmRecord)
+		if err == nil && strings.TrimSpace(string(recordedAlgorithm)) != algorithm {
+			fmt.Fprintln(ca.log, "Keeping existing "+strings.TrimSpace(string(recordedAlgorithm))+" key "+privateKey+" instead of generating a "+algorithm+" key. Delete it to switch algorithms.")
+		}
+		return nil
+	}
+
+	fmt.Fprintln(ca.log, "Generating "+algorithm+" private key: "+privateKey)
+	err := ca.generatePrivateKey(privateKey, algorithm, encryption)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(algorithmRecord, []byte(algorithm+"\n"), 0644)
+	if err != nil {
+		return newError(IOError, "recording the key algorithm in %s: %w", algorithmRecord, err)
+	}
+	return nil
+}
+
+//template: a template openssl configuration file, read from the embedded templates unless a templates directory was given
+//output: the output file that will be guaranteed to exist. It holds the template with its verbs filled in
+//args: the values of the template's verbs, in order
+func (ca *CA) hydrateTemplate(template, output string, args ...any) error {
+	fmt.Fprintln(ca.log, "Hydrating ", template, " into ", output)
+	var contentAsBytes []byte
+	var err error
+	if ca.options.TemplatesDirectory == "" {
+		contentAsBytes, err = templates.ReadFile(template)
+	} else {
+		contentAsBytes, err = ioutil.ReadFile(template)
+	}
+	if err != nil {
+		return newError(TemplateError, "reading template %s: %w", template, err)
+	}
+
+	//The contents of the template need to be altered to match the input domain name
+	newFileContents := fmt.Sprintf(string(contentAsBytes), args...)
+	//fmt marks missing and extra values with %!, which is never part of an openssl configuration
+	if strings.Contains(newFileContents, "%!") {
+		return newError(TemplateError, "template %s doesn't take the %d values filled into it, compare it with the built-in template", template, len(args))
+	}
+
+	err = ioutil.WriteFile(output, []byte(newFileContents), 0644)
+	if err != nil {
+		return newError(IOError, "writing %s: %w", output, err)
+	}
+	return nil
+}
+
+//Generates the CSR and the certificate of an intermediate authority, signed by the root. authority is the prefix
+//of the intermediate authority's string fragments.
+func (ca *CA) makeIntermediateAuthorityCertificate(authority string) error {
+	if !fileExists(ca.fragments[authority+"MakeInformationCSRConfig"]) {
+		err := ca.hydrateTemplate(ca.fragments[authority+"MakeInformationCSRConfigTemplate"], ca.fragments[authority+"MakeInformationCSRConfig"], ca.fragments[authority+"CommonName"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//Generate the intermediate authority CSR if it doesn't already exist
+	if !fileExists(ca.fragments[authority+"CSR"]) {
+		fmt.Fprintln(ca.log, "Generating intermediate CSR.") //This is the request from the intermediate authority to the root authority to sign its certificate
+		err := ca.generateCertificateSigningRequest(ca.fragments[authority+"PrivateKey"], ca.fragments[authority+"CSR"], ca.fragments[authority+"MakeInformationCSRConfig"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//ensure the openssl configuration file for making the intermediate authority certificate is present
+	if !fileExists(ca.fragments[authority+"MakeCertificateConfiguration"]) {
+		err := ca.hydrateTemplate(
+			ca.fragments[authority+"ConfigTemplate"],
+			ca.fragments[authority+"MakeCertificateConfiguration"],
+			//The root signs the intermediate, so the intermediate is recorded in the root's database,
+			//where it can be revoked and listed in the root's CRL
+			ca.fragments["rootAuthorityDatabase"],
+			ca.fragments["rootAuthoritySerialNumber"],
+			ca.fragments[authority+"ValidityDays"],
+			strings.TrimSpace(nameConstraintsLine(ca.fragments[authority+"PermittedNames"])+"\n"+ca.distributionExtensionLines("root", false)))
+		if err != nil {
+			return err
+		}
+	}
+
+	//An existing intermediate certificate is kept, so that the server certificates it signed stay valid
+	if !fileExists(ca.fragments[authority+"Certificate"]) {
+		fmt.Fprintln(ca.log, "Generating intermediate certificate")
+		fmt.Fprintln(ca.log, "Inside makeIntermediateAuthorityCertificate")
+		fmt.Fprintln(ca.log, ca.fragments[authority+"CSR"], ca.fragments[authority+"Certificate"], ca.fragments[authority+"MakeCertificateConfiguration"], ca.fragments["rootAuthorityPrivateKey"], ca.fragments["rootAuthoritySigningCertificate"], ca.fragments[authority+"Directory"])
+		return ca.generateSignedCertificate(ca.fragments[authority+"CSR"], ca.fragments[authority+"Certificate"], ca.fragments[authority+"MakeCertificateConfiguration"], ca.fragments["rootAuthorityPrivateKey"], ca.fragments["rootAuthoritySigningCertificate"], ca.fragments[authority+"Directory"])
+	}
+	return nil
+}
+
+func (ca *CA) makeRootAuthorityCertificate() error {
+	//Stage 4
+	//1)Generate root certificate
+	//2)Generate intermediate certificate
+	//3)Generate server certificate
+	if !fileExists(ca.fragments["rootCSR"]) {
+		fmt.Fprintln(ca.log, "Generating root CSR: ", ca.fragments["rootCSR"])
+
+		if !fileExists(ca.fragments["rootAuthorityCSRConfig"]) {
+			//Copy the config file over from the templates directory to the directoryNameDirectory if it doesn't exist
+			templatesDirectoryRootAuthorityCSRConfig := ca.fragments["templatesDirectory"] + "/" + ca.fragments["rootAuthorityMakeInformationCSRConfigFilename"]
+			err := ca.hydrateTemplate(templatesDirectoryRootAuthorityCSRConfig, ca.fragments["rootAuthorityCSRConfig"], ca.fragments["rootAuthorityCommonName"])
+			if err != nil {
+				return err
+			}
+		}
+		err := ca.generateCertificateSigningRequest(ca.fragments["rootAuthorityPrivateKey"], ca.fragments["rootCSR"], ca.fragments["rootAuthorityCSRConfig"])
+		if err != nil {
+			return err
+		}
+	}
+
+	if !fileExists(ca.fragments["rootAuthoritySigningCertificate"]) {
+		if !fileExists(ca.fragments["rootAuthorityMakeCertificateConfiguration"]) {
+			fmt.Fprintln(ca.log, "Copying root authority certificate generation configuration from ", ca.fragments["rootAuthorityConfigTemplate"], " to ", ca.fragments["rootAuthorityMakeCertificateConfiguration"])
+
+			err := ca.hydrateTemplate(ca.fragments["rootAuthorityConfigTemplate"], ca.fragments["rootAuthorityMakeCertificateConfiguration"], ca.fragments["rootAuthorityDatabase"], ca.fragments["rootAuthoritySerialNumber"], ca.fragments["rootAuthorityValidityDays"], nameConstraintsLine(ca.fragments["rootAuthorityPermittedNames"]))
+			if err != nil {
+				return err
+			}
+		}
+
+		fmt.Fprintln(ca.log, "Generating root certificate: ", ca.fragments["rootAuthoritySigningCertificate"])
+		err := ca.generateSelfSignedCertificate(ca.fragments["rootAuthorityPrivateKey"], ca.fragments["rootAuthorityMakeCertificateConfiguration"], ca.fragments["rootAuthoritySigningCertificate"], ca.fragments["rootCSR"], ca.fragments["rootAuthorityDirectory"])
+		if err != nil {
+			return err
+		}
+	}
+
+	//An offline root's certificate is copied into the output directory, where servers, clients and trust stores find it
+	if ca.rootOffline() && !fileExists(ca.fragments["rootAuthorityCertificate"]) {
+		fmt.Fprintln(ca.log, "Publishing root certificate: "+ca.fragments["rootAuthorityCertificate"])
+		certificate, err := ioutil.ReadFile(ca.fragments["rootAuthoritySigningCertificate"])
+		if err != nil {
+			return classify(err, IOError)
+		}
+		return classify(ioutil.WriteFile(ca.fragments["rootAuthorityCertificate"], certificate, 0644), IOError)
+	}
+	return nil
+}
+
+//Writes the CRL of an authority in PEM and DER form. authority is the prefix of the authority's string fragments,
+//rootAuthority or that of an intermediate authority. Unless force is set, a CRL is only regenerated once less than half of its lifetime remains.
+//The caller holds the mutex.
+func (ca *CA) makeCertificateRevocationList(authority string, force bool) error {
+	if !fileExists(ca.fragments[authority+"CRLConfiguration"]) {
+		err := ca.hydrateTemplate(ca.fragments["crlConfigTemplate"], ca.fragments[authority+"CRLConfiguration"], ca.fragments[authority+"Database"], ca.fragments[authority+"CRLNumber"], ca.fragments[authority+"CRLValidityDays"])
+		if err != nil {
+			return err
+		}
+	}
+
+	if !force {
+		halfway, err := ca.certificateRevocationListHalfway(authority)
+		if err != nil {
+			return err
+		}
+		if time.Now().Before(halfway) {
+			fmt.Fprintln(ca.log, "Keeping CRL "+ca.fragments[authority+"CRL"]+" until "+halfway.Format(time.RFC3339))
+			return nil
+		}
+	}
+
+	fmt.Fprintln(ca.log, "Generating CRL: "+ca.fragments[authority+"CRL"])
+	err := ca.backend.generateCertificateRevocationList(ca.fragments[authority+"CRLConfiguration"], ca.fragments[authority+"PrivateKey"], ca.fragments[authority+"SigningCertificate"], ca.fragments[authority+"CRL"])
+	if err != nil {
+		return err
+	}
+
+	der, err := readPEM(ca.fragments[authority+"CRL"], "X509 CRL")
+	if err != nil {
+		return err
+	}
+	return classify(ioutil.WriteFile(ca.fragments[authority+"CRLDER"], der, 0644), IOError)
+}
+
+//Returns the time halfway through the lifetime of the CRL of authority, when it is due to be regenerated,
+//or the zero time if the CRL doesn't exist yet
+func (ca *CA) certificateRevocationListHalfway(authority string) (time.Time, error) {
+	if !fileExists(ca.fragments[authority+"CRL"]) || !fileExists(ca.fragments[authority+"CRLDER"]) {
+		return time.Time{}, nil
+	}
+
+	der, err := readPEM(ca.fragments[authority+"CRL"], "X509 CRL")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	existing, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return time.Time{}, newError(IOError, "%s: %w", ca.fragments[authority+"CRL"], err)
+	}
+	return existing.ThisUpdate.Add(existing.NextUpdate.Sub(existing.ThisUpdate) / 2), nil
+}
+
+//Marks certificate as revoked for reason in the database of authority, rootAuthority or that of an intermediate authority,
+//and regenerates the authority's CRL. The caller holds the mutex.
+func (ca *CA) revokeIssuedCertificate(authority, certificate, reason string) error {
+	if reason != "" {
+		name, err := revocationReasonName(reason)
+		if err != nil {
+			return err
+		}
+		reason = name
+	}
+
+	//Revocation uses the same configuration as CRL generation, which points at the authority's database
+	if !fileExists(ca.fragments[authority+"CRLConfiguration"]) {
+		err := ca.hydrateTemplate(ca.fragments["crlConfigTemplate"], ca.fragments[authority+"CRLConfiguration"], ca.fragments[authority+"Database"], ca.fragments[authority+"CRLNumber"], ca.fragments[authority+"CRLValidityDays"])
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(ca.log, "Revoking certificate: "+certificate)
+	err := ca.backend.revokeCertificate(certificate, reason, ca.fragments[authority+"CRLConfiguration"], ca.fragments[authority+"PrivateKey"], ca.fragments[authority+"SigningCertificate"])
+	if err != nil {
+		return err
+	}
+	return ca.makeCertificateRevocationList(authority, true)
+}
+
+//Renders the optional lines of the x509_extensions section of the configuration of a server issued by authority,
+//the prefix of the intermediate authority's string fragments
+func (ca *CA) serverExtensionLines(authority string) string {
+	return ca.distributionExtensionLines(ca.fragments[authority+"PublicationName"], ca.ocspCovers(authority))
+}
+
+//Renders the optional lines of the x509_extensions section of the configuration of a client issued by authority.
+//A client certificate only has subject alternative names when email addresses or URIs were given, in an [altNames]
+//section that ends the file.
+func (ca *CA) clientExtensionLines(authority string, names []string) string {
+	lines := ca.distributionExtensionLines(ca.fragments[authority+"PublicationName"], ca.ocspCovers(authority))
+	if len(names) > 0 {
+		lines += "\nsubjectAltName=@altNames\n\n[altNames]\n" + formatSubjectAlternativeNames(names)
+	}
+	return lines
+}
+
+//Returns true if certificates issued by authority get the OCSP responder's URL. The responder only answers
+//for the default intermediate authority.
+func (ca *CA) ocspCovers(authority string) bool {
+	if ca.fragments["ocspAddToCertificates"] != "true" {
+		return false
+	}
+	if authority != intermediateAuthority("") {
+		fmt.Fprintln(ca.log, "Leaving out the OCSP URL, since the OCSP responder only answers for the default intermediate authority")
+		return false
+	}
+	return true
+}
+
+//Renders the authorityInfoAccess and crlDistributionPoints lines of a certificate issued by issuer, the name of an
+//authority on the publication server such as root, intermediate or intermediates/<name>.
+//They point at the OCSP responder when ocsp is set, and at the issuer's certificate and CRL on the publication server
+//when publishAddToCertificates is set. openssl rejects repeated extensions, so all access methods share one line.
+func (ca *CA) distributionExtensionLines(issuer string, ocsp bool) string {
+	accessMethods := []string{}
+	lines := []string{}
+	if ocsp {
+		accessMethods = append(accessMethods, "OCSP;URI:"+ca.fragments["ocspURL"])
+	}
+	if ca.fragments["publishAddToCertificates"] == "true" {
+		accessMethods = append(accessMethods, "caIssuers;URI:"+ca.fragments["publishURL"]+"/"+issuer+".crt")
+		lines = append(lines, "crlDistributionPoints=URI:"+ca.fragments["publishURL"]+"/"+issuer+".crl")
+	}
+	if len(accessMethods) > 0 {
+		lines = append([]string{"authorityInfoAccess=" + strings.Join(accessMethods, ",")}, lines...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+//Returns the handler of the publication server, which serves the DER encoded certificates and CRLs of the authorities
+//at root.crt, root.crl, intermediate.crt and intermediate.crl under the path of the publish url, and those of
+//the named intermediates at intermediates/<name>.crt and intermediates/<name>.crl
+func (ca *CA) PublicationHandler() (http.Handler, error) {
+	err := ca.CheckAuthorities()
+	if err != nil {
+		return nil, err
+	}
+
+	//The url was validated by New
+	publishURL, _ := url.Parse(ca.options.Publish.URL)
+	path := strings.TrimSuffix(publishURL.Path, "/")
+	mux := http.NewServeMux()
+	mux.Handle(path+"/root.crt", ca.certificateHandler("rootAuthority"))
+	mux.Handle(path+"/root.crl", ca.revocationListHandler("rootAuthority"))
+	for _, authority := range ca.intermediateAuthorities() {
+		mux.Handle(path+"/"+ca.fragments[authority+"PublicationName"]+".crt", ca.certificateHandler(authority))
+		mux.Handle(path+"/"+ca.fragments[authority+"PublicationName"]+".crl", ca.revocationListHandler(authority))
+	}
+	return mux, nil
+}
+
+//Serves the certificate of authority, the prefix of the string fragments of the root or an intermediate authority, in DER form as RFC 5280 expects of caIssuers
+func (ca *CA) certificateHandler(authority string) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		certificate, err := readCertificate(ca.fragments[authority+"Certificate"])
+		if err != nil {
+			fmt.Fprintln(ca.log, "Error:", err)
+			http.Error(writer, "certificate unavailable", http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprintln(ca.log, "Serving "+ca.fragments[authority+"Certificate"]+" to "+request.RemoteAddr)
+		writer.Header().Set("Content-Type", "application/pkix-cert")
+		writer.Write(certificate.Raw)
+	})
+}
+
+//Serves the DER form of the CRL of authority, first regenerating it if less than half of its lifetime remains.
+//CRLs are regenerated under the mutex, because requests are handled concurrently and the CRL files are rewritten in place.
+func (ca *CA) revocationListHandler(authority string) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		//An offline root's CRL is served as it is, since only UpdateRootCRL can sign a new one
+		ca.mutex.Lock()
+		var err error
+		if authority != "rootAuthority" || !ca.rootOffline() {
+			err = ca.makeCertificateRevocationList(authority, false)
+		}
+		var revocationList []byte
+		if err == nil {
+			revocationList, err = ioutil.ReadFile(ca.fragments[authority+"CRLDER"])
+		}
+		ca.mutex.Unlock()
+		if err != nil {
+			fmt.Fprintln(ca.log, "Error:", err)
+			http.Error(writer, "CRL unavailable", http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprintln(ca.log, "Serving "+ca.fragments[authority+"CRLDER"]+" to "+request.RemoteAddr)
+		writer.Header().Set("Content-Type", "application/pkix-crl")
+		writer.Write(revocationList)
+	})
+}
+
+//Makes the database file and serial number needed for the OpenSSL ca command for
+//the intermediate certificates, and for the root certificate when root is set
+func (ca *CA) makeDatabaseFiles(root bool) error {
+	type databaseFile struct {
+		description  string
+		filename     string
+		contents     string
+		serialNumber bool
+		root         bool
+	}
+	//Must also ensure the files referenced in the root authority configuration file exists.
+	//Serial number files need a hexadecimal number in them when initially created, although every certificate gets a
+	//random serial number written to the file just before it is signed. CRL numbers are counted from 01.
+	files := []databaseFile{
+		{"root database file", ca.fragments["rootAuthorityDatabase"], "", false, true},
+		{"root serial number file", ca.fragments["rootAuthoritySerialNumber"], "", true, true},
+		{"CRL number file", ca.fragments["rootAuthorityCRLNumber"], "01\n", false, true},
+	}
+	for _, authority := range ca.intermediateAuthorities() {
+		files = append(files,
+			databaseFile{"intermediate database file", ca.fragments[authority+"Database"], "", false, false},
+			databaseFile{"intermediate serial number file", ca.fragments[authority+"SerialNumber"], "", true, false},
+			databaseFile{"CRL number file", ca.fragments[authority+"CRLNumber"], "01\n", false, false})
+	}
+
+	for _, file := range files {
+		if file.root && !root {
+			continue
+		}
+		if !fileExists(file.filename) {
+			fmt.Fprintln(ca.log, "Generating "+file.description+":"+file.filename)
+			if file.serialNumber {
+				serialNumber, err := randomSerialNumber()
+				if err != nil {
+					return newError(SigningError, "generating a serial number: %w", err)
+				}
+				file.contents = serialNumberHex(serialNumber) + "\n"
+			}
+			err := ioutil.WriteFile(file.filename, []byte(file.contents), 0644)
+			if err != nil {
+				return newError(IOError, "creating the %s %s: %w", file.description, file.filename, err)
+			}
+		}
+	}
+	return nil
+}
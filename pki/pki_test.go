@@ -0,0 +1,112 @@
+package pki
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//Returns a CA with ECDSA keys, which are quick to generate, in a temporary directory. configure may change the
+//options before the CA is created and initialized.
+func newTestCA(t *testing.T, configure func(*Options)) *CA {
+	t.Helper()
+	options := DefaultOptions()
+	options.OutputDirectory = filepath.Join(t.TempDir(), "output")
+	options.Root.KeyAlgorithm = "ecdsa-p256"
+	options.Intermediate.KeyAlgorithm = "ecdsa-p256"
+	options.OCSP.KeyAlgorithm = "ecdsa-p256"
+	if configure != nil {
+		configure(&options)
+	}
+
+	ca, err := New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ca.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+//Returns a server for domain with an ECDSA key
+func newTestServer(domain string, names ...string) ServerOptions {
+	server := NewServerOptions(domain, names)
+	server.KeyAlgorithm = "ecdsa-p256"
+	return server
+}
+
+func TestCheckRoot(t *testing.T) {
+	rootDirectory := filepath.Join(t.TempDir(), "root")
+	err := os.Mkdir(rootDirectory, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := newTestCA(t, func(options *Options) { options.RootDirectory = rootDirectory })
+	if !ca.rootOffline() {
+		t.Fatal("a CA with a root directory doesn't keep its root offline")
+	}
+	err = ca.checkRoot()
+	if err != nil {
+		t.Fatalf("checkRoot with the root storage attached returned the error %v", err)
+	}
+
+	detached := rootDirectory + ".detached"
+	err = os.Rename(rootDirectory, detached)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ca.IssueServer(newTestServer("example.test"))
+	if err != nil {
+		t.Errorf("issuing a server without the root storage returned the error %v", err)
+	}
+	err = ca.UpdateCRLs(true)
+	if err != nil {
+		t.Errorf("updating the intermediate CRLs without the root storage returned the error %v", err)
+	}
+	for name, err := range map[string]error{
+		"checkRoot":          ca.checkRoot(),
+		"UpdateRootCRL":      ca.UpdateRootCRL(true),
+		"RevokeIntermediate": ca.RevokeIntermediate("", ""),
+	} {
+		if KindOf(err) != InvalidError {
+			t.Errorf("%s without the root storage returned the error %v, expected an InvalidError", name, err)
+		}
+	}
+
+	//A root directory that doesn't hold the root the certificates were made with
+	err = os.Mkdir(rootDirectory, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ca.checkRoot()
+	if KindOf(err) != InvalidError {
+		t.Errorf("checkRoot with an empty root directory returned the error %v, expected an InvalidError", err)
+	}
+
+	err = os.RemoveAll(rootDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Rename(detached, rootDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ca.UpdateRootCRL(true)
+	if err != nil {
+		t.Errorf("UpdateRootCRL with the root storage attached again returned the error %v", err)
+	}
+}
+
+func TestCheckRootOnline(t *testing.T) {
+	ca := newTestCA(t, nil)
+	if ca.rootOffline() {
+		t.Fatal("a CA without a root directory keeps its root offline")
+	}
+	err := ca.checkRoot()
+	if err != nil {
+		t.Errorf("checkRoot of an online root returned the error %v", err)
+	}
+}
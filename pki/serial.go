@@ -0,0 +1,150 @@
+package pki
+
+import (
+	"bufio"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//Wraps a backend so that every certificate it signs gets a random serial number, as the CA/Browser Forum requires,
+//instead of the next one in the authority's serial number file. Each serial number is written to the serial number
+//file just before signing, so both backends use it, and appended to the record afterwards. A serial number already
+//in the record or in the authority's database is never used again, so that a reset output directory sharing its record
+//can't issue a certificate with the issuer and serial number of an earlier one, which browsers reject.
+type serialNumberBackend struct {
+	certificateBackend
+	record string
+}
+
+func (backend serialNumberBackend) generateSelfSignedCertificate(privateKey, configuration, outputCertificate, certificateSigningRequest, outputDirectory string) error {
+	err := backend.assignSerialNumber(configuration)
+	if err != nil {
+		return err
+	}
+
+	err = backend.certificateBackend.generateSelfSignedCertificate(privateKey, configuration, outputCertificate, certificateSigningRequest, outputDirectory)
+	if err != nil {
+		return err
+	}
+	return backend.recordSerialNumber(outputCertificate)
+}
+
+func (backend serialNumberBackend) generateSignedCertificate(certificateSigningRequest, outputCertificate, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputDirectory string) error {
+	err := backend.assignSerialNumber(certificateAuthorityConfiguration)
+	if err != nil {
+		return err
+	}
+
+	err = backend.certificateBackend.generateSignedCertificate(certificateSigningRequest, outputCertificate, certificateAuthorityConfiguration, certificateAuthoritySigningKey, certificateAuthorityCertificate, outputDirectory)
+	if err != nil {
+		return err
+	}
+	return backend.recordSerialNumber(outputCertificate)
+}
+
+//Writes a random serial number that hasn't been used before to the serial number file named by the configuration
+func (backend serialNumberBackend) assignSerialNumber(configuration string) error {
+	conf, err := readOpensslConfiguration(configuration)
+	if err != nil {
+		return err
+	}
+
+	caSection := conf.get("ca", "default_ca")
+	if caSection == "" {
+		return fmt.Errorf("%s: no default_ca in the [ca] section", configuration)
+	}
+
+	used, err := readSerialNumberRecord(backend.record)
+	if err != nil {
+		return err
+	}
+
+	entries, err := readDatabase(conf.get(caSection, "database"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, entry := range entries {
+		used[entry.serialNumber] = true
+	}
+
+	for {
+		serialNumber, err := randomSerialNumber()
+		if err != nil {
+			return err
+		}
+
+		if !used[serialNumberHex(serialNumber)] {
+			return ioutil.WriteFile(conf.get(caSection, "serial"), []byte(serialNumberHex(serialNumber)+"\n"), 0644)
+		}
+	}
+}
+
+//Appends the serial number, issuer and subject of a newly signed certificate to the record
+func (backend serialNumberBackend) recordSerialNumber(certificateFile string) error {
+	certificate, err := readCertificate(certificateFile)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(backend.record), 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(backend.record, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s\t%s\t%s\n", serialNumberHex(certificate.SerialNumber), opensslSubject(certificate.Issuer), opensslSubject(certificate.Subject))
+	return err
+}
+
+//Returns the serial numbers in a record, which has one tab separated line per certificate starting with its
+//serial number. A record that doesn't exist yet is empty.
+func readSerialNumberRecord(record string) (map[string]bool, error) {
+	used := map[string]bool{}
+	file, err := os.Open(record)
+	if errors.Is(err, os.ErrNotExist) {
+		return used, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		serialNumber, _, _ := strings.Cut(scanner.Text(), "\t")
+		if serialNumber != "" {
+			used[serialNumber] = true
+		}
+	}
+	return used, scanner.Err()
+}
+
+//The source of random serial numbers, replaced by tests
+var serialNumberSource io.Reader = rand.Reader
+
+//Returns a positive serial number made of 128 bits from the operating system's cryptographically secure random
+//number generator, which stays within the 20 octets RFC 5280 allows
+func randomSerialNumber() (*big.Int, error) {
+	limit := new(big.Int).Lsh(big.NewInt(1), 128)
+	for {
+		serialNumber, err := rand.Int(serialNumberSource, limit)
+		if err != nil {
+			return nil, err
+		}
+		if serialNumber.Sign() > 0 {
+			return serialNumber, nil
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package pki
+
+import (
+	"bytes"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRandomSerialNumber(t *testing.T) {
+	seen := map[string]bool{}
+	for range 1000 {
+		serialNumber, err := randomSerialNumber()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serialNumber.Sign() <= 0 || serialNumber.BitLen() > 128 {
+			t.Fatalf("randomSerialNumber returned %s, expected a positive number of at most 128 bits", serialNumber)
+		}
+
+		hex := serialNumberHex(serialNumber)
+		if seen[hex] {
+			t.Fatalf("randomSerialNumber returned %s twice", hex)
+		}
+		seen[hex] = true
+	}
+}
+
+func TestRandomSerialNumberSkipsZero(t *testing.T) {
+	defer func(source io.Reader) { serialNumberSource = source }(serialNumberSource)
+	serialNumberSource = bytes.NewReader(append(make([]byte, 16), bytes.Repeat([]byte{0xff}, 16)...))
+
+	serialNumber, err := randomSerialNumber()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+	if serialNumber.Cmp(expected) != 0 {
+		t.Errorf("randomSerialNumber returned %s, expected %s", serialNumber, expected)
+	}
+}
+
+func TestSerialNumberHex(t *testing.T) {
+	for value, expected := range map[int64]string{1: "01", 0xabc: "0ABC", 0x1000: "1000"} {
+		if hex := serialNumberHex(big.NewInt(value)); hex != expected {
+			t.Errorf("serialNumberHex(%d) returned %s, expected %s", value, hex, expected)
+		}
+	}
+}
+
+func TestReadSerialNumberRecord(t *testing.T) {
+	record := filepath.Join(t.TempDir(), "serial_numbers.txt")
+	used, err := readSerialNumberRecord(record)
+	if err != nil || len(used) != 0 {
+		t.Fatalf("readSerialNumberRecord of a missing record returned %v, %v, expected an empty record", used, err)
+	}
+
+	err = os.WriteFile(record, []byte("0A\t/CN=Root\t/CN=Intermediate\n\n0B\t/CN=Intermediate\t/CN=example.test\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, err = readSerialNumberRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(used) != 2 || !used["0A"] || !used["0B"] {
+		t.Errorf("readSerialNumberRecord returned %v, expected 0A and 0B", used)
+	}
+}
+
+//Writes an authority configuration in a temporary directory whose database lists databaseSerialNumber, and a record
+//listing recordSerialNumber. Returns the configuration, the serial number file and the record.
+func writeSerialNumberTestAuthority(t *testing.T, databaseSerialNumber, recordSerialNumber string) (string, string, string) {
+	t.Helper()
+	directory := t.TempDir()
+	configuration := filepath.Join(directory, "ca.cnf")
+	database := filepath.Join(directory, "index.txt")
+	serial := filepath.Join(directory, "serial.txt")
+	record := filepath.Join(directory, "serial_numbers.txt")
+
+	files := map[string]string{
+		configuration: "[ca]\ndefault_ca = CA_default\n\n[CA_default]\ndatabase = " + database + "\nserial = " + serial + "\n",
+		database:      "V\t360101000000Z\t\t" + databaseSerialNumber + "\tunknown\t/CN=example.test\n",
+		record:        recordSerialNumber + "\t/CN=Intermediate\t/CN=example.test\n",
+	}
+	for filename, contents := range files {
+		err := os.WriteFile(filename, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return configuration, serial, record
+}
+
+func TestAssignSerialNumberSkipsUsedSerialNumbers(t *testing.T) {
+	defer func(source io.Reader) { serialNumberSource = source }(serialNumberSource)
+
+	//Three candidates: the first is in the database, the second in the record and the third is unused
+	candidate := func(last byte) []byte { return append(make([]byte, 15), last) }
+	serialNumberSource = bytes.NewReader(slices.Concat(candidate(0x0a), candidate(0x0b), candidate(0x0c)))
+
+	configuration, serial, record := writeSerialNumberTestAuthority(t, "0A", "0B")
+	err := serialNumberBackend{record: record}.assignSerialNumber(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := os.ReadFile(serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(contents)) != "0C" {
+		t.Errorf("assignSerialNumber wrote %q, expected 0C", contents)
+	}
+}
+
+func TestAssignSerialNumberWithoutDatabase(t *testing.T) {
+	configuration, serial, record := writeSerialNumberTestAuthority(t, "0A", "0B")
+	err := os.Remove(filepath.Join(filepath.Dir(configuration), "index.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = serialNumberBackend{record: record}.assignSerialNumber(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(serial); err != nil {
+		t.Errorf("assignSerialNumber didn't write the serial number file: %v", err)
+	}
+}
+
+func TestAssignSerialNumberWithoutDefaultCA(t *testing.T) {
+	configuration := filepath.Join(t.TempDir(), "ca.cnf")
+	err := os.WriteFile(configuration, []byte("[req]\nprompt = no\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = serialNumberBackend{}.assignSerialNumber(configuration)
+	if err == nil || !strings.Contains(err.Error(), "no default_ca") {
+		t.Errorf("assignSerialNumber returned the error %v, expected a missing default_ca", err)
+	}
+}
@@ -0,0 +1,234 @@
+package pki
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+//Parses the subset of TOML used by pki.toml: key = value pairs holding strings, integers, booleans and arrays,
+//[table] headers and [[array]] headers. Tables are returned as map[string]any and arrays of tables as []map[string]any.
+func readToml(filename string) (map[string]any, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	document := map[string]any{}
+	table := document
+	lines := strings.Split(string(contents), "\n")
+	for index := 0; index < len(lines); index++ {
+		number := index + 1
+		line := strings.TrimSpace(stripTomlComment(lines[index]))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[[") && strings.HasSuffix(line, "]]") {
+			name := strings.TrimSpace(line[2 : len(line)-2])
+			existing, found := document[name]
+			tables, isArray := existing.([]map[string]any)
+			if found && !isArray {
+				return nil, fmt.Errorf("%s:%d: %s is already defined", filename, number, name)
+			}
+			table = map[string]any{}
+			document[name] = append(tables, table)
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if _, found := document[name]; found {
+				return nil, fmt.Errorf("%s:%d: %s is already defined", filename, number, name)
+			}
+			table = map[string]any{}
+			document[name] = table
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("%s:%d: expected key = value", filename, number)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		//Arrays may continue over several lines until the closing bracket
+		for strings.HasPrefix(value, "[") && !tomlArrayClosed(value) && index+1 < len(lines) {
+			index++
+			value += " " + strings.TrimSpace(stripTomlComment(lines[index]))
+		}
+
+		if _, duplicate := table[key]; duplicate {
+			return nil, fmt.Errorf("%s:%d: %s is already defined", filename, number, key)
+		}
+
+		table[key], err = parseTomlValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", filename, number, key, err)
+		}
+	}
+	return document, nil
+}
+
+//Calls visit with the index of every character of text that is outside of a quoted string, until visit returns false
+func visitTomlOutsideStrings(text string, visit func(index int) bool) {
+	var quote byte
+	for index := 0; index < len(text); index++ {
+		character := text[index]
+		switch {
+		case quote == '"' && character == '\\':
+			//Skip the escaped character so that \" does not end the string
+			index++
+		case quote != 0:
+			if character == quote {
+				quote = 0
+			}
+		case character == '"' || character == '\'':
+			quote = character
+		default:
+			if !visit(index) {
+				return
+			}
+		}
+	}
+}
+
+//Removes a # comment from line, leaving # characters inside strings alone
+func stripTomlComment(line string) string {
+	end := len(line)
+	visitTomlOutsideStrings(line, func(index int) bool {
+		if line[index] == '#' {
+			end = index
+			return false
+		}
+		return true
+	})
+	return line[:end]
+}
+
+//Returns true if every [ in value outside of strings has a matching ]
+func tomlArrayClosed(value string) bool {
+	depth := 0
+	visitTomlOutsideStrings(value, func(index int) bool {
+		switch value[index] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		}
+		return true
+	})
+	return depth == 0
+}
+
+func parseTomlValue(value string) (any, error) {
+	switch {
+	case value == "true":
+		return true, nil
+	case value == "false":
+		return false, nil
+	case strings.HasPrefix(value, "\""):
+		return strconv.Unquote(value)
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") || strings.Contains(value[1:len(value)-1], "'") {
+			return nil, fmt.Errorf("invalid literal string %s", value)
+		}
+		return value[1 : len(value)-1], nil
+	case strings.HasPrefix(value, "["):
+		if !strings.HasSuffix(value, "]") {
+			return nil, fmt.Errorf("unterminated array %s", value)
+		}
+		return parseTomlArray(strings.TrimSpace(value[1 : len(value)-1]))
+	}
+
+	number, err := strconv.ParseInt(strings.ReplaceAll(value, "_", ""), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported value %s", value)
+	}
+	return number, nil
+}
+
+//Parses the elements between the brackets of an array. A trailing comma is allowed.
+func parseTomlArray(elements string) ([]any, error) {
+	commas := []int{}
+	depth := 0
+	visitTomlOutsideStrings(elements, func(index int) bool {
+		switch elements[index] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				commas = append(commas, index)
+			}
+		}
+		return true
+	})
+
+	values := []any{}
+	start := 0
+	for _, end := range append(commas, len(elements)) {
+		element := strings.TrimSpace(elements[start:end])
+		start = end + 1
+		if element == "" {
+			if end == len(elements) {
+				//Empty array or trailing comma
+				continue
+			}
+			return nil, errors.New("empty array element")
+		}
+
+		value, err := parseTomlValue(element)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+//Copies the values of table into fields, which maps each allowed key to a *string, *int, *bool or *[]string.
+//Keys listed in nested are skipped because the caller decodes them. Any other key is an error.
+func decodeTomlTable(table map[string]any, context string, fields map[string]any, nested ...string) error {
+	for key, value := range table {
+		if slices.Contains(nested, key) {
+			continue
+		}
+
+		field, known := fields[key]
+		if !known {
+			return fmt.Errorf("%s: unknown key %s", context, key)
+		}
+
+		ok := true
+		switch field := field.(type) {
+		case *string:
+			*field, ok = value.(string)
+		case *bool:
+			*field, ok = value.(bool)
+		case *int:
+			var number int64
+			number, ok = value.(int64)
+			*field = int(number)
+		case *[]string:
+			var elements []any
+			elements, ok = value.([]any)
+			*field = nil
+			for _, element := range elements {
+				text, isString := element.(string)
+				ok = ok && isString
+				*field = append(*field, text)
+			}
+		}
+
+		if !ok {
+			return fmt.Errorf("%s: %s has the wrong type", context, key)
+		}
+	}
+	return nil
+}
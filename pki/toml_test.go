@@ -0,0 +1,134 @@
+package pki
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//Writes contents to a pki.toml in a temporary directory and parses it
+func readTomlString(t *testing.T, contents string) (map[string]any, error) {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "pki.toml")
+	err := os.WriteFile(filename, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readToml(filename)
+}
+
+func TestReadToml(t *testing.T) {
+	document, err := readTomlString(t, `
+#A comment
+output_directory = "output" #A comment after a value
+passphrase = 'C:\keys'
+days = 3_650
+encrypt = true
+url = "http://example.test/#not-a-comment"
+quoted = "a \" # b"
+names = [
+	"one", #A comment inside an array
+	"two",
+]
+nested = [[1, 2], ["]"]]
+empty = []
+
+[root]
+common_name = "Root"
+
+[[server]]
+name = "web"
+
+[[server]]
+name = "mail"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]any{
+		"output_directory": "output",
+		"passphrase":       `C:\keys`,
+		"days":             int64(3650),
+		"encrypt":          true,
+		"url":              "http://example.test/#not-a-comment",
+		"quoted":           `a " # b`,
+		"names":            []any{"one", "two"},
+		"nested":           []any{[]any{int64(1), int64(2)}, []any{"]"}},
+		"empty":            []any{},
+		"root":             map[string]any{"common_name": "Root"},
+		"server":           []map[string]any{{"name": "web"}, {"name": "mail"}},
+	}
+	if !reflect.DeepEqual(document, expected) {
+		t.Errorf("readToml returned\n%#v\nexpected\n%#v", document, expected)
+	}
+}
+
+func TestReadTomlErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		err      string
+	}{
+		{"duplicate key", "a = 1\na = 2\n", "pki.toml:2: a is already defined"},
+		{"duplicate table", "[root]\n[root]\n", "pki.toml:2: root is already defined"},
+		{"table then array of tables", "[server]\n[[server]]\n", "pki.toml:2: server is already defined"},
+		{"array of tables then table", "[[server]]\n[server]\n", "pki.toml:2: server is already defined"},
+		{"missing value", "a\n", "pki.toml:1: expected key = value"},
+		{"unsupported value", "a = 1.5\n", "pki.toml:1: a: unsupported value 1.5"},
+		{"bare word", "a = yes\n", "pki.toml:1: a: unsupported value yes"},
+		{"unterminated string", "a = \"b\n", "pki.toml:1: a:"},
+		{"invalid literal string", "a = 'b'c'\n", "pki.toml:1: a: invalid literal string"},
+		{"unterminated array", "a = [1,\n2\n", "pki.toml:1: a: unterminated array"},
+		{"empty array element", "a = [1,,2]\n", "pki.toml:1: a: empty array element"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := readTomlString(t, test.contents)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("readToml returned the error %v, expected one containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestDecodeTomlTable(t *testing.T) {
+	var (
+		name    string
+		days    int
+		encrypt bool
+		names   []string
+	)
+	fields := map[string]any{"name": &name, "days": &days, "encrypt": &encrypt, "names": &names}
+
+	table := map[string]any{"name": "web", "days": int64(90), "encrypt": true, "names": []any{"a", "b"}, "server": []map[string]any{}}
+	err := decodeTomlTable(table, "root", fields, "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "web" || days != 90 || !encrypt || !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("decodeTomlTable decoded %q, %d, %v, %q", name, days, encrypt, names)
+	}
+
+	tests := []struct {
+		table map[string]any
+		err   string
+	}{
+		{map[string]any{"unknown": "a"}, "root: unknown key unknown"},
+		{map[string]any{"server": []map[string]any{}}, "root: unknown key server"},
+		{map[string]any{"name": int64(1)}, "root: name has the wrong type"},
+		{map[string]any{"days": "90"}, "root: days has the wrong type"},
+		{map[string]any{"encrypt": "true"}, "root: encrypt has the wrong type"},
+		{map[string]any{"names": "a"}, "root: names has the wrong type"},
+		{map[string]any{"names": []any{"a", int64(1)}}, "root: names has the wrong type"},
+	}
+	for _, test := range tests {
+		err := decodeTomlTable(test.table, "root", fields)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("decodeTomlTable(%v) returned the error %v, expected %q", test.table, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+//A system-wide trust store: the directory its anchors are placed in, the command that rebuilds the bundle
+//from the anchors, and the bundle that clients read
+type systemTrustStore struct {
+	name    string
+	anchors string
+	refresh []string
+	bundle  string
+}
+
+var systemTrustStores = []systemTrustStore{
+	{"Debian", "/usr/local/share/ca-certificates", []string{"update-ca-certificates"}, "/etc/ssl/certs/ca-certificates.crt"},
+	{"RHEL", "/etc/pki/ca-trust/source/anchors", []string{"update-ca-trust", "extract"}, "/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem"},
+}
+
+//The outcome of Trust for one store. Failed is set when the store doesn't end up in the state the action asked for.
+type TrustResult struct {
+	Store    string
+	Location string
+	Status   string
+	Failed   bool
+}
+
+//Returns the NSS nickname and anchor filename of the root certificate. Both include part of the certificate's
+//fingerprint, so that the roots of several output directories can be trusted side by side.
+func rootTrustNames(root *x509.Certificate) (string, string) {
+	fingerprint := sha256.Sum256(root.Raw)
+	suffix := fmt.Sprintf("%x", fingerprint[:4])
+
+	filename := []rune{}
+	for _, character := range strings.ToLower(root.Subject.CommonName) {
+		if character >= 'a' && character <= 'z' || character >= '0' && character <= '9' {
+			filename = append(filename, character)
+		} else {
+			filename = append(filename, '_')
+		}
+	}
+	return root.Subject.CommonName + " " + suffix, string(filename) + "_" + suffix + ".crt"
+}
+
+//Returns true if a PEM bundle contains certificate
+func bundleContains(bundle string, certificate *x509.Certificate) bool {
+	data, err := ioutil.ReadFile(bundle)
+	if err != nil {
+		return false
+	}
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return false
+		}
+		if block.Type == "CERTIFICATE" && bytes.Equal(block.Bytes, certificate.Raw) {
+			return true
+		}
+	}
+}
+
+//Installs, uninstalls or checks the root in a system trust store
+func systemTrust(action string, store systemTrustStore, root *x509.Certificate, anchorFilename string) TrustResult {
+	result := TrustResult{Store: store.name + " system store", Location: store.bundle}
+	anchor := store.anchors + "/" + anchorFilename
+	if action != "status" {
+		if os.Geteuid() != 0 {
+			result.Status = "requires root, run again with sudo or pass -system=false"
+			result.Failed = true
+			return result
+		}
+
+		var err error
+		if action == "install" {
+			err = writePEM(anchor, "CERTIFICATE", root.Raw, 0644)
+		} else if fileExists(anchor) {
+			err = os.Remove(anchor)
+		}
+		if err == nil {
+			var output []byte
+			output, err = exec.Command(store.refresh[0], store.refresh[1:]...).CombinedOutput()
+			if err != nil {
+				err = fmt.Errorf("%s: %v: %s", strings.Join(store.refresh, " "), err, strings.TrimSpace(string(output)))
+			}
+		}
+		if err != nil {
+			result.Status = err.Error()
+			result.Failed = true
+			return result
+		}
+	}
+
+	trusted := bundleContains(store.bundle, root)
+	result.Status = "not trusted"
+	if trusted {
+		result.Status = "trusted"
+	}
+	result.Failed = trusted != (action != "uninstall") && action != "status"
+	return result
+}
+
+//Returns the NSS databases of the current user: the shared database used by Chrome and other programs,
+//and the database of every Firefox profile, in the sql: or legacy dbm: format
+func nssDatabases() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+
+	directories := []string{home + "/.pki/nssdb"}
+	for _, profiles := range []string{"/.mozilla/firefox", "/snap/firefox/common/.mozilla/firefox", "/.var/app/org.mozilla.firefox/.mozilla/firefox"} {
+		profileDirectories, _ := filepath.Glob(home + profiles + "/*")
+		directories = append(directories, profileDirectories...)
+	}
+
+	databases := []string{}
+	for _, directory := range directories {
+		if fileExists(directory + "/cert9.db") {
+			databases = append(databases, "sql:"+directory)
+		} else if fileExists(directory + "/cert8.db") {
+			databases = append(databases, "dbm:"+directory)
+		}
+	}
+	return databases
+}
+
+//Installs, uninstalls or checks the root in an NSS database with certutil
+func nssTrust(action, database, nickname, rootCertificate string) TrustResult {
+	result := TrustResult{Store: "NSS", Location: database}
+	present := func() bool {
+		return exec.Command("certutil", "-L", "-d", database, "-n", nickname).Run() == nil
+	}
+
+	var err error
+	switch action {
+	case "install":
+		if !present() {
+			var output []byte
+			output, err = exec.Command("certutil", "-A", "-d", database, "-t", "C,,", "-n", nickname, "-i", rootCertificate).CombinedOutput()
+			if err != nil {
+				err = fmt.Errorf("certutil: %v: %s", err, strings.TrimSpace(string(output)))
+			}
+		}
+	case "uninstall":
+		//A nickname can be attached to more than one certificate, so they are deleted until none is left
+		for attempt := 0; attempt < 10 && err == nil && present(); attempt++ {
+			var output []byte
+			output, err = exec.Command("certutil", "-D", "-d", database, "-n", nickname).CombinedOutput()
+			if err != nil {
+				err = fmt.Errorf("certutil: %v: %s", err, strings.TrimSpace(string(output)))
+			}
+		}
+	}
+	if err != nil {
+		result.Status = err.Error()
+		result.Failed = true
+		return result
+	}
+
+	trusted := present()
+	result.Status = "not trusted"
+	if trusted {
+		result.Status = "trusted"
+	}
+	result.Failed = trusted != (action != "uninstall") && action != "status"
+	return result
+}
+
+//Adds the root certificate to, removes it from, or checks it in the system trust store of Debian and RHEL style systems
+//with system, and the NSS databases of the current user used by Chrome and Firefox with nss. action is install,
+//uninstall or status. Returns the NSS nickname of the root and the outcome for each store.
+func (ca *CA) Trust(action string, system, nss bool) (string, []TrustResult, error) {
+	if action != "install" && action != "uninstall" && action != "status" {
+		return "", nil, newError(InvalidError, "unknown trust action %q, expected install, uninstall or status", action)
+	}
+
+	root, err := readCertificate(ca.fragments["rootAuthorityCertificate"])
+	if err != nil {
+		return "", nil, err
+	}
+	nickname, anchorFilename := rootTrustNames(root)
+
+	results := []TrustResult{}
+	if system {
+		found := false
+		for _, store := range systemTrustStores {
+			if fileExists(store.anchors) {
+				found = true
+				results = append(results, systemTrust(action, store, root, anchorFilename))
+			}
+		}
+		if !found {
+			results = append(results, TrustResult{Store: "system store", Status: "no supported trust store found", Failed: action != "status"})
+		}
+	}
+
+	if nss {
+		databases := nssDatabases()
+		_, err := exec.LookPath("certutil")
+		switch {
+		case len(databases) == 0:
+			results = append(results, TrustResult{Store: "NSS", Status: "no NSS databases found"})
+		case os.Getenv("SUDO_USER") != "" && action != "status":
+			//Running certutil as root would leave the user's databases owned by root
+			results = append(results, TrustResult{Store: "NSS", Status: "skipped under sudo, run again as " + os.Getenv("SUDO_USER") + " with -system=false"})
+		case err != nil:
+			results = append(results, TrustResult{Store: "NSS", Status: "certutil not found, install libnss3-tools or nss-tools", Failed: action != "status"})
+		default:
+			for _, database := range databases {
+				results = append(results, nssTrust(action, database, nickname, ca.fragments["rootAuthorityCertificate"]))
+			}
+		}
+	}
+	return nickname, results, nil
+}
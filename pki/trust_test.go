@@ -0,0 +1,83 @@
+package pki
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRootTrustNames(t *testing.T) {
+	tests := []struct {
+		commonName string
+		filename   string
+	}{
+		{"Root Authority Name", "root_authority_name"},
+		{"ACME Corp. Root CA 2026", "acme_corp__root_ca_2026"},
+		{"Raíz/../CA", "ra_z____ca"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		root := &x509.Certificate{Raw: []byte(test.commonName + " certificate"), Subject: pkix.Name{CommonName: test.commonName}}
+		fingerprint := sha256.Sum256(root.Raw)
+		suffix := fmt.Sprintf("%x", fingerprint[:4])
+
+		nickname, filename := rootTrustNames(root)
+		if nickname != test.commonName+" "+suffix || filename != test.filename+"_"+suffix+".crt" {
+			t.Errorf("rootTrustNames of %q returned %q and %q, expected %q and %q", test.commonName, nickname, filename, test.commonName+" "+suffix, test.filename+"_"+suffix+".crt")
+		}
+	}
+
+	//Roots with the same name are told apart by their fingerprint
+	_, first := rootTrustNames(&x509.Certificate{Raw: []byte("first"), Subject: pkix.Name{CommonName: "Root"}})
+	_, second := rootTrustNames(&x509.Certificate{Raw: []byte("second"), Subject: pkix.Name{CommonName: "Root"}})
+	if first == second {
+		t.Errorf("rootTrustNames returned %s for two different roots", first)
+	}
+}
+
+func TestBundleContains(t *testing.T) {
+	ca := newTestCA(t, nil)
+	root, err := readCertificate(ca.RootCertificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediate, err := readCertificate(ca.IntermediateCertificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootPEM, err := os.ReadFile(ca.RootCertificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediatePEM, err := os.ReadFile(ca.IntermediateCertificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	directory := t.TempDir()
+	bundle := filepath.Join(directory, "ca-certificates.crt")
+	//Bundles hold comments between certificates, and other blocks
+	contents := "# Intermediate\n" + string(intermediatePEM) + "-----BEGIN TRUSTED CERTIFICATE-----\n" + "AAAA\n" + "-----END TRUSTED CERTIFICATE-----\n" + "# Root\n" + string(rootPEM)
+	err = os.WriteFile(bundle, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bundleContains(bundle, root) || !bundleContains(bundle, intermediate) {
+		t.Errorf("bundleContains didn't find the certificates in %s", bundle)
+	}
+
+	err = os.WriteFile(bundle, intermediatePEM, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundleContains(bundle, root) {
+		t.Errorf("bundleContains found the root in a bundle without it")
+	}
+	if bundleContains(filepath.Join(directory, "missing.crt"), root) {
+		t.Errorf("bundleContains found the root in a missing bundle")
+	}
+}